@@ -0,0 +1,198 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+const (
+	// AnnotationCriuConfig is read by runc for the criu configuration file of checkpoint and restore.
+	AnnotationCriuConfig = "org.criu.config"
+	// CriuConfigFile is written into the bundle, it registers pod volume mounts as criu external mounts.
+	CriuConfigFile = "grit-criu.conf"
+)
+
+// podVolumeMounts returns bind mounts of pod volumes in the oci spec of bundle. runc registers every
+// bind mount as an external mount keyed by destination, pod volume mounts are registered with the
+// volume key instead, so they can be mapped to new mount sources when the pod uid is changed.
+func podVolumeMounts(bundle string) ([]metadata.MountEntry, error) {
+	data, err := os.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var s struct {
+		Mounts []struct {
+			Destination string   `json:"destination"`
+			Type        string   `json:"type,omitempty"`
+			Source      string   `json:"source,omitempty"`
+			Options     []string `json:"options,omitempty"`
+		} `json:"mounts,omitempty"`
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	var mounts []metadata.MountEntry
+	for _, m := range s.Mounts {
+		if m.Type != "bind" && !containsBindOption(m.Options) {
+			continue
+		}
+		if volumeKey := metadata.VolumeKey(m.Source); len(volumeKey) != 0 {
+			mounts = append(mounts, metadata.MountEntry{
+				Key:         volumeKey,
+				VolumeKey:   volumeKey,
+				Destination: m.Destination,
+				Source:      m.Source,
+			})
+		}
+	}
+	return mounts, nil
+}
+
+func containsBindOption(options []string) bool {
+	for _, o := range options {
+		if o == "bind" || o == "rbind" {
+			return true
+		}
+	}
+	return false
+}
+
+// enableCriuConfig sets AnnotationCriuConfig in the oci spec of bundle, so runc reads CriuConfigFile
+// for both checkpoint and restore. the annotation is kept if it has been specified by users.
+func enableCriuConfig(bundle string) (bool, error) {
+	configPath := filepath.Join(bundle, "config.json")
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return false, err
+	}
+
+	var s map[string]json.RawMessage
+	if err := json.Unmarshal(data, &s); err != nil {
+		return false, err
+	}
+
+	annotations := make(map[string]string)
+	if raw, ok := s["annotations"]; ok {
+		if err := json.Unmarshal(raw, &annotations); err != nil {
+			return false, err
+		}
+	}
+	if path, ok := annotations[AnnotationCriuConfig]; ok {
+		return path == filepath.Join(bundle, CriuConfigFile), nil
+	}
+
+	annotations[AnnotationCriuConfig] = filepath.Join(bundle, CriuConfigFile)
+	raw, err := json.Marshal(annotations)
+	if err != nil {
+		return false, err
+	}
+	s["annotations"] = raw
+	if data, err = json.Marshal(s); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(configPath, data, 0644)
+}
+
+func writeCriuConfig(bundle string, lines []string) error {
+	content := strings.Join(lines, "\n")
+	if len(lines) != 0 {
+		content += "\n"
+	}
+	return os.WriteFile(filepath.Join(bundle, CriuConfigFile), []byte(content), 0644)
+}
+
+// prepareCriuDump registers pod volume mounts as external mounts keyed by volume key, and records
+// them into mounts manifest in the criu image directory.
+func prepareCriuDump(bundle, imagePath string) error {
+	mounts, err := podVolumeMounts(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to read pod volume mounts: %w", err)
+	}
+
+	// criu-image-streamer is listening in the image directory, so criu images are streamed to it.
+	_, err = os.Stat(filepath.Join(imagePath, metadata.StreamerCaptureSocket))
+	stream := err == nil
+
+	if _, err := os.Stat(filepath.Join(bundle, CriuConfigFile)); os.IsNotExist(err) {
+		if stream {
+			return fmt.Errorf("criu images can't be streamed for container which is created before criu config is enabled")
+		}
+		// container is created before criu config is enabled, mounts are keyed by destination by runc.
+		for i := range mounts {
+			mounts[i].Key = mounts[i].Destination
+		}
+	} else {
+		var lines []string
+		for _, m := range mounts {
+			// for dumping, external mount is specified as mnt[<mountpoint>]:<key>
+			lines = append(lines, fmt.Sprintf("external mnt[%s]:%s", m.Destination, m.Key))
+		}
+		if stream {
+			lines = append(lines, "stream")
+		}
+		if err := writeCriuConfig(bundle, lines); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(imagePath, 0755); err != nil {
+		return err
+	}
+	return metadata.WriteMountsManifest(imagePath, &metadata.MountsManifest{Mounts: mounts})
+}
+
+// prepareCriuRestore maps external mounts in criu images to mount sources of the new container.
+// mounts are resolved by volume key first, and by destination for volumes whose names are generated,
+// like kube-api-access-xxxxx. restoring fails early if a volume can't be resolved. criu reads images
+// from criu-image-streamer if stream is true.
+func prepareCriuRestore(bundle, imagePath string, stream bool) error {
+	var lines []string
+	if stream {
+		lines = append(lines, "stream")
+	}
+
+	manifest, err := metadata.ReadMountsManifest(imagePath)
+	if os.IsNotExist(err) {
+		// checkpoint is created before mounts manifest is introduced, mounts are keyed by destination.
+		return writeCriuConfig(bundle, lines)
+	} else if err != nil {
+		return err
+	}
+
+	mounts, err := podVolumeMounts(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to read pod volume mounts: %w", err)
+	}
+	byVolumeKey := make(map[string]metadata.MountEntry, len(mounts))
+	byDestination := make(map[string]metadata.MountEntry, len(mounts))
+	for _, m := range mounts {
+		byVolumeKey[m.VolumeKey] = m
+		byDestination[m.Destination] = m
+	}
+
+	for _, ckptMount := range manifest.Mounts {
+		m, ok := byVolumeKey[ckptMount.VolumeKey]
+		if !ok {
+			if m, ok = byDestination[ckptMount.Destination]; !ok {
+				return fmt.Errorf("volume %s mounted at %s in checkpoint is not found in the restored container", ckptMount.VolumeKey, ckptMount.Destination)
+			}
+		}
+		if ckptMount.Key == ckptMount.Destination {
+			// runc maps mounts keyed by destination itself.
+			continue
+		}
+		// for restoring, external mount is specified as mnt[<key>]:<mount source>
+		lines = append(lines, fmt.Sprintf("external mnt[%s]:%s", ckptMount.Key, m.Source))
+	}
+	return writeCriuConfig(bundle, lines)
+}
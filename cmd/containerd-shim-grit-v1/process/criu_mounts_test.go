@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+func writeBundleConfig(t *testing.T, bundle, podUID string) {
+	config := fmt.Sprintf(`{"ociVersion":"1.0.2","mounts":[
+		{"destination":"/proc","type":"proc","source":"proc"},
+		{"destination":"/etc/config","type":"bind","source":"/var/lib/kubelet/pods/%[1]s/volumes/kubernetes.io~configmap/config","options":["rbind","ro"]},
+		{"destination":"/var/run/secrets/kubernetes.io/serviceaccount","type":"bind","source":"/var/lib/kubelet/pods/%[1]s/volumes/kubernetes.io~projected/kube-api-access-%[1]s","options":["rbind","ro"]}
+	]}`, podUID)
+	if err := os.WriteFile(filepath.Join(bundle, "config.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCriuExternalMounts(t *testing.T) {
+	srcBundle, dstBundle, imagePath := t.TempDir(), t.TempDir(), t.TempDir()
+	writeBundleConfig(t, srcBundle, "uid1")
+	writeBundleConfig(t, dstBundle, "uid2")
+
+	t.Run("enable criu config", func(t *testing.T) {
+		enabled, err := enableCriuConfig(srcBundle)
+		if err != nil || !enabled {
+			t.Fatalf("expected criu config enabled, got %v, %v", enabled, err)
+		}
+		data, _ := os.ReadFile(filepath.Join(srcBundle, "config.json"))
+		if !strings.Contains(string(data), AnnotationCriuConfig) || !strings.Contains(string(data), "ociVersion") {
+			t.Fatalf("unexpected config.json %s", string(data))
+		}
+		if err := writeCriuConfig(srcBundle, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("dump registers volume keys", func(t *testing.T) {
+		if err := prepareCriuDump(srcBundle, imagePath); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(filepath.Join(srcBundle, CriuConfigFile))
+		if !strings.Contains(string(data), "external mnt[/etc/config]:kubernetes.io~configmap/config\n") {
+			t.Fatalf("unexpected criu config %s", string(data))
+		}
+	})
+
+	t.Run("restore maps volume keys to new sources", func(t *testing.T) {
+		if err := prepareCriuRestore(dstBundle, imagePath, false); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(filepath.Join(dstBundle, CriuConfigFile))
+		for _, expected := range []string{
+			"external mnt[kubernetes.io~configmap/config]:/var/lib/kubelet/pods/uid2/volumes/kubernetes.io~configmap/config\n",
+			"external mnt[kubernetes.io~projected/kube-api-access-uid1]:/var/lib/kubelet/pods/uid2/volumes/kubernetes.io~projected/kube-api-access-uid2\n",
+		} {
+			if !strings.Contains(string(data), expected) {
+				t.Fatalf("expected %q in criu config %s", expected, string(data))
+			}
+		}
+	})
+
+	t.Run("images are streamed when criu-image-streamer is listening", func(t *testing.T) {
+		streamImagePath := t.TempDir()
+		if err := os.WriteFile(filepath.Join(streamImagePath, metadata.StreamerCaptureSocket), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := prepareCriuDump(srcBundle, streamImagePath); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(filepath.Join(srcBundle, CriuConfigFile))
+		if !strings.Contains(string(data), "\nstream\n") {
+			t.Fatalf("expected stream in criu config %s", string(data))
+		}
+
+		if err := prepareCriuRestore(dstBundle, streamImagePath, true); err != nil {
+			t.Fatal(err)
+		}
+		data, _ = os.ReadFile(filepath.Join(dstBundle, CriuConfigFile))
+		if !strings.HasPrefix(string(data), "stream\n") {
+			t.Fatalf("expected stream in criu config %s", string(data))
+		}
+	})
+
+	t.Run("restore fails for unresolved volume", func(t *testing.T) {
+		bundle := t.TempDir()
+		if err := os.WriteFile(filepath.Join(bundle, "config.json"), []byte(`{"mounts":[]}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := prepareCriuRestore(bundle, imagePath, false); err == nil {
+			t.Fatal("expected error for unresolved volume")
+		}
+	})
+}
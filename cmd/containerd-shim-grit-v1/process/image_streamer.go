@@ -0,0 +1,71 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/log"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+// imageStreamerReadyTimeout is the max duration for waiting criu-image-streamer to create the serve socket.
+const imageStreamerReadyTimeout = 30 * time.Second
+
+// serveImages runs criu-image-streamer in serve mode, criu restore in stream mode reads criu images from the
+// stream through the socket in images dir. the streamer exits after all images are read by criu, and the
+// returned function is used for stopping it when restoration failed.
+func serveImages(ctx context.Context, imagesDir, streamPath string) (func(), error) {
+	stream, err := os.Open(streamPath)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	// the streamer outlives the request of starting the container, so it's not bound to the request context.
+	cmd := exec.Command(metadata.ImageStreamerBinary, "--images-dir", imagesDir, "serve")
+	cmd.Stdin = stream
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", metadata.ImageStreamerBinary, err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		if err := cmd.Wait(); err != nil {
+			log.G(ctx).WithError(err).Errorf("%s for %s exited", metadata.ImageStreamerBinary, imagesDir)
+			return
+		}
+		log.G(ctx).Infof("criu images in %s are served", streamPath)
+	}()
+	stop := func() {
+		select {
+		case <-exited:
+		default:
+			cmd.Process.Kill()
+			<-exited
+		}
+	}
+
+	readyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-exited:
+			cancel()
+		case <-readyCtx.Done():
+		}
+	}()
+	if err := metadata.WaitForStreamerSocket(readyCtx, filepath.Join(imagesDir, metadata.StreamerServeSocket), imageStreamerReadyTimeout); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
@@ -126,9 +126,25 @@ func (p *Init) Create(ctx context.Context, r *CreateConfig) error {
 		}
 		p.io = pio
 	}
+	criuConfigEnabled, err := enableCriuConfig(p.Bundle)
+	if err != nil {
+		return fmt.Errorf("failed to enable criu config: %w", err)
+	}
 	if r.Checkpoint != "" {
+		if criuConfigEnabled {
+			if err := prepareCriuRestore(p.Bundle, r.Checkpoint, len(r.ImagesStream) != 0); err != nil {
+				return fmt.Errorf("failed to prepare criu external mounts: %w", err)
+			}
+		} else if len(r.ImagesStream) != 0 {
+			return fmt.Errorf("container can't be restored from criu images stream without criu config")
+		}
 		return p.createCheckpointedState(r, pidFile)
 	}
+	if criuConfigEnabled {
+		if err := writeCriuConfig(p.Bundle, nil); err != nil {
+			return fmt.Errorf("failed to write criu config: %w", err)
+		}
+	}
 	opts := &runc.CreateOpts{
 		PidFile:      pidFile.Path(),
 		NoPivot:      p.NoPivotRoot,
@@ -196,14 +212,17 @@ func (p *Init) createCheckpointedState(r *CreateConfig, pidFile *pidFile) error
 		Detach:      true,
 		NoSubreaper: true,
 	}
+	opts.LazyPages = len(r.LazyPageServer) != 0
 
 	if p.io != nil {
 		opts.IO = p.io.IO()
 	}
 
 	p.initState = &createdCheckpointState{
-		p:    p,
-		opts: opts,
+		p:              p,
+		opts:           opts,
+		lazyPageServer: r.LazyPageServer,
+		imagesStream:   r.ImagesStream,
 	}
 	return nil
 }
@@ -433,6 +452,11 @@ func (p *Init) checkpoint(ctx context.Context, r *CheckpointConfig) error {
 		work = filepath.Join(p.WorkDir, "criu-work")
 		defer os.RemoveAll(work)
 	}
+	if r.Path != "" {
+		if err := prepareCriuDump(p.Bundle, r.Path); err != nil {
+			return fmt.Errorf("failed to prepare criu external mounts: %w", err)
+		}
+	}
 	if err := p.runtime.Checkpoint(ctx, p.id, &runc.CheckpointOpts{
 		WorkDir:                  work,
 		ImagePath:                r.Path,
@@ -112,6 +112,10 @@ func (s *createdState) Status(ctx context.Context) (string, error) {
 type createdCheckpointState struct {
 	p    *Init
 	opts *runc.RestoreOpts
+	// lazyPageServer is the address of criu page server which serves memory pages for lazy restore.
+	lazyPageServer string
+	// imagesStream is the stream of criu images which is served by criu-image-streamer for restoring.
+	imagesStream string
 }
 
 func (s *createdCheckpointState) transition(name string) error {
@@ -144,7 +148,7 @@ func (s *createdCheckpointState) Checkpoint(ctx context.Context, r *CheckpointCo
 	return errors.New("cannot checkpoint a task in created state")
 }
 
-func (s *createdCheckpointState) Start(ctx context.Context) error {
+func (s *createdCheckpointState) Start(ctx context.Context) (retErr error) {
 	p := s.p
 	sio := p.stdio
 
@@ -160,6 +164,30 @@ func (s *createdCheckpointState) Start(ctx context.Context) error {
 		s.opts.ConsoleSocket = socket
 	}
 
+	if len(s.lazyPageServer) != 0 {
+		stopLazyPages, err := startLazyPagesDaemon(ctx, s.opts.ImagePath, s.opts.WorkDir, s.lazyPageServer)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if retErr != nil {
+				stopLazyPages()
+			}
+		}()
+	}
+
+	if len(s.imagesStream) != 0 {
+		stopStreamer, err := serveImages(ctx, s.opts.ImagePath, s.imagesStream)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if retErr != nil {
+				stopStreamer()
+			}
+		}()
+	}
+
 	if _, err := s.p.runtime.Restore(ctx, p.id, p.Bundle, s.opts); err != nil {
 		return p.runtimeError(err, "OCI runtime restore failed")
 	}
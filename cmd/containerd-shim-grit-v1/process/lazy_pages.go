@@ -0,0 +1,115 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/log"
+
+	"github.com/kaito-project/grit/pkg/util/netutil"
+)
+
+// lazyPagesReadyTimeout is the max duration for waiting criu lazy-pages daemon to connect to the page server.
+const lazyPagesReadyTimeout = 30 * time.Second
+
+// startLazyPagesDaemon runs criu lazy-pages daemon for lazy restore. criu restore connects to the daemon through
+// the socket in work dir, and memory pages are pulled from criu page server on demand by the daemon. remaining
+// pages are also pulled in the background, and the daemon exits after all pages are transferred. the returned
+// function is used for stopping the daemon when restoration failed.
+//
+// pageServer is the unix socket which is tunneled to criu page server by grit agent, it's only accessible by
+// root. criu only accepts a tcp socket for page server, so the daemon is started with one end of a connected
+// loopback socket pair(--ps-socket), and the other end is forwarded to the unix socket. no port is listened on,
+// so memory pages can't be pulled by other processes in host network.
+func startLazyPagesDaemon(ctx context.Context, imagesDir, workDir, pageServer string) (func(), error) {
+	pageServerConn, err := net.DialTimeout("unix", pageServer, lazyPagesReadyTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect page server %s: %w", pageServer, err)
+	}
+	criuConn, conn, err := netutil.LoopbackConnPair()
+	if err != nil {
+		pageServerConn.Close()
+		return nil, err
+	}
+	defer criuConn.Close()
+	criuFile, err := criuConn.File()
+	if err != nil {
+		pageServerConn.Close()
+		conn.Close()
+		return nil, err
+	}
+	defer criuFile.Close()
+
+	statusReader, statusWriter, err := os.Pipe()
+	if err != nil {
+		pageServerConn.Close()
+		conn.Close()
+		return nil, err
+	}
+	defer statusReader.Close()
+
+	// the daemon outlives the request of starting the container, so it's not bound to the request context.
+	// criu writes \0 into status fd once the daemon is ready, the status file is passed as fd 3 and the socket
+	// of page server is passed as fd 4.
+	cmd := exec.Command("criu", "lazy-pages", "--page-server", "--ps-socket", "4",
+		"--images-dir", imagesDir, "--work-dir", workDir, "--status-fd", "3",
+		"--log-file", filepath.Join(workDir, "lazy-pages.log"))
+	cmd.ExtraFiles = []*os.File{statusWriter, criuFile}
+	if err := cmd.Start(); err != nil {
+		statusWriter.Close()
+		pageServerConn.Close()
+		conn.Close()
+		return nil, fmt.Errorf("failed to start criu lazy-pages daemon: %w", err)
+	}
+	statusWriter.Close()
+	go netutil.Tunnel(conn, pageServerConn)
+
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		if err := cmd.Wait(); err != nil {
+			log.G(ctx).WithError(err).Errorf("criu lazy-pages daemon for %s exited", imagesDir)
+			return
+		}
+		log.G(ctx).Infof("all memory pages in %s are restored by criu lazy-pages daemon", imagesDir)
+	}()
+	stop := func() {
+		select {
+		case <-exited:
+		default:
+			cmd.Process.Kill()
+			<-exited
+		}
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		if _, err := statusReader.Read(b); err != nil {
+			ready <- fmt.Errorf("criu lazy-pages daemon is not ready, %w", err)
+			return
+		}
+		ready <- nil
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		return stop, nil
+	case <-time.After(lazyPagesReadyTimeout):
+		stop()
+		return nil, errors.New("timed out waiting for criu lazy-pages daemon to be ready")
+	}
+}
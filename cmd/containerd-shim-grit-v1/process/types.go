@@ -41,6 +41,12 @@ type CreateConfig struct {
 	Checkpoint       string
 	ParentCheckpoint string
 	Options          *google_protobuf.Any
+	// LazyPageServer is the unix socket of criu page server which serves memory pages of Checkpoint, the
+	// container is restored with criu lazy-pages before memory pages are downloaded if it's specified.
+	LazyPageServer string
+	// ImagesStream is the stream of criu images captured by criu-image-streamer, the container is restored
+	// from it instead of criu images in Checkpoint if it's specified.
+	ImagesStream string
 }
 
 // ExecConfig holds exec creation configuration
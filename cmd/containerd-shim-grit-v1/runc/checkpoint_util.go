@@ -1,11 +1,19 @@
 package runc
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+
+	"github.com/containerd/log"
 
 	crmetadata "github.com/checkpoint-restore/checkpointctl/lib"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+	"github.com/kaito-project/grit/pkg/util/archive"
 )
 
 type CheckpointOpts struct {
@@ -13,24 +21,113 @@ type CheckpointOpts struct {
 	// ├── checkpoint/
 	// │   ├── pages-1.img
 	// │   └── ...
+	// ├── images.stream (criu images captured by criu-image-streamer in stream mode)
 	// ├── rootfs-diff.tar
 	// ├── config.dump
 	// └── spec.dump
 	CheckpointBaseDir string
+	// CheckpointRootDir is the directory of pod checkpoint which contains download state.
+	CheckpointRootDir string
+	// Volumes maps the name of archived pod volume to the mount source of the container.
+	Volumes map[string]string
+	// ImageName is the image of restoration container which is specified by the user.
+	ImageName string
 }
 
 func (c *CheckpointOpts) GetCheckpointPath() string {
 	return path.Join(c.CheckpointBaseDir, crmetadata.CheckpointDirectory)
 }
 
+// LazyPageServer returns the unix socket of criu page server which serves memory pages of the container for lazy restore.
+func (c *CheckpointOpts) LazyPageServer(state *metadata.DownloadState) (string, error) {
+	imagesDir, err := filepath.Rel(c.CheckpointRootDir, c.GetCheckpointPath())
+	if err != nil {
+		return "", err
+	}
+
+	pageServer, ok := state.PageServers[imagesDir]
+	if !ok {
+		return "", fmt.Errorf("there is no page server for memory pages in %s, phase: %s", c.GetCheckpointPath(), state.Phase)
+	}
+	return pageServer, nil
+}
+
+func (c *CheckpointOpts) GetImagesStream() string {
+	return metadata.ImagesStreamPath(c.CheckpointBaseDir)
+}
+
 func (c *CheckpointOpts) GetRootFsDiffTar() string {
 	return path.Join(c.CheckpointBaseDir, crmetadata.RootFsDiffTar)
 }
 
+// RestoreVolumes repopulates emptyDir and /dev/shm volumes of the pod from checkpoint. volumes are
+// shared by containers of the pod, so a marker is recorded after the volume is restored, and the volume
+// is skipped by other containers. a volume which is not empty and has no marker is reported instead of
+// being restored partially.
+func (c *CheckpointOpts) RestoreVolumes(ctx context.Context) error {
+	for name, source := range c.Volumes {
+		archivePath := metadata.VolumeArchivePath(c.CheckpointRootDir, name)
+		if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		markerPath := metadata.VolumeRestoredMarkerPath(c.CheckpointRootDir, source)
+		if _, err := os.Stat(markerPath); err == nil {
+			log.G(ctx).Debugf("Volume %s has been restored, skip", name)
+			continue
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		entries, err := os.ReadDir(source)
+		if err != nil {
+			return fmt.Errorf("failed to read volume %s: %w", source, err)
+		}
+		if len(entries) != 0 {
+			return fmt.Errorf("volume %s in %s is not empty, it can't be restored from checkpoint", name, source)
+		}
+
+		if err := archive.UntarFile(archivePath, source); err != nil {
+			return fmt.Errorf("failed to restore volume %s into %s: %w", name, source, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(markerPath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(markerPath, []byte(source), 0644); err != nil {
+			return fmt.Errorf("failed to record volume %s is restored: %w", name, err)
+		}
+		log.G(ctx).Infof("Restored volume %s into %s", name, source)
+	}
+	return nil
+}
+
+// VerifyImage checks the restoration container uses the image which rootfs diff is based on. the image is
+// only verified when restoration container is pinned by digest, images are pinned by grit-manager.
+func (c *CheckpointOpts) VerifyImage() error {
+	image, err := metadata.ReadContainerImage(c.CheckpointBaseDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	digest := metadata.ImageDigest(c.ImageName)
+	if len(digest) == 0 || len(image.Digest()) == 0 {
+		return nil
+	}
+	if digest != image.Digest() {
+		return fmt.Errorf("image %s of restoration container doesn't match checkpointed image %s", c.ImageName, image.ImageRef)
+	}
+	return nil
+}
+
 const (
 	AnnotationGRITCheckpoint = "grit.dev/checkpoint"
 	AnnotationContainerType  = "io.kubernetes.cri.container-type"
 	AnnotationContainerName  = "io.kubernetes.cri.container-name"
+	AnnotationImageName      = "io.kubernetes.cri.image-name"
 )
 
 // spec is a shallow version of [oci.Spec] containing only the
@@ -39,6 +136,13 @@ const (
 type spec struct {
 	// Annotations contains arbitrary metadata for the container.
 	Annotations map[string]string `json:"annotations,omitempty"`
+	// Mounts configures additional mounts (on top of Root).
+	Mounts []specMount `json:"mounts,omitempty"`
+}
+
+type specMount struct {
+	Destination string `json:"destination"`
+	Source      string `json:"source,omitempty"`
 }
 
 func readCRSpec(bundle string) (*spec, error) {
@@ -71,8 +175,18 @@ func ReadCheckpointOpts(bundle string) (*CheckpointOpts, error) {
 	if checkpointPath == "" {
 		return nil, nil
 	}
+	volumes := make(map[string]string)
+	for _, m := range s.Mounts {
+		if name := metadata.ArchivedVolumeName(m.Destination, m.Source); len(name) != 0 {
+			volumes[name] = m.Source
+		}
+	}
+
 	containerName := s.Annotations[AnnotationContainerName]
 	return &CheckpointOpts{
 		CheckpointBaseDir: path.Join(checkpointPath, containerName),
+		CheckpointRootDir: checkpointPath,
+		Volumes:           volumes,
+		ImageName:         s.Annotations[AnnotationImageName],
 	}, nil
 }
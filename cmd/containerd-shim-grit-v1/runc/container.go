@@ -45,6 +45,7 @@ import (
 	"github.com/pelletier/go-toml/v2"
 
 	"github.com/kaito-project/grit/cmd/containerd-shim-grit-v1/process"
+	"github.com/kaito-project/grit/pkg/metadata"
 )
 
 // NewContainer returns a new runc container
@@ -60,8 +61,38 @@ func NewContainer(ctx context.Context, platform stdio.Platform, r *task.CreateTa
 	}
 	log.G(ctx).Debugf("runtime options: %v", opts)
 
+	var lazyPageServer, imagesStream string
 	ckptOpts, err := ReadCheckpointOpts(r.Bundle)
 	if ckptOpts != nil {
+		// fail fast with the reason reported by grit-agent instead of restoring from incomplete data.
+		if state, err := metadata.ReadDownloadState(ckptOpts.CheckpointRootDir); err == nil {
+			if state.Phase == metadata.DownloadPhaseFailed {
+				return nil, state.Error()
+			} else if !state.IsRestorable() {
+				return nil, fmt.Errorf("checkpointed data in %s is not ready, phase: %s", ckptOpts.CheckpointRootDir, state.Phase)
+			}
+
+			// memory pages are still being downloaded for lazy restore, they are pulled from criu page server on demand.
+			if state.Phase == metadata.DownloadPhaseMetadataReady {
+				if lazyPageServer, err = ckptOpts.LazyPageServer(state); err != nil {
+					return nil, err
+				}
+				log.G(ctx).Infof("restore container lazily, memory pages are served by page server %s", lazyPageServer)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read download state: %w", err)
+		}
+
+		// rootfs diff can't be applied on top of a different image.
+		if err := ckptOpts.VerifyImage(); err != nil {
+			return nil, err
+		}
+
+		// criu images are captured into a stream when the pod is checkpointed in stream mode.
+		if _, err := os.Stat(ckptOpts.GetImagesStream()); err == nil {
+			imagesStream = ckptOpts.GetImagesStream()
+		}
+
 		checkpointPath := ckptOpts.GetCheckpointPath()
 		if _, err := os.Stat(checkpointPath); err == nil {
 			r.Checkpoint = checkpointPath
@@ -106,6 +137,8 @@ func NewContainer(ctx context.Context, platform stdio.Platform, r *task.CreateTa
 		Checkpoint:       r.Checkpoint,
 		ParentCheckpoint: r.ParentCheckpoint,
 		Options:          r.Options,
+		LazyPageServer:   lazyPageServer,
+		ImagesStream:     imagesStream,
 	}
 
 	if err := WriteOptions(r.Bundle, opts); err != nil {
@@ -169,6 +202,12 @@ func NewContainer(ctx context.Context, platform stdio.Platform, r *task.CreateTa
 			}
 			log.G(ctx).Debugf("Unpacked checkpoint in %s", rootfs)
 		}
+
+		// Volumes should be restored before the process is restored, since
+		// restored process may reference files in volumes.
+		if err := ckptOpts.RestoreVolumes(ctx); err != nil {
+			return nil, err
+		}
 	}
 
 	p, err := newInit(
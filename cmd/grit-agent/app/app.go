@@ -5,6 +5,7 @@ package app
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 
@@ -18,11 +19,20 @@ import (
 
 	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
 	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
 	"github.com/kaito-project/grit/pkg/gritagent/checkpoint"
+	"github.com/kaito-project/grit/pkg/gritagent/copy"
+	"github.com/kaito-project/grit/pkg/gritagent/daemon"
+	"github.com/kaito-project/grit/pkg/gritagent/metrics"
 	"github.com/kaito-project/grit/pkg/gritagent/restore"
 	"github.com/kaito-project/grit/pkg/injections"
+	"github.com/kaito-project/grit/pkg/metadata"
 )
 
+// maxJobResultMessageLength leaves room for other fields of job result in termination message, which is limited
+// to 4096 bytes by kubelet.
+const maxJobResultMessageLength = 3072
+
 func init() {
 	v1alpha1.SchemeBuilder.AddToScheme(scheme.Scheme)
 }
@@ -64,9 +74,56 @@ func Run(opts *options.GritAgentOptions) error {
 		handler = checkpoint.RunCheckpoint
 	case options.ActionRestore:
 		handler = restore.RunRestore
+	case options.ActionDaemon:
+		handler = daemon.RunDaemon
+	case options.ActionPrestage:
+		handler = restore.RunPrestage
+	case options.ActionCleanup:
+		handler = restore.RunCleanup
 	default:
 		return fmt.Errorf("unknown action %s", opts.Action)
 	}
 
-	return handler(ctx, opts)
+	// grit agent daemon throttles every operation separately.
+	if opts.Action != options.ActionDaemon {
+		ctx = copy.WithBandwidthLimit(ctx, opts.BandwidthLimit)
+	}
+
+	// grit agent job exits after the operation, so metrics of the operation are reported to grit-manager by
+	// termination message instead of being scraped.
+	if opts.Action != options.ActionDaemon {
+		metrics.CollectOperationMetrics()
+	}
+
+	err := handler(ctx, opts)
+	if opts.Action != options.ActionDaemon && len(opts.TerminationMessagePath) != 0 {
+		if writeErr := writeJobResult(opts, err); writeErr != nil {
+			logger.Error(writeErr, "failed to write termination message", "path", opts.TerminationMessagePath)
+		}
+	}
+	return err
+}
+
+// writeJobResult writes the result of the operation into termination message of grit agent job, the failure reason
+// is truncated so the result fits into the size limit of termination message.
+func writeJobResult(opts *options.GritAgentOptions, err error) error {
+	result := &api.JobResult{Metrics: metrics.CollectedOperationMetrics()}
+	if err != nil {
+		result.Message = err.Error()
+		if len(result.Message) > maxJobResultMessageLength {
+			result.Message = result.Message[:maxJobResultMessageLength]
+		}
+	}
+	// estimated size of checkpointed data is also reported when checkpoint is refused for insufficient disk space.
+	if opts.Action == options.ActionCheckpoint {
+		if estimate, err := metadata.ReadSizeEstimate(opts.HostWorkPath); err == nil {
+			result.EstimatedSize = estimate.Total()
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(opts.TerminationMessagePath, data, 0644)
 }
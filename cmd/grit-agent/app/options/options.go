@@ -5,6 +5,7 @@ package options
 
 import (
 	"os"
+	"time"
 
 	"github.com/spf13/pflag"
 )
@@ -16,6 +17,40 @@ type GritAgentOptions struct {
 	Action          string
 	SrcDir          string
 	DstDir          string
+	GRPCPort        int
+	// APISecretFile contains the secret shared by grit-manager and grit agent daemons for authenticating requests
+	// of grpc api, it's required when grit agent runs as a node daemon.
+	APISecretFile string
+	HostPath      string
+	// NodeName is the node which grit agent runs on, and JanitorInterval is the interval of removing checkpointed
+	// data under HostPath which is not needed anymore by grit agent daemon. the janitor is disabled if it's 0.
+	NodeName        string
+	JanitorInterval time.Duration
+	// PeerPort is the port which grit agent daemon serves checkpointed data on for other nodes, and
+	// PeerSecretFile contains the secret shared by grit agent daemons for authenticating peer requests.
+	// checkpointed data is not served to other nodes if PeerSecretFile is not specified. PeerTLSDir contains
+	// the ca and the serving certificate of grit agent daemons, peer requests are only served over tls.
+	PeerPort       int
+	PeerSecretFile string
+	PeerTLSDir     string
+	// PeerURL is the url of checkpointed data served by grit agent daemon on the source node, checkpointed
+	// data is pulled from it before falling back to cloud storage.
+	PeerURL string
+	// LazyPages makes restoration start as soon as checkpointed data except memory pages is pulled from the peer,
+	// memory pages are served on demand by criu page server on the source node and downloaded in the background.
+	LazyPages bool
+	// Stream makes criu images be streamed into DstDir directly when checkpointing, so criu images are not
+	// staged on the disk of the node and uploading is overlapped with dumping.
+	Stream bool
+	// BandwidthLimit is the max rate of transferring checkpointed data in bytes per second, it's shared by all
+	// files transferred by one operation. transfer is not throttled if it's 0.
+	BandwidthLimit int64
+	// MetricsPort is the port which grit agent daemon serves prometheus metrics on, 0 disables it. grit agent job
+	// reports metrics by termination message instead.
+	MetricsPort int
+	// TerminationMessagePath is the file which the result of checkpoint or restore in grit agent job is written to,
+	// like the error and metrics of the operation, grit-manager surfaces it from the status of grit agent pod.
+	TerminationMessagePath string
 
 	RuntimeCheckpointOptions
 }
@@ -27,11 +62,29 @@ type RuntimeCheckpointOptions struct {
 	RuntimeEndpoint    string
 	KubeletLogPath     string
 	HostWorkPath       string
+	// CheckpointName is the name of Checkpoint resource, it's used for naming VolumeSnapshots.
+	CheckpointName string
+	// VolumeSnapshotClaims are pvcs of the target pod which VolumeSnapshots are taken for while the pod is frozen.
+	VolumeSnapshotClaims    []string
+	VolumeSnapshotClassName string
+	VolumeSnapshotTimeout   time.Duration
+	// StreamDir is the directory in storage which criu images are streamed into by criu-image-streamer, criu
+	// images are dumped into HostWorkPath if it's not specified.
+	StreamDir string
+	// StorageDir is the directory in storage which checkpointed data is transferred to, free space of it is
+	// checked before the pod is paused. it's empty if checkpointed data is kept on the node.
+	StorageDir string
 }
 
 const (
 	ActionCheckpoint = "checkpoint"
 	ActionRestore    = "restore"
+	ActionDaemon     = "daemon"
+	// ActionPrestage downloads checkpointed data to a candidate node before the checkpointed pod is deleted,
+	// and ActionCleanup removes pre-staged data which is not used by restoration pod, or all checkpointed data
+	// under host path which is not needed anymore if dst-dir is not specified.
+	ActionPrestage = "prestage"
+	ActionCleanup  = "cleanup"
 )
 
 func NewGritAgentOptions() *GritAgentOptions {
@@ -39,6 +92,16 @@ func NewGritAgentOptions() *GritAgentOptions {
 		Version:         false,
 		KubeClientQPS:   50,
 		KubeClientBurst: 100,
+		GRPCPort:        10360,
+		PeerPort:        10361,
+		HostPath:        "/mnt/grit-agent",
+		JanitorInterval: time.Hour,
+		MetricsPort:     10362,
+
+		TerminationMessagePath: "/dev/termination-log",
+		RuntimeCheckpointOptions: RuntimeCheckpointOptions{
+			VolumeSnapshotTimeout: 2 * time.Minute,
+		},
 	}
 }
 
@@ -46,9 +109,23 @@ func (o *GritAgentOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&o.Version, "version", o.Version, "print the version information, and then exit")
 	fs.IntVar(&o.KubeClientQPS, "kube-client-qps", o.KubeClientQPS, "the rate of qps to kube-apiserver.")
 	fs.IntVar(&o.KubeClientBurst, "kube-client-burst", o.KubeClientBurst, "the max allowed burst of queries to the kube-apiserver.")
-	fs.StringVar(&o.Action, "action", os.Getenv("ACTION"), "the action to be performed. Valid values are: 'checkpoint', 'restore'.")
+	fs.StringVar(&o.Action, "action", os.Getenv("ACTION"), "the action to be performed. Valid values are: 'checkpoint', 'restore', 'daemon', 'prestage', 'cleanup'.")
 	fs.StringVar(&o.SrcDir, "src-dir", o.SrcDir, "the source directory in agent container for C/R data.")
 	fs.StringVar(&o.DstDir, "dst-dir", o.DstDir, "the destination directory in agent container for C/R data.")
+	fs.IntVar(&o.GRPCPort, "grpc-port", o.GRPCPort, "the port the grpc endpoint binds to when grit-agent runs as a node daemon.")
+	fs.StringVar(&o.APISecretFile, "api-secret-file", o.APISecretFile, "the file of secret shared by grit-manager and grit agent daemons for authenticating grpc requests, it's required in daemon mode.")
+	fs.StringVar(&o.HostPath, "host-path", o.HostPath, "the root path on the host for C/R data, only directories under this path can be cleaned up by grit-agent daemon.")
+	fs.StringVar(&o.NodeName, "node-name", os.Getenv("NODE_NAME"), "the name of the node which grit agent runs on.")
+	fs.DurationVar(&o.JanitorInterval, "janitor-interval", o.JanitorInterval, "the interval of removing checkpointed data under host-path which is not needed anymore by grit agent daemon, 0 disables it.")
+	fs.IntVar(&o.PeerPort, "peer-port", o.PeerPort, "the port checkpointed data is served on for grit agent daemons on other nodes.")
+	fs.StringVar(&o.PeerSecretFile, "peer-secret-file", o.PeerSecretFile, "the file of secret shared by grit agent daemons for authenticating peer transfer, peer transfer is disabled if it's not specified.")
+	fs.StringVar(&o.PeerTLSDir, "peer-tls-dir", o.PeerTLSDir, "the directory of ca.crt, tls.crt and tls.key for serving and verifying peer transfer over tls, it's required if peer-secret-file is specified.")
+	fs.StringVar(&o.PeerURL, "peer-url", o.PeerURL, "the url of checkpointed data served by grit agent daemon on the source node.")
+	fs.BoolVar(&o.LazyPages, "lazy-pages", o.LazyPages, "start restoration before memory pages are pulled from the peer, memory pages are served on demand by criu page server on the source node.")
+	fs.BoolVar(&o.Stream, "stream", o.Stream, "stream criu images into dst-dir directly by criu-image-streamer when checkpointing.")
+	fs.Int64Var(&o.BandwidthLimit, "bandwidth-limit", o.BandwidthLimit, "the max rate of transferring checkpointed data in bytes per second, 0 means unlimited.")
+	fs.IntVar(&o.MetricsPort, "metrics-port", o.MetricsPort, "the port grit agent daemon serves prometheus metrics on, 0 disables it.")
+	fs.StringVar(&o.TerminationMessagePath, "termination-message-path", o.TerminationMessagePath, "the file which the result of checkpoint or restore in grit agent job is written to.")
 
 	fs.StringVar(&o.TargetPodNamespace, "target-pod-namespace", os.Getenv("TARGET_NAMESPACE"), "the namespace of the target pod.")
 	fs.StringVar(&o.TargetPodName, "target-pod-name", os.Getenv("TARGET_NAME"), "the name of the target pod.")
@@ -56,4 +133,8 @@ func (o *GritAgentOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.RuntimeEndpoint, "runtime-endpoint", "/run/containerd/containerd.sock", "the endpoint of the container runtime.")
 	fs.StringVar(&o.KubeletLogPath, "kubelet-log-path", "/var/log/pods", "the path of kubelet log.")
 	fs.StringVar(&o.HostWorkPath, "host-work-path", o.HostWorkPath, "the work path on the host.")
+	fs.StringVar(&o.CheckpointName, "checkpoint-name", o.CheckpointName, "the name of checkpoint resource.")
+	fs.StringSliceVar(&o.VolumeSnapshotClaims, "volume-snapshot-claims", o.VolumeSnapshotClaims, "the pvcs of the target pod which volume snapshots are taken for while the pod is frozen.")
+	fs.StringVar(&o.VolumeSnapshotClassName, "volume-snapshot-class", o.VolumeSnapshotClassName, "the volume snapshot class used for taking volume snapshots.")
+	fs.DurationVar(&o.VolumeSnapshotTimeout, "volume-snapshot-timeout", o.VolumeSnapshotTimeout, "the max duration for waiting volume snapshots to be taken.")
 }
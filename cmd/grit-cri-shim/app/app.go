@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/cli/globalflag"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/cmd/grit-cri-shim/app/options"
+	"github.com/kaito-project/grit/pkg/crishim"
+	"github.com/kaito-project/grit/pkg/injections"
+)
+
+func NewGritCRIShimCommand() *cobra.Command {
+	opts := options.NewGritCRIShimOptions()
+
+	cmd := &cobra.Command{
+		Use:     "grit-cri-shim",
+		Version: injections.VersionInfo(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliflag.PrintFlags(cmd.Flags())
+
+			if err := Run(opts); err != nil {
+				fmt.Fprintf(os.Stderr, "run grit-cri-shim failed: %v\n", err)
+				return err
+			}
+			return nil
+		},
+	}
+
+	globalflag.AddGlobalFlags(cmd.Flags(), cmd.Name())
+	opts.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+func Run(opts *options.GritCRIShimOptions) error {
+	ctx := ctrl.SetupSignalHandler()
+
+	//logging
+	logger := klog.FromContext(ctx)
+	log.SetLogger(logger)
+
+	proxy, err := crishim.NewProxy(crishim.Options{
+		ListenAddress:   opts.ListenAddress,
+		RuntimeEndpoint: opts.RuntimeEndpoint,
+		DownloadTimeout: opts.DownloadTimeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	return proxy.Run(ctx)
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type GritCRIShimOptions struct {
+	Version         bool
+	ListenAddress   string
+	RuntimeEndpoint string
+	DownloadTimeout time.Duration
+}
+
+func NewGritCRIShimOptions() *GritCRIShimOptions {
+	return &GritCRIShimOptions{
+		Version:         false,
+		ListenAddress:   "/run/grit/grit-cri-shim.sock",
+		RuntimeEndpoint: "/run/containerd/containerd.sock",
+		DownloadTimeout: 10 * time.Minute,
+	}
+}
+
+func (o *GritCRIShimOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Version, "version", o.Version, "print the version information, and then exit")
+	fs.StringVar(&o.ListenAddress, "listen", o.ListenAddress, "the unix socket which grit-cri-shim listens on, kubelet should use it as container runtime endpoint.")
+	fs.StringVar(&o.RuntimeEndpoint, "runtime-endpoint", o.RuntimeEndpoint, "the endpoint of the backend container runtime.")
+	fs.DurationVar(&o.DownloadTimeout, "download-timeout", o.DownloadTimeout, "the max duration for waiting checkpointed data to be downloaded when pulling image for restoration pod.")
+}
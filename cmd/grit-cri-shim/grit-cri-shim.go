@@ -0,0 +1,18 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"os"
+
+	"k8s.io/component-base/cli"
+
+	"github.com/kaito-project/grit/cmd/grit-cri-shim/app"
+)
+
+func main() {
+	command := app.NewGritCRIShimCommand()
+	code := cli.Run(command)
+	os.Exit(code)
+}
@@ -34,9 +34,11 @@ import (
 
 	"github.com/kaito-project/grit/cmd/grit-manager/app/options"
 	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
 	"github.com/kaito-project/grit/pkg/gritmanager/agentmanager"
 	"github.com/kaito-project/grit/pkg/gritmanager/controllers"
 	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
+	"github.com/kaito-project/grit/pkg/gritmanager/metrics"
 	"github.com/kaito-project/grit/pkg/gritmanager/webhooks"
 	"github.com/kaito-project/grit/pkg/injections"
 	"github.com/kaito-project/grit/pkg/util/profile"
@@ -59,6 +61,9 @@ func NewGritManagerCommand() *cobra.Command {
 		Version: injections.VersionInfo(),
 		Run: func(cmd *cobra.Command, args []string) {
 			cliflag.PrintFlags(cmd.Flags())
+			if err := opts.Validate(); err != nil {
+				klog.Fatalf("validate options failed, %v", err)
+			}
 
 			if err := Run(opts); err != nil {
 				klog.Fatalf("run grit-manager failed, %v", err)
@@ -168,8 +173,16 @@ func Run(opts *options.GritManagerOptions) error {
 	lo.Must0(mgr.AddReadyzCheck("readyz", healthz.Ping))
 
 	// initialize girt agent manager
-	agentManager := agentmanager.NewAgentManager(opts.WorkingNamespace, configmapLister)
+	// requests to grit agent daemons are signed with the api secret, grit agent jobs are used if it's not specified.
+	var agentAPISecret []byte
+	if len(opts.AgentAPISecretFile) != 0 {
+		if agentAPISecret, err = api.LoadSecret(opts.AgentAPISecretFile); err != nil {
+			return fmt.Errorf("failed to load grit agent api secret: %w", err)
+		}
+	}
+	agentManager := agentmanager.NewAgentManager(opts.WorkingNamespace, configmapLister, mgr.GetClient(), agentAPISecret)
 	clk := clock.RealClock{}
+	lo.Must0(metrics.RegisterInFlightCollector(mgr.GetClient()))
 
 	// initialize controllers
 	controllers := controllers.NewControllers(mgr, clk, opts, agentManager)
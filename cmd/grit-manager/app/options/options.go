@@ -3,9 +3,11 @@
 package options
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	componentbaseconfig "k8s.io/component-base/config"
 	"k8s.io/component-base/config/options"
@@ -24,6 +26,13 @@ type GritManagerOptions struct {
 	WebhookSecretName  string
 	WebhookServiceName string
 	ExpirationDuration time.Duration
+	// label selectors of pod webhook, only pods which are matched by both selectors are intercepted by
+	// pod webhook. all pods are intercepted when selectors are empty.
+	PodWebhookNamespaceSelector string
+	PodWebhookObjectSelector    string
+	// AgentAPISecretFile contains the secret shared with grit agent daemons for signing grpc requests, grit agent
+	// jobs are used instead of grit agent daemons if it's not specified.
+	AgentAPISecretFile string
 }
 
 func NewGritManagerOptions() *GritManagerOptions {
@@ -61,4 +70,36 @@ func (o *GritManagerOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.WebhookSecretName, "webhook-secret-name", o.WebhookSecretName, "the secret which used for storing certificates for grit webhook")
 	fs.StringVar(&o.WebhookServiceName, "webhook-service-name", o.WebhookServiceName, "the service which used for accessing grit webhook")
 	fs.DurationVar(&o.ExpirationDuration, "cert-duration", o.ExpirationDuration, "the expiration duration of webhook server certificates")
+	fs.StringVar(&o.PodWebhookNamespaceSelector, "pod-webhook-namespace-selector", o.PodWebhookNamespaceSelector, "the label selector of namespaces whose pods are intercepted by pod webhook, like grit.dev/restore=enabled.")
+	fs.StringVar(&o.PodWebhookObjectSelector, "pod-webhook-object-selector", o.PodWebhookObjectSelector, "the label selector of pods which are intercepted by pod webhook.")
+	fs.StringVar(&o.AgentAPISecretFile, "agent-api-secret-file", o.AgentAPISecretFile, "the file of secret shared with grit agent daemons for authenticating grpc requests.")
+}
+
+func (o *GritManagerOptions) Validate() error {
+	if _, _, err := o.PodWebhookSelectors(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PodWebhookSelectors returns namespace selector and object selector of pod webhook, nil is returned for
+// empty selector.
+func (o *GritManagerOptions) PodWebhookSelectors() (*metav1.LabelSelector, *metav1.LabelSelector, error) {
+	namespaceSelector, err := parseLabelSelector(o.PodWebhookNamespaceSelector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid pod webhook namespace selector, %w", err)
+	}
+
+	objectSelector, err := parseLabelSelector(o.PodWebhookObjectSelector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid pod webhook object selector, %w", err)
+	}
+	return namespaceSelector, objectSelector, nil
+}
+
+func parseLabelSelector(selector string) (*metav1.LabelSelector, error) {
+	if len(selector) == 0 {
+		return nil, nil
+	}
+	return metav1.ParseToLabelSelector(selector)
 }
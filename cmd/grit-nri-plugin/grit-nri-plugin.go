@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/containerd/nri/pkg/stub"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func main() {
+	var (
+		pluginName string
+		pluginIdx  string
+		podLogDir  string
+	)
+
+	klog.InitFlags(nil)
+	flag.StringVar(&pluginName, "name", "grit", "the name of nri plugin.")
+	flag.StringVar(&pluginIdx, "idx", "10", "the index of nri plugin, it decides the order of plugins.")
+	flag.StringVar(&podLogDir, "pod-log-dir", "/var/log/pods", "the directory of pod logs on the host.")
+	flag.Parse()
+
+	ctx := context.Background()
+	log.SetLogger(klog.FromContext(ctx))
+
+	p := &plugin{
+		podLogDir: podLogDir,
+	}
+
+	s, err := stub.New(p, stub.WithPluginName(pluginName), stub.WithPluginIdx(pluginIdx))
+	if err != nil {
+		klog.Errorf("failed to create nri plugin stub, %v", err)
+		os.Exit(1)
+	}
+
+	if err := s.Run(ctx); err != nil {
+		klog.Errorf("nri plugin exited, %v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/containerd/nri/pkg/api"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/crishim/interceptor"
+)
+
+const (
+	// annotationRestartCount is set by kubelet on each container, it's used for composing log path.
+	annotationRestartCount = "io.kubernetes.container.restartCount"
+)
+
+// plugin replaces the containerd interceptor patch, it handles containers of restoration pods:
+//   - refuse to create container until checkpointed data is downloaded by grit-agent. NRI requests have a short
+//     timeout, so the plugin fails the request instead of waiting, and kubelet retries container creation.
+//   - resume container log from checkpointed data.
+//   - inject annotations which are required by containerd-shim-grit-v1 for restoring container.
+type plugin struct {
+	podLogDir string
+}
+
+func (p *plugin) CreateContainer(ctx context.Context, pod *api.PodSandbox, ctr *api.Container) (*api.ContainerAdjustment, []*api.ContainerUpdate, error) {
+	checkpointPath := pod.GetAnnotations()[v1alpha1.CheckpointDataPathLabel]
+	if len(checkpointPath) == 0 {
+		return nil, nil, nil
+	}
+
+	logger := log.FromContext(ctx).WithValues("pod", fmt.Sprintf("%s/%s", pod.GetNamespace(), pod.GetName()), "container", ctr.GetName())
+	ctx = log.IntoContext(ctx, logger)
+
+	logger.Info("found restoration pod, checking checkpointed data is downloaded", "checkpoint", checkpointPath)
+	if err := interceptor.CheckCheckpointData(checkpointPath); err != nil {
+		return nil, nil, err
+	}
+
+	restartCount := ctr.GetAnnotations()[annotationRestartCount]
+	if len(restartCount) == 0 {
+		restartCount = "0"
+	}
+	logPath := filepath.Join(p.podLogDir, fmt.Sprintf("%s_%s_%s", pod.GetNamespace(), pod.GetName(), pod.GetUid()), ctr.GetName(), restartCount+".log")
+	// failing to resume log should not block the restoration.
+	if err := interceptor.ResumeContainerLog(ctx, checkpointPath, ctr.GetName(), logPath); err != nil {
+		logger.Error(err, "failed to resume container log")
+	}
+
+	// pod annotations are not passed through to oci spec unless pod_annotations is configured
+	// for the runtime, so checkpoint path is injected into container annotations explicitly.
+	adjust := &api.ContainerAdjustment{}
+	adjust.AddAnnotation(v1alpha1.CheckpointDataPathLabel, checkpointPath)
+	return adjust, nil, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/nri/pkg/api"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+func TestCreateContainer(t *testing.T) {
+	prepare := func(t *testing.T, state *metadata.DownloadState) string {
+		dir := t.TempDir()
+		os.MkdirAll(filepath.Join(dir, "app"), 0755)
+		os.WriteFile(filepath.Join(dir, "app", metadata.ContainerLogFile), []byte("before checkpoint\n"), 0644)
+		if state != nil {
+			if err := metadata.WriteDownloadState(dir, state); err != nil {
+				t.Fatalf("failed to write download state, %v", err)
+			}
+		}
+		return dir
+	}
+	restorationPod := func(checkpointPath string) *api.PodSandbox {
+		return &api.PodSandbox{
+			Name:        "demo",
+			Namespace:   "default",
+			Uid:         "uid",
+			Annotations: map[string]string{v1alpha1.CheckpointDataPathLabel: checkpointPath},
+		}
+	}
+	ctr := &api.Container{Name: "app"}
+
+	t.Run("pod is not a restoration pod", func(t *testing.T) {
+		p := &plugin{podLogDir: t.TempDir()}
+		adjust, _, err := p.CreateContainer(context.Background(), &api.PodSandbox{Name: "demo"}, ctr)
+		if err != nil || adjust != nil {
+			t.Fatalf("expected container of normal pod not to be adjusted, got %v, %v", adjust, err)
+		}
+	})
+
+	t.Run("download is not started", func(t *testing.T) {
+		p := &plugin{podLogDir: t.TempDir()}
+		if _, _, err := p.CreateContainer(context.Background(), restorationPod(prepare(t, nil)), ctr); err == nil {
+			t.Fatalf("expected container creation to fail before checkpointed data is downloaded")
+		}
+	})
+
+	t.Run("data is downloading", func(t *testing.T) {
+		p := &plugin{podLogDir: t.TempDir()}
+		dir := prepare(t, &metadata.DownloadState{Phase: metadata.DownloadPhaseDownloading})
+		if _, _, err := p.CreateContainer(context.Background(), restorationPod(dir), ctr); err == nil {
+			t.Fatalf("expected container creation to fail while checkpointed data is downloading")
+		}
+	})
+
+	t.Run("download failed", func(t *testing.T) {
+		p := &plugin{podLogDir: t.TempDir()}
+		dir := prepare(t, &metadata.DownloadState{Phase: metadata.DownloadPhaseFailed, Reason: "DownloadFailed", Message: "no space left"})
+		_, _, err := p.CreateContainer(context.Background(), restorationPod(dir), ctr)
+		if err == nil || !strings.Contains(err.Error(), "no space left") {
+			t.Fatalf("expected container creation to fail with the download error, got %v", err)
+		}
+	})
+
+	t.Run("data is ready", func(t *testing.T) {
+		p := &plugin{podLogDir: t.TempDir()}
+		dir := prepare(t, &metadata.DownloadState{Phase: metadata.DownloadPhaseReady})
+		adjust, _, err := p.CreateContainer(context.Background(), restorationPod(dir), ctr)
+		if err != nil {
+			t.Fatalf("expected container to be created, %v", err)
+		}
+		if adjust.GetAnnotations()[v1alpha1.CheckpointDataPathLabel] != dir {
+			t.Fatalf("expected checkpoint path to be injected into container annotations, got %v", adjust.GetAnnotations())
+		}
+		data, err := os.ReadFile(filepath.Join(p.podLogDir, "default_demo_uid", "app", "0.log"))
+		if err != nil || !strings.HasPrefix(string(data), "before checkpoint\n") {
+			t.Fatalf("expected container log to be resumed, got %q, %v", data, err)
+		}
+	})
+}
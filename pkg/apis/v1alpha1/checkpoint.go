@@ -5,6 +5,7 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -20,12 +21,31 @@ const (
 	CheckpointFailed        CheckpointPhase = "Failed"
 )
 
+// CheckpointQueued means checkpoint is waiting in Pending state because limits of concurrent checkpoint and restore
+// operations are reached. it's also the condition type, but it's not resolved as a state of the state machine.
+const CheckpointQueued CheckpointPhase = "Queued"
+
+// CheckpointPrestaged is the condition type of pre-staging checkpointed data, it's not a phase of checkpoint.
+const CheckpointPrestaged = "Prestaged"
+
+type PrestagePhase string
+
+const (
+	Prestaging     PrestagePhase = "Prestaging"
+	Prestaged      PrestagePhase = "Prestaged"
+	PrestageFailed PrestagePhase = "Failed"
+	// PrestageCleaningUp means pre-staged data on the node is being removed because it's not used by restoration pod.
+	PrestageCleaningUp PrestagePhase = "CleaningUp"
+)
+
 type CheckpointSpec struct {
 	// PodName is used to specify pod for checkpointing. only pod in the same namespace of Checkpoint will be selected.
 	// +required
 	PodName string `json:"podName"`
 	// VolumeClaim is used to specify cloud storage for storing checkpoint data and share data across nodes.
 	// End user should ensure related pvc/pv resource exist and ready before creating Checkpoint resource.
+	// If it's not specified, checkpointed data is kept on the node where the pod is checkpointed, and the
+	// restoration pod will be scheduled to this node.
 	// +optional
 	VolumeClaim *corev1.PersistentVolumeClaimVolumeSource `json:"volumeClaim,omitempty"`
 	// AutoMigration is used for migrating pod across nodes automatically. If true is set, related Restore resource will be created automatically, then checkpointed pod will be deleted by grit-manager, and a new pod will be created automatically by the pod owner(like Deployment and Job). this new pod will be selected as restoration pod and checkpointed data will be used for restoring new pod.
@@ -34,6 +54,79 @@ type CheckpointSpec struct {
 	// 2. VolumeClaim field is specified as a cloud storage, this means checkpointed data can be shared across nodes.
 	// +optional
 	AutoMigration bool `json:"autoMigration,omitempty"`
+	// VolumeSnapshot is used for taking CSI VolumeSnapshots of persistent volume claims which are used by the pod.
+	// Snapshots are taken while the pod is frozen, so data in the pvc matches the checkpointed process state, and
+	// the restoration pod will use new pvcs which are provisioned from these snapshots.
+	// +optional
+	VolumeSnapshot *VolumeSnapshotSpec `json:"volumeSnapshot,omitempty"`
+	// Prestage is used for copying checkpointed data to candidate nodes of restoration pod before the checkpointed
+	// pod is deleted, so restoration pod on these nodes only verifies the data instead of downloading it. Candidate
+	// nodes are nodes which match node selector, node affinity and tolerations of checkpointed pod. VolumeClaim
+	// should be specified for pre-staging, and auto migration is submitted after pre-staging is completed.
+	// +optional
+	Prestage *PrestageSpec `json:"prestage,omitempty"`
+	// Streaming is used for streaming criu images into VolumeClaim directly by criu-image-streamer while the pod is
+	// dumped, instead of staging them on the disk of the node and uploading them afterward. VolumeClaim should be
+	// specified for streaming, and streamed checkpoint is always restored from cloud storage without lazy pages.
+	// +optional
+	Streaming bool `json:"streaming,omitempty"`
+	// Priority is used for ordering checkpoint and restore operations which are queued by limits of concurrent
+	// operations, operation with higher priority is admitted first. priority of the checkpointed pod, which is
+	// resolved from its PriorityClass, is used if it's not specified. it's inherited by auto migration restore.
+	// +optional
+	Priority *int32 `json:"priority,omitempty"`
+	// Deadline is the time by which checkpointing should be started, like the time when the node is reclaimed.
+	// queued operations with the same priority are admitted in order of deadline, and operations without
+	// deadline are admitted after them.
+	// +optional
+	Deadline *metav1.Time `json:"deadline,omitempty"`
+}
+
+type PrestageSpec struct {
+	// MaxNodes is the max number of candidate nodes which checkpointed data is pre-staged to, 1 is used by default.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxNodes int32 `json:"maxNodes,omitempty"`
+}
+
+// PrestagedNode records the state of checkpointed data which is pre-staged to a candidate node.
+type PrestagedNode struct {
+	// NodeName is the name of candidate node.
+	NodeName string `json:"nodeName"`
+	// Phase is the phase of pre-staging on the node.
+	Phase PrestagePhase `json:"phase"`
+	// Message describes why pre-staging failed on the node.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+type VolumeSnapshotSpec struct {
+	// ClaimNames is used to specify pvcs of the pod for taking snapshots. only pvc in the same namespace of Checkpoint will be selected.
+	// +required
+	ClaimNames []string `json:"claimNames"`
+	// VolumeSnapshotClassName is the VolumeSnapshotClass used for taking snapshots, default VolumeSnapshotClass is used if not specified.
+	// +optional
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+}
+
+// ContainerImage records the image of a container in checkpointed pod.
+type ContainerImage struct {
+	// Name is the name of container.
+	Name string `json:"name"`
+	// Image is the image specified in pod spec.
+	Image string `json:"image"`
+	// ImageDigest is the image reference pinned by digest, like docker.io/library/nginx@sha256:xxx.
+	// it's empty if the image is not pulled from registry.
+	// +optional
+	ImageDigest string `json:"imageDigest,omitempty"`
+}
+
+// VolumeSnapshotReference records the VolumeSnapshot which is taken for a pvc of checkpointed pod.
+type VolumeSnapshotReference struct {
+	// ClaimName is the name of pvc used by checkpointed pod.
+	ClaimName string `json:"claimName"`
+	// VolumeSnapshotName is the name of VolumeSnapshot which is taken for the pvc.
+	VolumeSnapshotName string `json:"volumeSnapshotName"`
 }
 
 type CheckpointStatus struct {
@@ -44,18 +137,52 @@ type CheckpointStatus struct {
 	// Checkpointed data can be used to restore for pod with same hash value.
 	// +optional
 	PodSpecHash string `json:"podSpecHash,omitempty"`
+	// PodSpecDigest is the canonical digest of pod spec fields which matter for restoring.
+	// +optional
+	PodSpecDigest string `json:"podSpecDigest,omitempty"`
+	// PodSpecFieldDigests records digest of each field which matters for restoring, the key is <container name>/<field>.
+	// It's used for checking restoration pod is compatible with checkpointed pod, and reporting the different fields.
+	// +optional
+	PodSpecFieldDigests map[string]string `json:"podSpecFieldDigests,omitempty"`
 	// PodUid is used for storing pod uid which will be used to construct log path of pod.
 	// +optional
 	PodUID string `json:"podUID,omitempty"`
+	// ContainerImages records images of containers in checkpointed pod. rootfs diff of container is based on
+	// the image, so images of restoration pod are pinned to these digests.
+	// +optional
+	ContainerImages []ContainerImage `json:"containerImages,omitempty"`
 	// state machine of Checkpoint Phase: Created -->Pending --> Checkpointing --> Checkpointed --> Submitting --> Submitted or Failed.
+	// checkpoint in Pending phase is Queued until it's admitted by limits of concurrent operations.
 	// +optional
 	Phase CheckpointPhase `json:"phase,omitempty"`
+	// Priority is the priority which queued checkpoint is ordered by, it's resolved from spec.priority or
+	// PriorityClass of the checkpointed pod.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// QueuePosition is the position of checkpoint in the queue of operations which wait for admission, it starts
+	// from 1 and it's only set in Queued phase.
+	// +optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
 	// current state of pod checkpoint
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 	// checkpointed data is stored under this path in the storage volume. and the data in this path will be used for restoring pod.
+	// for checkpoint without VolumeClaim, data is kept on the node and the path is node://<node name>/<namespace>/<name>.
 	// +optional
 	DataPath string `json:"dataPath,omitempty"`
+	// EstimatedSize is the size of checkpointed data which is estimated by grit agent before the pod is paused, it's
+	// the sum of anonymous and shared memory of containers, size of their writable layers and size of emptyDir and
+	// /dev/shm volumes of the pod.
+	// +optional
+	EstimatedSize *resource.Quantity `json:"estimatedSize,omitempty"`
+	// VolumeSnapshots records the VolumeSnapshots which are taken with the checkpoint, pvcs of restoration pod
+	// will be provisioned from these snapshots.
+	// +optional
+	VolumeSnapshots []VolumeSnapshotReference `json:"volumeSnapshots,omitempty"`
+	// PrestagedNodes records candidate nodes which checkpointed data is pre-staged to. pre-staged data which is
+	// not used by restoration pod will be removed, and the node is removed from this list after cleanup.
+	// +optional
+	PrestagedNodes []PrestagedNode `json:"prestagedNodes,omitempty"`
 }
 
 // Checkpoint is the Schema for the Checkpoints API
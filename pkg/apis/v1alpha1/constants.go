@@ -8,11 +8,20 @@ const (
 	GritAgentLabel = "grit.dev/helper"
 	GritAgentName  = "grit-agent"
 
+	// label of grit agent jobs which pre-stage checkpointed data to nodes or clean it up, value is checkpoint name.
+	CheckpointNameLabel = "grit.dev/checkpoint-name"
+
 	// annotations for restoration pod
 	CheckpointDataPathLabel = "grit.dev/checkpoint"
 	RestoreNameLabel        = "grit.dev/restore-name"
+	RestoreBindingLabel     = "grit.dev/restore-binding"
+	// OriginalImagesAnnotation records images in pod spec before they are pinned by digest for restoration pod,
+	// value is a json map from container name to image, so the pod can be checkpointed again with its own images.
+	OriginalImagesAnnotation = "grit.dev/original-images"
 
-	// annotations for restore resource
+	// annotations for restore resource, value of RestorationPodSelectedLabel is the binding id which is
+	// also recorded in RestoreBindingLabel annotation of restoration pod.
 	PodSpecHashLabel            = "grit.dev/pod-spec-hash"
+	PodSpecDigestLabel          = "grit.dev/pod-spec-digest"
 	RestorationPodSelectedLabel = "grit.dev/pod-selected"
 )
@@ -5,6 +5,7 @@ package v1alpha1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 type RestorePhase string
@@ -17,6 +18,38 @@ const (
 	RestoreFailed  RestorePhase = "Failed"
 )
 
+// RestoreQueued means restore is waiting in Pending state because limits of concurrent checkpoint and restore
+// operations are reached. it's also the condition type, but it's not resolved as a state of the state machine.
+const RestoreQueued RestorePhase = "Queued"
+
+// RestoreMode specifies how restoration pods are bound to restore.
+// +kubebuilder:validation:Enum=Single;FanOut
+type RestoreMode string
+
+const (
+	// RestoreModeSingle binds one restoration pod, and restore is completed after the pod is restored.
+	RestoreModeSingle RestoreMode = "Single"
+	// RestoreModeFanOut binds every matching pod which is created after restore until restore is deleted.
+	// checkpointed data is downloaded once on each node, and shared by restoration pods on the node.
+	RestoreModeFanOut RestoreMode = "FanOut"
+)
+
+// PodSpecField is a field of container in pod spec which matters for restoring.
+// +kubebuilder:validation:Enum=Image;Command;VolumeMounts;Devices;AdditionalContainers
+type PodSpecField string
+
+const (
+	PodSpecFieldImage PodSpecField = "Image"
+	// PodSpecFieldCommand includes command, args and working dir of container.
+	PodSpecFieldCommand      PodSpecField = "Command"
+	PodSpecFieldVolumeMounts PodSpecField = "VolumeMounts"
+	// PodSpecFieldDevices includes volume devices and extended resources(like nvidia.com/gpu) of container.
+	PodSpecFieldDevices PodSpecField = "Devices"
+	// PodSpecFieldAdditionalContainers allows containers which don't exist in checkpointed pod, like injected sidecars,
+	// these containers are started from scratch instead of being restored.
+	PodSpecFieldAdditionalContainers PodSpecField = "AdditionalContainers"
+)
+
 type RestoreSpec struct {
 	// CheckpointName is used to specify Checkpoint resource. only Checkpoint in the same namespace of Restore will be selected.
 	// Only checkpointed Checkpoint will be accepted, and checkpointed data will be used for restoring pod.
@@ -27,13 +60,47 @@ type RestoreSpec struct {
 	// But recommend to use OwnerRef for pods which created by controller(like Deployment).
 	// Pod will be selected as target pod for restoring with following conditions:
 	// 1. pod has owner reference which equal to this owner reference.
-	// 2. pod spec has the same hash value corresponding to Checkpoint.
+	// 2. pod spec is compatible with the checkpointed pod.
+	// If both OwnerRef and Selector are specified, pod should match both of them.
 	// +optional
 	OwnerRef metav1.OwnerReference `json:"ownerRef,omitempty"`
-	// Selector is also used for selecting restoration pod.
-	// and recommend to use selector for standalone pod.
+	// Selector is also used for selecting restoration pod, pod whose labels match the selector will be selected.
+	// and recommend to use selector for standalone pod. empty selector is not allowed.
 	// +optional
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// CompatibilityPolicy is used for checking restoration pod is compatible with checkpointed pod.
+	// Container names, images, commands, volume mounts and devices of restoration pod should be the same as
+	// checkpointed pod by default, other fields like resources requests and tolerations are allowed to differ.
+	// +optional
+	CompatibilityPolicy *CompatibilityPolicy `json:"compatibilityPolicy,omitempty"`
+	// Mode specifies how restoration pods are bound to restore, Single is used by default.
+	// FanOut mode is used for starting many pods(like replicas of Deployment) from one checkpoint,
+	// and checkpoint with volume snapshots is not supported by FanOut mode.
+	// +optional
+	Mode RestoreMode `json:"mode,omitempty"`
+	// LazyPages makes restoration pod start as soon as checkpointed data except memory pages is downloaded,
+	// memory pages are served on demand by criu page server on the node where the pod is checkpointed and
+	// downloaded in the background. it requires peer transfer of grit agent daemon from another node, so it's
+	// ignored for restore on the node where the pod is checkpointed and for streamed checkpoint, checkpointed
+	// data is downloaded completely before restoring if lazy restore is unavailable, and the Restoring condition
+	// tells it. FanOut mode is not supported.
+	// +optional
+	LazyPages bool `json:"lazyPages,omitempty"`
+	// Priority is used for ordering checkpoint and restore operations which are queued by limits of concurrent
+	// operations, operation with higher priority is admitted first. priority of the restoration pod, which is
+	// resolved from its PriorityClass, is used if it's not specified.
+	// +optional
+	Priority *int32 `json:"priority,omitempty"`
+	// Deadline is the time by which restoring should be started. queued operations with the same priority are
+	// admitted in order of deadline, and operations without deadline are admitted after them.
+	// +optional
+	Deadline *metav1.Time `json:"deadline,omitempty"`
+}
+
+type CompatibilityPolicy struct {
+	// AllowedDifferences specifies fields of containers which are allowed to differ between checkpointed pod and restoration pod.
+	// +optional
+	AllowedDifferences []PodSpecField `json:"allowedDifferences,omitempty"`
 }
 
 type RestoreStatus struct {
@@ -43,14 +110,96 @@ type RestoreStatus struct {
 	// the pod specified by TargetPod is selected for restoring.
 	// +optional
 	TargetPod string `json:"targetPod,omitempty"`
+	// Binding records the restoration pod which is bound to this restore. the binding is released when
+	// the bound pod is deleted before restoring starts, so another pod can be selected.
+	// +optional
+	Binding *RestorationPodBinding `json:"binding,omitempty"`
 	// state machine of Restore Phase: Pending --> Restoring --> Restored or Failed.
+	// restore in Pending phase is Queued until it's admitted by limits of concurrent operations.
+	// restore in FanOut mode stays in Restoring phase until it's deleted.
 	// +optional
 	Phase RestorePhase `json:"phase,omitempty"`
+	// Priority is the priority which queued restore is ordered by, it's resolved from spec.priority or
+	// PriorityClass of the restoration pod.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+	// QueuePosition is the position of restore in the queue of operations which wait for admission, it starts
+	// from 1 and it's only set in Queued phase.
+	// +optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+	// Nodes records download results of checkpointed data on each node for restore in FanOut mode.
+	// +optional
+	Nodes []RestoreNodeStatus `json:"nodes,omitempty"`
+	// Pods records restore results of restoration pods for restore in FanOut mode.
+	// +optional
+	Pods []RestorationPodStatus `json:"pods,omitempty"`
+	// RestoredPods is the number of restoration pods which are restored for restore in FanOut mode.
+	// +optional
+	RestoredPods int32 `json:"restoredPods,omitempty"`
+	// PodStartedTime is the time when restoration pod is observed running.
+	// +optional
+	PodStartedTime *metav1.Time `json:"podStartedTime,omitempty"`
+	// PagesCompletedTime is the time when all memory pages arrived on the node for lazy restore.
+	// +optional
+	PagesCompletedTime *metav1.Time `json:"pagesCompletedTime,omitempty"`
 	// current state of pod restore
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+type RestorationPodBinding struct {
+	// ID is generated by pod webhook when the pod is selected, and it's recorded in annotations of both
+	// restore and restoration pod.
+	// +required
+	ID string `json:"id"`
+	// PodName is the name of restoration pod.
+	// +required
+	PodName string `json:"podName"`
+	// PodUID is the uid of restoration pod.
+	// +required
+	PodUID types.UID `json:"podUID"`
+	// BoundTime is the time when restoration pod is bound to restore.
+	// +optional
+	BoundTime metav1.Time `json:"boundTime,omitempty"`
+}
+
+type RestoreNodeStatus struct {
+	// NodeName is the name of node where checkpointed data is downloaded.
+	// +required
+	NodeName string `json:"nodeName"`
+	// Phase is Restoring when checkpointed data is downloading, and Restored when it's downloaded.
+	// +required
+	Phase RestorePhase `json:"phase"`
+	// Message is the reason of download failure.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Attempts is the number of attempts for downloading checkpointed data to the node, failed download is
+	// retried with backoff until the max attempts.
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+	// LastTransitionTime is the last time the phase transitioned.
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+type RestorationPodStatus struct {
+	// PodName is the name of restoration pod.
+	// +required
+	PodName string `json:"podName"`
+	// PodUID is the uid of restoration pod.
+	// +required
+	PodUID types.UID `json:"podUID"`
+	// NodeName is the name of node where restoration pod is located on.
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// Phase of restoration pod: Pending --> Restoring --> Restored or Failed.
+	// +required
+	Phase RestorePhase `json:"phase"`
+	// Message is the reason of restore failure.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
 // Restore is the Schema for the Restores API
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:path=restores,scope=Namespaced,categories=girt,shortName=rt
@@ -60,6 +209,7 @@ type RestoreStatus struct {
 // +kubebuilder:printcolumn:name="RestorationPod",type="string",JSONPath=".status.targetPod",description="The pod will be restored"
 // +kubebuilder:printcolumn:name="NodeName",type="string",JSONPath=".status.nodeName",description="The node where restoration pod located on"
 // +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description="The phase of restore action"
+// +kubebuilder:printcolumn:name="RestoredPods",type="integer",JSONPath=".status.restoredPods",description="The number of restored pods in FanOut mode",priority=1
 type Restore struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
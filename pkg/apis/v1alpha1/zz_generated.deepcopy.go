@@ -80,6 +80,25 @@ func (in *CheckpointSpec) DeepCopyInto(out *CheckpointSpec) {
 		*out = new(v1.PersistentVolumeClaimVolumeSource)
 		**out = **in
 	}
+	if in.VolumeSnapshot != nil {
+		in, out := &in.VolumeSnapshot, &out.VolumeSnapshot
+		*out = new(VolumeSnapshotSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Prestage != nil {
+		in, out := &in.Prestage, &out.Prestage
+		*out = new(PrestageSpec)
+		**out = **in
+	}
+	if in.Priority != nil {
+		in, out := &in.Priority, &out.Priority
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Deadline != nil {
+		in, out := &in.Deadline, &out.Deadline
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CheckpointSpec.
@@ -95,6 +114,18 @@ func (in *CheckpointSpec) DeepCopy() *CheckpointSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CheckpointStatus) DeepCopyInto(out *CheckpointStatus) {
 	*out = *in
+	if in.PodSpecFieldDigests != nil {
+		in, out := &in.PodSpecFieldDigests, &out.PodSpecFieldDigests
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ContainerImages != nil {
+		in, out := &in.ContainerImages, &out.ContainerImages
+		*out = make([]ContainerImage, len(*in))
+		copy(*out, *in)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]metav1.Condition, len(*in))
@@ -102,6 +133,21 @@ func (in *CheckpointStatus) DeepCopyInto(out *CheckpointStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.EstimatedSize != nil {
+		in, out := &in.EstimatedSize, &out.EstimatedSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.VolumeSnapshots != nil {
+		in, out := &in.VolumeSnapshots, &out.VolumeSnapshots
+		*out = make([]VolumeSnapshotReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.PrestagedNodes != nil {
+		in, out := &in.PrestagedNodes, &out.PrestagedNodes
+		*out = make([]PrestagedNode, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CheckpointStatus.
@@ -114,6 +160,102 @@ func (in *CheckpointStatus) DeepCopy() *CheckpointStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CompatibilityPolicy) DeepCopyInto(out *CompatibilityPolicy) {
+	*out = *in
+	if in.AllowedDifferences != nil {
+		in, out := &in.AllowedDifferences, &out.AllowedDifferences
+		*out = make([]PodSpecField, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CompatibilityPolicy.
+func (in *CompatibilityPolicy) DeepCopy() *CompatibilityPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CompatibilityPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerImage) DeepCopyInto(out *ContainerImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerImage.
+func (in *ContainerImage) DeepCopy() *ContainerImage {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrestageSpec) DeepCopyInto(out *PrestageSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrestageSpec.
+func (in *PrestageSpec) DeepCopy() *PrestageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PrestageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrestagedNode) DeepCopyInto(out *PrestagedNode) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrestagedNode.
+func (in *PrestagedNode) DeepCopy() *PrestagedNode {
+	if in == nil {
+		return nil
+	}
+	out := new(PrestagedNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestorationPodBinding) DeepCopyInto(out *RestorationPodBinding) {
+	*out = *in
+	in.BoundTime.DeepCopyInto(&out.BoundTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestorationPodBinding.
+func (in *RestorationPodBinding) DeepCopy() *RestorationPodBinding {
+	if in == nil {
+		return nil
+	}
+	out := new(RestorationPodBinding)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestorationPodStatus) DeepCopyInto(out *RestorationPodStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestorationPodStatus.
+func (in *RestorationPodStatus) DeepCopy() *RestorationPodStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RestorationPodStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Restore) DeepCopyInto(out *Restore) {
 	*out = *in
@@ -173,6 +315,25 @@ func (in *RestoreList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestoreNodeStatus) DeepCopyInto(out *RestoreNodeStatus) {
+	*out = *in
+	if in.LastTransitionTime != nil {
+		in, out := &in.LastTransitionTime, &out.LastTransitionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestoreNodeStatus.
+func (in *RestoreNodeStatus) DeepCopy() *RestoreNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RestoreNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RestoreSpec) DeepCopyInto(out *RestoreSpec) {
 	*out = *in
@@ -182,6 +343,20 @@ func (in *RestoreSpec) DeepCopyInto(out *RestoreSpec) {
 		*out = new(metav1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.CompatibilityPolicy != nil {
+		in, out := &in.CompatibilityPolicy, &out.CompatibilityPolicy
+		*out = new(CompatibilityPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Priority != nil {
+		in, out := &in.Priority, &out.Priority
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Deadline != nil {
+		in, out := &in.Deadline, &out.Deadline
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestoreSpec.
@@ -197,6 +372,31 @@ func (in *RestoreSpec) DeepCopy() *RestoreSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RestoreStatus) DeepCopyInto(out *RestoreStatus) {
 	*out = *in
+	if in.Binding != nil {
+		in, out := &in.Binding, &out.Binding
+		*out = new(RestorationPodBinding)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]RestoreNodeStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]RestorationPodStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.PodStartedTime != nil {
+		in, out := &in.PodStartedTime, &out.PodStartedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PagesCompletedTime != nil {
+		in, out := &in.PagesCompletedTime, &out.PagesCompletedTime
+		*out = (*in).DeepCopy()
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]metav1.Condition, len(*in))
@@ -215,3 +415,38 @@ func (in *RestoreStatus) DeepCopy() *RestoreStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeSnapshotReference) DeepCopyInto(out *VolumeSnapshotReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeSnapshotReference.
+func (in *VolumeSnapshotReference) DeepCopy() *VolumeSnapshotReference {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeSnapshotReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeSnapshotSpec) DeepCopyInto(out *VolumeSnapshotSpec) {
+	*out = *in
+	if in.ClaimNames != nil {
+		in, out := &in.ClaimNames, &out.ClaimNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeSnapshotSpec.
+func (in *VolumeSnapshotSpec) DeepCopy() *VolumeSnapshotSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeSnapshotSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package crishim
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/mem"
+)
+
+// frame is a raw grpc message, it is forwarded to the backend runtime without decoding.
+type frame struct {
+	payload []byte
+}
+
+// rawCodec passes grpc messages through as bytes. it's named "proto" so the content type
+// of requests and responses is the same as the one between kubelet and container runtime.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) (mem.BufferSlice, error) {
+	f, ok := v.(*frame)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return mem.BufferSlice{mem.SliceBuffer(f.payload)}, nil
+}
+
+func (rawCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	f, ok := v.(*frame)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	f.payload = data.Materialize()
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,241 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package interceptor implements the CRI interceptions which are required for restoring pods
+// from checkpointed data, they are shared by grit-cri-shim and other runtime integrations.
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+const (
+	defaultDownloadTimeout = 10 * time.Minute
+	pollInterval           = 1 * time.Second
+
+	// logResumedFile is created in the log directory of the container after its log is resumed.
+	logResumedFile = ".grit-log-resumed"
+	// rotatedLogTimestampFormat is the timestamp format in names of log files rotated by kubelet.
+	rotatedLogTimestampFormat = "20060102-150405"
+)
+
+// CheckpointPath returns the checkpoint path specified by grit-manager in pod annotations,
+// empty string is returned if the pod is not a restoration pod.
+func CheckpointPath(sandboxConfig *runtimeapi.PodSandboxConfig) string {
+	return sandboxConfig.GetAnnotations()[v1alpha1.CheckpointDataPathLabel]
+}
+
+// WaitForCheckpointData waits for the checkpointed data to be downloaded by grit-agent. it fails
+// fast with the reason reported by grit-agent if the download failed.
+func WaitForCheckpointData(ctx context.Context, checkpointPath string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultDownloadTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var lastState *metadata.DownloadState
+	for {
+		select {
+		case <-ticker.C:
+			state, err := metadata.ReadDownloadState(checkpointPath)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			lastState = state
+			// memory pages are not required before restoring for lazy restore.
+			if state.IsRestorable() {
+				return nil
+			} else if state.Phase == metadata.DownloadPhaseFailed {
+				return fmt.Errorf("checkpoint %s: %w", checkpointPath, state.Error())
+			}
+		case <-timer.C:
+			if lastState != nil {
+				return fmt.Errorf("timed out waiting for checkpoint %s, last phase: %s, %d/%d bytes transferred", checkpointPath, lastState.Phase, lastState.TransferredBytes, lastState.TotalBytes)
+			}
+			return fmt.Errorf("timed out waiting for checkpoint %s, download is not started", checkpointPath)
+		case <-ctx.Done():
+			return fmt.Errorf("context canceled while waiting for checkpoint %s: %w", checkpointPath, ctx.Err())
+		}
+	}
+}
+
+// CheckCheckpointData checks whether checkpointed data is ready for restoring without waiting. an error is returned
+// if checkpointed data is not downloaded yet or the download failed, so the caller can fail the request quickly and
+// let kubelet retry it, instead of blocking a runtime request which has a short timeout.
+func CheckCheckpointData(checkpointPath string) error {
+	state, err := metadata.ReadDownloadState(checkpointPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("checkpoint %s is not ready, download is not started", checkpointPath)
+	} else if err != nil {
+		return err
+	}
+
+	// memory pages are not required before restoring for lazy restore.
+	if state.IsRestorable() {
+		return nil
+	} else if state.Phase == metadata.DownloadPhaseFailed {
+		return fmt.Errorf("checkpoint %s: %w", checkpointPath, state.Error())
+	}
+	return fmt.Errorf("checkpoint %s is not ready, phase: %s, %d/%d bytes transferred", checkpointPath, state.Phase, state.TransferredBytes, state.TotalBytes)
+}
+
+// InterceptPullImage blocks image pulling of restoration pod until checkpointed data is downloaded.
+func InterceptPullImage(ctx context.Context, r *runtimeapi.PullImageRequest, timeout time.Duration) error {
+	checkpointPath := CheckpointPath(r.GetSandboxConfig())
+	if len(checkpointPath) == 0 {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("found restoration pod, waiting for checkpointed data to be downloaded", "checkpoint", checkpointPath, "image", r.GetImage().GetImage())
+	return WaitForCheckpointData(ctx, checkpointPath, timeout)
+}
+
+// InterceptCreateContainer fails the container creation of restoration pod until checkpointed data is ready, so the
+// container is never created from incomplete data, and kubelet retries it. then the container log is resumed from
+// checkpointed data, so logs before checkpointing are still available after the pod is restored.
+func InterceptCreateContainer(ctx context.Context, r *runtimeapi.CreateContainerRequest) error {
+	sandboxConfig := r.GetSandboxConfig()
+	checkpointPath := CheckpointPath(sandboxConfig)
+	if len(checkpointPath) == 0 {
+		return nil
+	}
+
+	if err := CheckCheckpointData(checkpointPath); err != nil {
+		return err
+	}
+
+	config := r.GetConfig()
+	if len(sandboxConfig.GetLogDirectory()) == 0 || len(config.GetLogPath()) == 0 {
+		return nil
+	}
+	logPath := filepath.Join(sandboxConfig.GetLogDirectory(), config.GetLogPath())
+
+	// failing to resume log should not block the restoration.
+	if err := ResumeContainerLog(ctx, checkpointPath, config.GetMetadata().GetName(), logPath); err != nil {
+		log.FromContext(ctx).Error(err, "failed to resume container log", "container", config.GetMetadata().GetName())
+	}
+	return nil
+}
+
+// ResumeContainerLog copies the container log saved in checkpointed data to logPath. log files which were rotated
+// before checkpointing are restored as rotated files of logPath according to the container log index, so kubelet
+// keeps applying its log rotation policy to them. the log is only resumed once for each container of the pod, a
+// restarted container starts with an empty log file and the history is kept in the log files of earlier restarts.
+func ResumeContainerLog(ctx context.Context, checkpointPath, containerName, logPath string) error {
+	resumedPath := filepath.Join(filepath.Dir(logPath), logResumedFile)
+	if _, err := os.Stat(resumedPath); err == nil {
+		log.FromContext(ctx).Info("container log has been resumed, skip log resume", "container", containerName, "path", logPath)
+		return nil
+	}
+
+	savedDir := filepath.Join(checkpointPath, containerName)
+	savedLogPath := filepath.Join(savedDir, metadata.ContainerLogFile)
+	srcFile, err := os.Open(savedLogPath)
+	if os.IsNotExist(err) {
+		log.FromContext(ctx).Info("saved log file does not exist, skip log resume", "container", containerName, "path", savedLogPath)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open source log file %q: %w", savedLogPath, err)
+	}
+	defer srcFile.Close()
+
+	segments, err := savedLogSegments(srcFile, savedDir)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return err
+	}
+	// segments are ordered from the oldest to the newest, rotated files are named with increasing timestamps
+	// before now in the same way as kubelet.
+	now := time.Now()
+	last := segments[len(segments)-1]
+	for i, segment := range segments[:len(segments)-1] {
+		rotatedAt := now.Add(time.Duration(i-len(segments)+1) * time.Second)
+		if err := copyLogSegment(srcFile, segment, fmt.Sprintf("%s.%s", logPath, rotatedAt.Format(rotatedLogTimestampFormat)), ""); err != nil {
+			return err
+		}
+	}
+
+	// saved log contains the complete history of the container, a marker line is appended
+	// at the migration point, so kubectl logs shows one continuous log.
+	marker := metadata.MigrationMarker(now, checkpointPath)
+	if last.Size > 0 && !endsWithNewline(srcFile, last.Offset+last.Size) {
+		marker = "\n" + marker
+	}
+	if err := copyLogSegment(srcFile, last, logPath, marker); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(resumedPath, []byte(checkpointPath), 0640); err != nil {
+		return fmt.Errorf("failed to record container log is resumed: %w", err)
+	}
+	log.FromContext(ctx).Info("container log is resumed", "container", containerName, "path", savedLogPath, "files", len(segments))
+	return nil
+}
+
+// savedLogSegments returns log segments in the container log index, the saved log is regarded as one segment
+// if there is no index in checkpointed data.
+func savedLogSegments(srcFile *os.File, savedDir string) ([]metadata.ContainerLogSegment, error) {
+	index, err := metadata.ReadContainerLogIndex(savedDir)
+	if err == nil && len(index.Segments) != 0 {
+		return index.Segments, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return []metadata.ContainerLogSegment{{Offset: 0, Size: info.Size()}}, nil
+}
+
+// copyLogSegment writes the segment of saved log into dstPath, suffix is appended after the segment.
+func copyLogSegment(srcFile *os.File, segment metadata.ContainerLogSegment, dstPath, suffix string) error {
+	destFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open destination log file %q: %w", dstPath, err)
+	}
+	defer destFile.Close()
+
+	if _, err := io.Copy(destFile, io.NewSectionReader(srcFile, segment.Offset, segment.Size)); err != nil {
+		return fmt.Errorf("failed to copy log file from %q to %q: %w", srcFile.Name(), dstPath, err)
+	}
+	if len(suffix) != 0 {
+		if _, err := destFile.WriteString(suffix); err != nil {
+			return fmt.Errorf("failed to write migration marker to %q: %w", dstPath, err)
+		}
+	}
+	return nil
+}
+
+func endsWithNewline(f *os.File, size int64) bool {
+	buf := make([]byte, 1)
+	if _, err := f.ReadAt(buf, size-1); err != nil {
+		return false
+	}
+	return buf[0] == '\n'
+}
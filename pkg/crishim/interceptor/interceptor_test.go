@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package interceptor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+func createContainerRequest(checkpointPath, logDir string) *runtimeapi.CreateContainerRequest {
+	return &runtimeapi.CreateContainerRequest{
+		SandboxConfig: &runtimeapi.PodSandboxConfig{
+			Annotations:  map[string]string{v1alpha1.CheckpointDataPathLabel: checkpointPath},
+			LogDirectory: logDir,
+		},
+		Config: &runtimeapi.ContainerConfig{
+			Metadata: &runtimeapi.ContainerMetadata{Name: "app"},
+			LogPath:  "app/0.log",
+		},
+	}
+}
+
+func TestInterceptCreateContainer(t *testing.T) {
+	prepare := func(t *testing.T, state *metadata.DownloadState) string {
+		dir := t.TempDir()
+		os.MkdirAll(filepath.Join(dir, "app"), 0755)
+		os.WriteFile(filepath.Join(dir, "app", metadata.ContainerLogFile), []byte("before checkpoint\n"), 0644)
+		if state != nil {
+			if err := metadata.WriteDownloadState(dir, state); err != nil {
+				t.Fatalf("failed to write download state, %v", err)
+			}
+		}
+		return dir
+	}
+
+	t.Run("pod is not a restoration pod", func(t *testing.T) {
+		if err := InterceptCreateContainer(context.Background(), createContainerRequest("", t.TempDir())); err != nil {
+			t.Fatalf("expected container of normal pod to be created, %v", err)
+		}
+	})
+
+	t.Run("download is not started", func(t *testing.T) {
+		logDir := t.TempDir()
+		if err := InterceptCreateContainer(context.Background(), createContainerRequest(prepare(t, nil), logDir)); err == nil {
+			t.Fatalf("expected container creation to fail before checkpointed data is downloaded")
+		}
+		if _, err := os.Stat(filepath.Join(logDir, "app/0.log")); !os.IsNotExist(err) {
+			t.Fatalf("expected container log not to be resumed, %v", err)
+		}
+	})
+
+	t.Run("data is downloading", func(t *testing.T) {
+		dir := prepare(t, &metadata.DownloadState{Phase: metadata.DownloadPhaseDownloading})
+		if err := InterceptCreateContainer(context.Background(), createContainerRequest(dir, t.TempDir())); err == nil {
+			t.Fatalf("expected container creation to fail while checkpointed data is downloading")
+		}
+	})
+
+	t.Run("download failed", func(t *testing.T) {
+		dir := prepare(t, &metadata.DownloadState{Phase: metadata.DownloadPhaseFailed, Reason: "DownloadFailed", Message: "no space left"})
+		err := InterceptCreateContainer(context.Background(), createContainerRequest(dir, t.TempDir()))
+		if err == nil || !strings.Contains(err.Error(), "no space left") {
+			t.Fatalf("expected container creation to fail with the download error, got %v", err)
+		}
+	})
+
+	t.Run("data is ready", func(t *testing.T) {
+		logDir := t.TempDir()
+		dir := prepare(t, &metadata.DownloadState{Phase: metadata.DownloadPhaseReady})
+		if err := InterceptCreateContainer(context.Background(), createContainerRequest(dir, logDir)); err != nil {
+			t.Fatalf("expected container to be created, %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(logDir, "app/0.log"))
+		if err != nil || !strings.HasPrefix(string(data), "before checkpoint\n") {
+			t.Fatalf("expected container log to be resumed, got %q, %v", data, err)
+		}
+	})
+}
+
+func TestResumeContainerLog(t *testing.T) {
+	checkpointPath := t.TempDir()
+	savedDir := filepath.Join(checkpointPath, "app")
+	os.MkdirAll(savedDir, 0755)
+	os.WriteFile(filepath.Join(savedDir, metadata.ContainerLogFile), []byte("rotated\ncurrent\n"), 0644)
+	index := &metadata.ContainerLogIndex{Segments: []metadata.ContainerLogSegment{
+		{Source: "0.log.20250101-000000.gz", Compressed: true, Offset: 0, Size: 8},
+		{Source: "0.log", Offset: 8, Size: 8},
+	}}
+	if err := metadata.WriteContainerLogIndex(savedDir, index); err != nil {
+		t.Fatalf("failed to write container log index, %v", err)
+	}
+	logDir := t.TempDir()
+
+	if err := ResumeContainerLog(context.Background(), checkpointPath, "app", filepath.Join(logDir, "0.log")); err != nil {
+		t.Fatalf("failed to resume container log, %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(logDir, "0.log"))
+	if err != nil || !strings.HasPrefix(string(data), "current\n") || !strings.Contains(string(data), "[grit]") {
+		t.Fatalf("expected current log file to be resumed with migration marker, got %q, %v", data, err)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(logDir, "0.log.*"))
+	if len(rotated) != 1 {
+		t.Fatalf("expected one rotated log file, got %v", rotated)
+	}
+	if data, err := os.ReadFile(rotated[0]); err != nil || string(data) != "rotated\n" {
+		t.Fatalf("expected rotated log file to be resumed, got %q, %v", data, err)
+	}
+
+	// restarted container gets a new log file, the log history is not copied again.
+	if err := ResumeContainerLog(context.Background(), checkpointPath, "app", filepath.Join(logDir, "1.log")); err != nil {
+		t.Fatalf("failed to resume container log, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "1.log")); !os.IsNotExist(err) {
+		t.Fatalf("expected log of restarted container not to be resumed, %v", err)
+	}
+}
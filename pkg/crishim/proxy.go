@@ -0,0 +1,239 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package crishim implements a CRI proxy which sits between kubelet and container runtime.
+// requests which are required for restoring pods are intercepted, and all other requests
+// are forwarded to the backend runtime unchanged.
+package crishim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/crishim/interceptor"
+)
+
+const (
+	PullImageMethod       = "/runtime.v1.ImageService/PullImage"
+	CreateContainerMethod = "/runtime.v1.RuntimeService/CreateContainer"
+)
+
+// InterceptFunc is called with the raw request before the request is forwarded to the backend
+// runtime. forwarding is aborted when an error is returned.
+type InterceptFunc func(ctx context.Context, req []byte) error
+
+type Options struct {
+	// ListenAddress is the unix socket which kubelet connects to.
+	ListenAddress string
+	// RuntimeEndpoint is the unix socket of the backend container runtime.
+	RuntimeEndpoint string
+	// DownloadTimeout is the max duration for waiting checkpointed data to be downloaded.
+	DownloadTimeout time.Duration
+}
+
+type Proxy struct {
+	opts    Options
+	backend *grpc.ClientConn
+	// beforeForward interceptors are called before the request is forwarded.
+	beforeForward map[string]InterceptFunc
+	// afterForward interceptors are called after the backend runtime returns successfully.
+	afterForward map[string]InterceptFunc
+}
+
+func NewProxy(opts Options) (*Proxy, error) {
+	backend, err := grpc.NewClient(unixTarget(opts.RuntimeEndpoint),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodecV2(rawCodec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to runtime %s: %w", opts.RuntimeEndpoint, err)
+	}
+
+	p := &Proxy{
+		opts:          opts,
+		backend:       backend,
+		beforeForward: make(map[string]InterceptFunc),
+		afterForward:  make(map[string]InterceptFunc),
+	}
+
+	p.AfterForward(PullImageMethod, func(ctx context.Context, req []byte) error {
+		var r runtimeapi.PullImageRequest
+		if err := r.Unmarshal(req); err != nil {
+			return err
+		}
+		return interceptor.InterceptPullImage(ctx, &r, opts.DownloadTimeout)
+	})
+	p.BeforeForward(CreateContainerMethod, func(ctx context.Context, req []byte) error {
+		var r runtimeapi.CreateContainerRequest
+		if err := r.Unmarshal(req); err != nil {
+			return err
+		}
+		return interceptor.InterceptCreateContainer(ctx, &r)
+	})
+	return p, nil
+}
+
+// BeforeForward registers an interceptor which is called before the request of method is forwarded.
+func (p *Proxy) BeforeForward(method string, fn InterceptFunc) {
+	p.beforeForward[method] = fn
+}
+
+// AfterForward registers an interceptor which is called after the request of method is handled by
+// the backend runtime successfully, the response is returned to kubelet only when fn succeeds.
+func (p *Proxy) AfterForward(method string, fn InterceptFunc) {
+	p.afterForward[method] = fn
+}
+
+// Run serves CRI requests on ListenAddress until ctx is canceled.
+func (p *Proxy) Run(ctx context.Context) error {
+	defer p.backend.Close()
+
+	if err := os.MkdirAll(filepath.Dir(p.opts.ListenAddress), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(p.opts.ListenAddress); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket %s: %w", p.opts.ListenAddress, err)
+	}
+	lis, err := net.Listen("unix", p.opts.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", p.opts.ListenAddress, err)
+	}
+
+	server := grpc.NewServer(
+		grpc.ForceServerCodecV2(rawCodec{}),
+		grpc.UnknownServiceHandler(p.handle),
+	)
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	log.FromContext(ctx).Info("grit cri shim is serving", "address", p.opts.ListenAddress, "runtime", p.opts.RuntimeEndpoint)
+	return server.Serve(lis)
+}
+
+// handle forwards any stream to the backend runtime. unary requests are regarded as streams
+// which have only one request and one response.
+func (p *Proxy) handle(_ any, serverStream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(serverStream)
+	if !ok {
+		return status.Error(codes.Internal, "failed to get method from server stream")
+	}
+
+	ctx, cancel := context.WithCancel(serverStream.Context())
+	defer cancel()
+	logger := log.FromContext(ctx).WithValues("method", method)
+	ctx = log.IntoContext(ctx, logger)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = metadata.NewOutgoingContext(ctx, md.Copy())
+	}
+
+	before, after := p.beforeForward[method], p.afterForward[method]
+	if before != nil || after != nil {
+		return p.handleIntercepted(ctx, method, serverStream, before, after)
+	}
+
+	clientStream, err := p.backend.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, method)
+	if err != nil {
+		return err
+	}
+
+	// forward requests from kubelet to runtime
+	go func() {
+		for {
+			req := &frame{}
+			if err := serverStream.RecvMsg(req); err != nil {
+				if errors.Is(err, io.EOF) {
+					clientStream.CloseSend()
+				} else {
+					cancel()
+				}
+				return
+			}
+			if err := clientStream.SendMsg(req); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	// forward responses from runtime to kubelet
+	headerSent := false
+	for {
+		resp := &frame{}
+		if err := clientStream.RecvMsg(resp); err != nil {
+			serverStream.SetTrailer(clientStream.Trailer())
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if !headerSent {
+			if header, err := clientStream.Header(); err == nil {
+				if err := serverStream.SendHeader(header); err != nil {
+					return err
+				}
+			}
+			headerSent = true
+		}
+		if err := serverStream.SendMsg(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// handleIntercepted handles unary requests which need to be intercepted.
+func (p *Proxy) handleIntercepted(ctx context.Context, method string, serverStream grpc.ServerStream, before, after InterceptFunc) error {
+	req := &frame{}
+	if err := serverStream.RecvMsg(req); err != nil {
+		return err
+	}
+
+	if before != nil {
+		if err := before(ctx, req.payload); err != nil {
+			return status.Errorf(codes.Internal, "grit cri shim: %v", err)
+		}
+	}
+
+	resp := &frame{}
+	var header, trailer metadata.MD
+	if err := p.backend.Invoke(ctx, method, req, resp, grpc.Header(&header), grpc.Trailer(&trailer)); err != nil {
+		serverStream.SetTrailer(trailer)
+		return err
+	}
+
+	if after != nil {
+		if err := after(ctx, req.payload); err != nil {
+			return status.Errorf(codes.Internal, "grit cri shim: %v", err)
+		}
+	}
+
+	if err := serverStream.SendHeader(header); err != nil {
+		return err
+	}
+	serverStream.SetTrailer(trailer)
+	return serverStream.SendMsg(resp)
+}
+
+func unixTarget(endpoint string) string {
+	if strings.HasPrefix(endpoint, "unix://") {
+		return endpoint
+	}
+	return "unix://" + endpoint
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package crishim
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+// fakeRuntimeService is the backend runtime which records the requests forwarded by the proxy.
+type fakeRuntimeService struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+	created atomic.Int32
+}
+
+func (f *fakeRuntimeService) Version(context.Context, *runtimeapi.VersionRequest) (*runtimeapi.VersionResponse, error) {
+	return &runtimeapi.VersionResponse{RuntimeName: "fake"}, nil
+}
+
+func (f *fakeRuntimeService) CreateContainer(context.Context, *runtimeapi.CreateContainerRequest) (*runtimeapi.CreateContainerResponse, error) {
+	f.created.Add(1)
+	return &runtimeapi.CreateContainerResponse{ContainerId: "container"}, nil
+}
+
+// startProxy starts the fake runtime and the proxy in front of it, and returns a client connected to the proxy.
+func startProxy(t *testing.T) (*fakeRuntimeService, runtimeapi.RuntimeServiceClient) {
+	// unix socket path is limited to 108 bytes, so sockets are not created in t.TempDir().
+	dir, err := os.MkdirTemp("", "crishim")
+	if err != nil {
+		t.Fatalf("failed to create socket dir, %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	runtimeEndpoint := filepath.Join(dir, "runtime.sock")
+	lis, err := net.Listen("unix", runtimeEndpoint)
+	if err != nil {
+		t.Fatalf("failed to listen on %s, %v", runtimeEndpoint, err)
+	}
+	runtime := &fakeRuntimeService{}
+	server := grpc.NewServer()
+	runtimeapi.RegisterRuntimeServiceServer(server, runtime)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	listenAddress := filepath.Join(dir, "shim.sock")
+	proxy, err := NewProxy(Options{ListenAddress: listenAddress, RuntimeEndpoint: runtimeEndpoint})
+	if err != nil {
+		t.Fatalf("failed to create proxy, %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go proxy.Run(ctx)
+
+	conn, err := grpc.NewClient(unixTarget(listenAddress), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to connect to proxy, %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return runtime, runtimeapi.NewRuntimeServiceClient(conn)
+}
+
+func TestProxy(t *testing.T) {
+	runtime, client := startProxy(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("request is forwarded", func(t *testing.T) {
+		resp, err := client.Version(ctx, &runtimeapi.VersionRequest{}, grpc.WaitForReady(true))
+		if err != nil || resp.GetRuntimeName() != "fake" {
+			t.Fatalf("expected version request to be forwarded, got %v, %v", resp, err)
+		}
+	})
+
+	t.Run("container of normal pod is created", func(t *testing.T) {
+		created := runtime.created.Load()
+		req := &runtimeapi.CreateContainerRequest{SandboxConfig: &runtimeapi.PodSandboxConfig{}, Config: &runtimeapi.ContainerConfig{}}
+		if _, err := client.CreateContainer(ctx, req); err != nil {
+			t.Fatalf("expected container to be created, %v", err)
+		}
+		if runtime.created.Load() != created+1 {
+			t.Fatalf("expected create container request to be forwarded")
+		}
+	})
+
+	t.Run("container of restoration pod is created after checkpointed data is ready", func(t *testing.T) {
+		checkpointPath := t.TempDir()
+		req := &runtimeapi.CreateContainerRequest{
+			SandboxConfig: &runtimeapi.PodSandboxConfig{Annotations: map[string]string{v1alpha1.CheckpointDataPathLabel: checkpointPath}},
+			Config:        &runtimeapi.ContainerConfig{Metadata: &runtimeapi.ContainerMetadata{Name: "app"}},
+		}
+
+		created := runtime.created.Load()
+		if err := metadata.WriteDownloadState(checkpointPath, &metadata.DownloadState{Phase: metadata.DownloadPhaseDownloading}); err != nil {
+			t.Fatalf("failed to write download state, %v", err)
+		}
+		if _, err := client.CreateContainer(ctx, req); err == nil {
+			t.Fatalf("expected create container to fail while checkpointed data is downloading")
+		}
+		if runtime.created.Load() != created {
+			t.Fatalf("expected create container request not to be forwarded")
+		}
+
+		if err := metadata.WriteDownloadState(checkpointPath, &metadata.DownloadState{Phase: metadata.DownloadPhaseReady}); err != nil {
+			t.Fatalf("failed to write download state, %v", err)
+		}
+		if _, err := client.CreateContainer(ctx, req); err != nil {
+			t.Fatalf("expected container to be created, %v", err)
+		}
+		if runtime.created.Load() != created+1 {
+			t.Fatalf("expected create container request to be forwarded")
+		}
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kaito-project/grit/pkg/util/nonce"
+)
+
+const (
+	// ExpiresMetadataKey, NonceMetadataKey and SignatureMetadataKey are used for authenticating requests from
+	// grit-manager, the signature is the hmac-sha256 of method, nonce, expiration time and request body with the
+	// secret shared by grit-manager and grit agent daemons. grit agent daemon rejects a nonce which has been used,
+	// so a captured request can't be replayed.
+	ExpiresMetadataKey   = "x-grit-expires"
+	NonceMetadataKey     = "x-grit-nonce"
+	SignatureMetadataKey = "x-grit-signature"
+
+	// signatureTTL is the lifetime of a signed request, it also tolerates clock skew between nodes.
+	signatureTTL = 5 * time.Minute
+)
+
+// LoadSecret reads the secret shared by grit-manager and grit agent daemons from file, the secret is mounted
+// from a kubernetes secret.
+func LoadSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := []byte(strings.TrimSpace(string(data)))
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret in file %s is empty", path)
+	}
+	return secret, nil
+}
+
+func sign(secret []byte, method, nonce string, expires int64, req any) ([]byte, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n", method, nonce, expires)
+	mac.Write(body)
+	return mac.Sum(nil), nil
+}
+
+// UnaryClientInterceptor signs every request to grit agent daemon with the shared secret.
+func UnaryClientInterceptor(secret []byte) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		n, err := nonce.New()
+		if err != nil {
+			return err
+		}
+		expires := time.Now().Add(signatureTTL).Unix()
+		signature, err := sign(secret, method, n, expires, req)
+		if err != nil {
+			return err
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, ExpiresMetadataKey, strconv.FormatInt(expires, 10), NonceMetadataKey, n, SignatureMetadataKey, hex.EncodeToString(signature))
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// UnaryServerInterceptor rejects requests which are not signed with the shared secret, expired or replayed.
+func UnaryServerInterceptor(secret []byte) grpc.UnaryServerInterceptor {
+	nonces := nonce.NewCache()
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := verify(ctx, secret, nonces, info.FullMethod, req, time.Now()); err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+func verify(ctx context.Context, secret []byte, nonces *nonce.Cache, method string, req any, now time.Time) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(ExpiresMetadataKey)
+	if len(values) != 1 {
+		return fmt.Errorf("missing %s metadata", ExpiresMetadataKey)
+	}
+	expires, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s metadata", ExpiresMetadataKey)
+	}
+	if expires < now.Unix() || expires > now.Add(2*signatureTTL).Unix() {
+		return errors.New("request signature is expired")
+	}
+
+	values = md.Get(NonceMetadataKey)
+	if len(values) != 1 || len(values[0]) == 0 {
+		return fmt.Errorf("missing %s metadata", NonceMetadataKey)
+	}
+	n := values[0]
+
+	values = md.Get(SignatureMetadataKey)
+	if len(values) != 1 {
+		return fmt.Errorf("missing %s metadata", SignatureMetadataKey)
+	}
+	signature, err := hex.DecodeString(values[0])
+	if err != nil {
+		return fmt.Errorf("invalid %s metadata", SignatureMetadataKey)
+	}
+
+	expected, err := sign(secret, method, n, expires, req)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(signature, expected) {
+		return errors.New("request signature mismatch")
+	}
+
+	// only record the nonce of a verified request, so unsigned requests can't fill up the cache.
+	if !nonces.Add(n, time.Unix(expires, 0), now) {
+		return errors.New("request is replayed")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/kaito-project/grit/pkg/util/nonce"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	method := "/" + ServiceName + "/Restore"
+	req := &RestoreRequest{ID: "restore/default/demo", DstDir: "/mnt/grit-agent/default/demo"}
+	now := time.Now()
+
+	signedContext := func(secret []byte, req any, expires int64) context.Context {
+		n, err := nonce.New()
+		if err != nil {
+			t.Fatalf("failed to generate nonce, %v", err)
+		}
+		signature, err := sign(secret, method, n, expires, req)
+		if err != nil {
+			t.Fatalf("failed to sign request, %v", err)
+		}
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(ExpiresMetadataKey, strconv.FormatInt(expires, 10), NonceMetadataKey, n, SignatureMetadataKey, hex.EncodeToString(signature)))
+	}
+	expires := now.Add(signatureTTL).Unix()
+
+	t.Run("signed request", func(t *testing.T) {
+		if err := verify(signedContext(secret, req, expires), secret, nonce.NewCache(), method, req, now); err != nil {
+			t.Fatalf("expected request is verified, but got %v", err)
+		}
+	})
+
+	t.Run("unsigned request", func(t *testing.T) {
+		if err := verify(context.Background(), secret, nonce.NewCache(), method, req, now); err == nil {
+			t.Fatalf("expected unsigned request is rejected")
+		}
+	})
+
+	t.Run("request signed with another secret", func(t *testing.T) {
+		if err := verify(signedContext([]byte("another"), req, expires), secret, nonce.NewCache(), method, req, now); err == nil {
+			t.Fatalf("expected request signed with another secret is rejected")
+		}
+	})
+
+	t.Run("request body is modified", func(t *testing.T) {
+		modified := *req
+		modified.DstDir = "/etc"
+		if err := verify(signedContext(secret, req, expires), secret, nonce.NewCache(), method, &modified, now); err == nil {
+			t.Fatalf("expected modified request is rejected")
+		}
+	})
+
+	t.Run("expired request", func(t *testing.T) {
+		if err := verify(signedContext(secret, req, now.Add(-time.Second).Unix()), secret, nonce.NewCache(), method, req, now); err == nil {
+			t.Fatalf("expected expired request is rejected")
+		}
+	})
+	t.Run("replayed request", func(t *testing.T) {
+		nonces := nonce.NewCache()
+		ctx := signedContext(secret, req, expires)
+		if err := verify(ctx, secret, nonces, method, req, now); err != nil {
+			t.Fatalf("expected request is verified, but got %v", err)
+		}
+		if err := verify(ctx, secret, nonces, method, req, now); err == nil {
+			t.Fatalf("expected replayed request is rejected")
+		}
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	ServiceName = "grit.agent.v1alpha1.Agent"
+	// CodecName is the content-subtype of grit agent api, messages are encoded in json
+	// so there is no need to maintain generated protobuf code.
+	CodecName = "json"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return CodecName
+}
+
+// AgentServer is the server API for grit agent daemon.
+type AgentServer interface {
+	Checkpoint(context.Context, *CheckpointRequest) (*OperationStatus, error)
+	Restore(context.Context, *RestoreRequest) (*OperationStatus, error)
+	Prestage(context.Context, *RestoreRequest) (*OperationStatus, error)
+	Status(context.Context, *StatusRequest) (*OperationStatus, error)
+	Cleanup(context.Context, *CleanupRequest) (*CleanupResponse, error)
+}
+
+func RegisterAgentServer(s grpc.ServiceRegistrar, srv AgentServer) {
+	s.RegisterService(&agentServiceDesc, srv)
+}
+
+var agentServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Checkpoint",
+			Handler: unaryHandler("Checkpoint", func(ctx context.Context, srv AgentServer, req *CheckpointRequest) (any, error) {
+				return srv.Checkpoint(ctx, req)
+			}),
+		},
+		{
+			MethodName: "Restore",
+			Handler: unaryHandler("Restore", func(ctx context.Context, srv AgentServer, req *RestoreRequest) (any, error) {
+				return srv.Restore(ctx, req)
+			}),
+		},
+		{
+			MethodName: "Prestage",
+			Handler: unaryHandler("Prestage", func(ctx context.Context, srv AgentServer, req *RestoreRequest) (any, error) {
+				return srv.Prestage(ctx, req)
+			}),
+		},
+		{
+			MethodName: "Status",
+			Handler: unaryHandler("Status", func(ctx context.Context, srv AgentServer, req *StatusRequest) (any, error) {
+				return srv.Status(ctx, req)
+			}),
+		},
+		{
+			MethodName: "Cleanup",
+			Handler: unaryHandler("Cleanup", func(ctx context.Context, srv AgentServer, req *CleanupRequest) (any, error) {
+				return srv.Cleanup(ctx, req)
+			}),
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+func unaryHandler[T any](method string, call func(context.Context, AgentServer, *T) (any, error)) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := new(T)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(ctx, srv.(AgentServer), req)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fullMethod(method),
+		}
+		return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+			return call(ctx, srv.(AgentServer), req.(*T))
+		})
+	}
+}
+
+func fullMethod(method string) string {
+	return "/" + ServiceName + "/" + method
+}
+
+// AgentClient is the client API for grit agent daemon.
+type AgentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentClient(cc grpc.ClientConnInterface) *AgentClient {
+	return &AgentClient{cc: cc}
+}
+
+func (c *AgentClient) Checkpoint(ctx context.Context, req *CheckpointRequest) (*OperationStatus, error) {
+	out := new(OperationStatus)
+	if err := c.cc.Invoke(ctx, fullMethod("Checkpoint"), req, out, grpc.CallContentSubtype(CodecName)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *AgentClient) Restore(ctx context.Context, req *RestoreRequest) (*OperationStatus, error) {
+	out := new(OperationStatus)
+	if err := c.cc.Invoke(ctx, fullMethod("Restore"), req, out, grpc.CallContentSubtype(CodecName)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *AgentClient) Prestage(ctx context.Context, req *RestoreRequest) (*OperationStatus, error) {
+	out := new(OperationStatus)
+	if err := c.cc.Invoke(ctx, fullMethod("Prestage"), req, out, grpc.CallContentSubtype(CodecName)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *AgentClient) Status(ctx context.Context, req *StatusRequest) (*OperationStatus, error) {
+	out := new(OperationStatus)
+	if err := c.cc.Invoke(ctx, fullMethod("Status"), req, out, grpc.CallContentSubtype(CodecName)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *AgentClient) Cleanup(ctx context.Context, req *CleanupRequest) (*CleanupResponse, error) {
+	out := new(CleanupResponse)
+	if err := c.cc.Invoke(ctx, fullMethod("Cleanup"), req, out, grpc.CallContentSubtype(CodecName)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,117 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package api defines the gRPC API served by grit-agent when it runs as a node daemon.
+package api
+
+import (
+	"time"
+)
+
+type OperationPhase string
+
+const (
+	OperationRunning   OperationPhase = "Running"
+	OperationSucceeded OperationPhase = "Succeeded"
+	OperationFailed    OperationPhase = "Failed"
+)
+
+// CheckpointRequest is used for checkpointing a pod on the node where grit agent daemon is running.
+type CheckpointRequest struct {
+	// ID is used for identifying the operation, grit-manager uses <namespace>/<name> of Checkpoint resource.
+	ID                 string `json:"id"`
+	TargetPodNamespace string `json:"targetPodNamespace"`
+	TargetPodName      string `json:"targetPodName"`
+	TargetPodUID       string `json:"targetPodUID"`
+	// SrcDir is the directory on the host for storing checkpointed data temporarily.
+	SrcDir string `json:"srcDir"`
+	// DstDir is the directory in cloud storage which checkpointed data will be transferred to.
+	DstDir string `json:"dstDir"`
+	// HostWorkPath is the work path on the host for checkpointing.
+	HostWorkPath string `json:"hostWorkPath"`
+	// CheckpointName is the name of Checkpoint resource, it's used for naming VolumeSnapshots.
+	CheckpointName string `json:"checkpointName,omitempty"`
+	// VolumeSnapshotClaims are pvcs of the target pod which VolumeSnapshots are taken for while the pod is frozen.
+	VolumeSnapshotClaims    []string `json:"volumeSnapshotClaims,omitempty"`
+	VolumeSnapshotClassName string   `json:"volumeSnapshotClassName,omitempty"`
+	// Stream makes criu images be streamed into DstDir directly instead of staging them in SrcDir.
+	Stream bool `json:"stream,omitempty"`
+	// BandwidthLimit is the max rate of transferring checkpointed data in bytes per second, the limit of grit
+	// agent daemon is used if it's 0.
+	BandwidthLimit int64 `json:"bandwidthLimit,omitempty"`
+}
+
+// RestoreRequest is used for downloading checkpointed data to the node where restoration pod is located,
+// it's also used for pre-staging checkpointed data to candidate nodes of restoration pod.
+type RestoreRequest struct {
+	// ID is used for identifying the operation, grit-manager uses <namespace>/<name> of Restore resource.
+	ID string `json:"id"`
+	// SrcDir is the directory in cloud storage where checkpointed data is stored.
+	SrcDir string `json:"srcDir"`
+	// DstDir is the directory on the host which checkpointed data will be downloaded to.
+	DstDir string `json:"dstDir"`
+	// PeerURL is the url of checkpointed data served by grit agent daemon on the node where the pod is
+	// checkpointed. checkpointed data is pulled from the peer first, and SrcDir is used as a fallback.
+	PeerURL string `json:"peerURL,omitempty"`
+	// LazyPages makes restoration pod start before memory pages are pulled from the peer, it only takes
+	// effect when PeerURL is specified.
+	LazyPages bool `json:"lazyPages,omitempty"`
+	// BandwidthLimit is the max rate of transferring checkpointed data in bytes per second, the limit of grit
+	// agent daemon is used if it's 0.
+	BandwidthLimit int64 `json:"bandwidthLimit,omitempty"`
+}
+
+type StatusRequest struct {
+	ID string `json:"id"`
+}
+
+// CleanupRequest is used for removing the operation record from grit agent daemon.
+// if RemoveData is true, host directory used by the operation will be removed as well.
+type CleanupRequest struct {
+	ID         string `json:"id"`
+	RemoveData bool   `json:"removeData,omitempty"`
+	// HostDir is removed when the operation is not found(like grit agent daemon has been restarted) and
+	// RemoveData is true, it should be under host path.
+	HostDir string `json:"hostDir,omitempty"`
+}
+
+type CleanupResponse struct{}
+
+// OperationStatus represents the current state of an operation in grit agent daemon.
+type OperationStatus struct {
+	ID             string         `json:"id"`
+	Action         string         `json:"action"`
+	Phase          OperationPhase `json:"phase"`
+	Message        string         `json:"message,omitempty"`
+	StartTime      time.Time      `json:"startTime"`
+	CompletionTime *time.Time     `json:"completionTime,omitempty"`
+	// EstimatedSize is the estimated size of checkpointed data in bytes, it's reported when checkpoint operation
+	// is completed, including the operation which is refused for insufficient disk space.
+	EstimatedSize int64 `json:"estimatedSize,omitempty"`
+}
+
+// JobResult is written into termination message by grit agent job, so grit-manager gets the result of the operation
+// in grit agent job as it's reported by OperationStatus in grit agent daemon.
+type JobResult struct {
+	// Message is the failure reason of the operation.
+	Message string `json:"message,omitempty"`
+	// EstimatedSize is the estimated size of checkpointed data in bytes for checkpoint operation.
+	EstimatedSize int64 `json:"estimatedSize,omitempty"`
+	// Metrics are observed by the operation. grit agent job exits after the operation, so they are recorded by
+	// grit-manager instead of being scraped from grit agent.
+	Metrics *OperationMetrics `json:"metrics,omitempty"`
+}
+
+// OperationMetrics are the observations of grit agent metrics in an operation.
+type OperationMetrics struct {
+	PodFreezeSeconds float64           `json:"podFreezeSeconds,omitempty"`
+	CheckpointBytes  int64             `json:"checkpointBytes,omitempty"`
+	Transfers        []TransferMetrics `json:"transfers,omitempty"`
+}
+
+// TransferMetrics is a completed transfer of checkpointed data, direction is upload or download.
+type TransferMetrics struct {
+	Direction string  `json:"direction"`
+	Bytes     int64   `json:"bytes"`
+	Seconds   float64 `json:"seconds"`
+}
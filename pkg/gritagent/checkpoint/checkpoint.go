@@ -5,17 +5,86 @@ package checkpoint
 
 import (
 	"context"
+	"errors"
+	"sync/atomic"
+	"time"
 
 	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
 	"github.com/kaito-project/grit/pkg/gritagent/copy"
+	"github.com/kaito-project/grit/pkg/gritagent/metrics"
+	"github.com/kaito-project/grit/pkg/metadata"
 )
 
 func RunCheckpoint(ctx context.Context, opts *options.GritAgentOptions) error {
+	opts.StorageDir = opts.DstDir
+
+	// criu images are streamed into cloud storage directly, and other checkpointed data is transferred later.
+	if opts.Stream {
+		if len(opts.DstDir) == 0 {
+			return errors.New("cloud storage should be specified for streaming criu images")
+		}
+		opts.StreamDir = opts.DstDir
+		return runStreamCheckpoint(ctx, opts)
+	}
+
 	// execute checkpoint
 	if err := RuntimeCheckpointPod(ctx, &opts.RuntimeCheckpointOptions); err != nil {
 		return err
 	}
 
-	// transfer checkpointed data to cloud storage
-	return copy.TransferData(ctx, opts.SrcDir, opts.DstDir)
+	manifest, err := metadata.GenerateManifest(opts.SrcDir)
+	if err != nil {
+		return err
+	}
+	metrics.ObserveCheckpointSize(manifest.TotalBytes())
+
+	// checkpointed data is kept on the node only if cloud storage is not specified.
+	if len(opts.DstDir) != 0 {
+		// transfer checkpointed data to cloud storage
+		if err := uploadData(ctx, opts.SrcDir, opts.DstDir); err != nil {
+			return err
+		}
+
+		// manifest is written after all data is transferred, it's used for verifying downloaded data when restoring.
+		if err := metadata.WriteManifest(opts.DstDir, manifest); err != nil {
+			return err
+		}
+	}
+
+	// manifest is also kept on the node, so checkpointed data can be verified in place when the pod is
+	// restored on the same node.
+	return metadata.WriteManifest(opts.SrcDir, manifest)
+}
+
+// runStreamCheckpoint checkpoints the pod with criu images streamed into cloud storage. checkpointed data on the
+// node is incomplete without criu images, so manifest is only written into cloud storage, and the data on the
+// node is neither served to peers nor restored in place.
+func runStreamCheckpoint(ctx context.Context, opts *options.GritAgentOptions) error {
+	if err := RuntimeCheckpointPod(ctx, &opts.RuntimeCheckpointOptions); err != nil {
+		return err
+	}
+
+	if err := uploadData(ctx, opts.SrcDir, opts.DstDir); err != nil {
+		return err
+	}
+
+	manifest, err := metadata.GenerateManifest(opts.DstDir)
+	if err != nil {
+		return err
+	}
+	metrics.ObserveCheckpointSize(manifest.TotalBytes())
+	return metadata.WriteManifest(opts.DstDir, manifest)
+}
+
+// uploadData transfers checkpointed data to cloud storage, and records the throughput of the transfer.
+func uploadData(ctx context.Context, srcDir, dstDir string) error {
+	var transferred atomic.Int64
+	start := time.Now()
+	if err := copy.TransferData(ctx, srcDir, dstDir, func(copiedBytes int64) {
+		transferred.Add(copiedBytes)
+	}); err != nil {
+		return err
+	}
+	metrics.ObserveTransfer(metrics.DirectionUpload, transferred.Load(), time.Since(start))
+	return nil
 }
@@ -0,0 +1,157 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/containerd/cgroups/v3/cgroup1/stats"
+	v2 "github.com/containerd/cgroups/v3/cgroup2/stats"
+	containerd "github.com/containerd/containerd/v2/client"
+	"github.com/containerd/typeurl/v2"
+	"golang.org/x/sys/unix"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+// preflightCheckpoint estimates the size of checkpointed data and checks free space on the node and in storage
+// before containers are paused, so checkpoint is refused early instead of failing halfway with a paused pod.
+// the estimate is recorded in pod checkpoint directory even if checkpoint is refused.
+func preflightCheckpoint(ctx context.Context, client *containerd.Client, containers []*runtimeapi.Container, opts *options.RuntimeCheckpointOptions) error {
+	estimate := &metadata.SizeEstimate{}
+	for _, container := range containers {
+		memory, rootfsDiff, err := estimateContainerSize(ctx, client, container.Id)
+		if err != nil {
+			return fmt.Errorf("failed to estimate checkpoint size of container %s: %w", container.Id, err)
+		}
+		estimate.MemoryBytes += memory
+		estimate.RootfsDiffBytes += rootfsDiff
+	}
+
+	// emptyDir volumes and /dev/shm are archived into pod checkpoint as well.
+	volumes, err := podVolumes(ctx, containers, client)
+	if err != nil {
+		return fmt.Errorf("failed to estimate checkpoint size of pod volumes: %w", err)
+	}
+	for _, source := range volumes {
+		estimate.VolumeBytes += dirSize(source)
+	}
+	log.FromContext(ctx).Info("Checkpointing pod", "step", "preflight", "memoryBytes", estimate.MemoryBytes, "rootfsDiffBytes", estimate.RootfsDiffBytes, "volumeBytes", estimate.VolumeBytes)
+
+	if err := metadata.WriteSizeEstimate(opts.HostWorkPath, estimate); err != nil {
+		return fmt.Errorf("failed to write size estimate: %w", err)
+	}
+
+	// criu images are not staged on the node when they are streamed into storage.
+	hostRequired := estimate.Total()
+	if len(opts.StreamDir) != 0 {
+		hostRequired = estimate.RootfsDiffBytes + estimate.VolumeBytes
+	}
+	if err := checkFreeSpace(opts.HostWorkPath, hostRequired); err != nil {
+		return err
+	}
+	if len(opts.StorageDir) != 0 {
+		return checkFreeSpace(opts.StorageDir, estimate.Total())
+	}
+	return nil
+}
+
+// estimateContainerSize returns the size of memory which is dumped by criu and the size of rootfs diff.
+func estimateContainerSize(ctx context.Context, client *containerd.Client, id string) (int64, int64, error) {
+	container, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	metric, err := task.Metrics(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get metrics: %w", err)
+	}
+	data, err := typeurl.UnmarshalAny(metric.Data)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	// file backed pages are not dumped by criu, so only anonymous and shared memory are counted.
+	var memory uint64
+	switch m := data.(type) {
+	case *v1.Metrics:
+		memory = m.GetMemory().GetTotalRSS()
+	case *v2.Metrics:
+		memory = m.GetMemory().GetAnon() + m.GetMemory().GetShmem()
+	default:
+		return 0, 0, fmt.Errorf("unknown metrics type %T", data)
+	}
+
+	info, err := container.Info(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	usage, err := client.SnapshotService(info.Snapshotter).Usage(ctx, info.SnapshotKey)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get usage of snapshot %s: %w", info.SnapshotKey, err)
+	}
+	return int64(memory), usage.Size, nil
+}
+
+// dirSize returns the total size of regular files under dir, which is the size of the tar archive of dir
+// without headers.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// checkFreeSpace checks whether free space of the filesystem where dir is located is enough for required bytes,
+// dir may not be created yet, so free space of the nearest existing parent is checked.
+func checkFreeSpace(dir string, required int64) error {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return errors.New("there is no existing parent directory")
+		}
+		dir = parent
+	}
+
+	available, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get free space of %s: %w", dir, err)
+	}
+	if available < required {
+		return fmt.Errorf("%w: checkpointed data is estimated to be %d bytes, but only %d bytes are available in %s", metadata.ErrInsufficientDiskSpace, required, available, dir)
+	}
+	return nil
+}
+
+func freeSpace(dir string) (int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
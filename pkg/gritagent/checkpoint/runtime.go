@@ -4,12 +4,15 @@
 package checkpoint
 
 import (
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"regexp"
 	"sort"
+	"strconv"
 	"time"
 
 	crmetadata "github.com/checkpoint-restore/checkpointctl/lib"
@@ -28,7 +31,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
+	"github.com/kaito-project/grit/pkg/gritagent/metrics"
 	"github.com/kaito-project/grit/pkg/metadata"
+	"github.com/kaito-project/grit/pkg/util/archive"
 )
 
 func RuntimeCheckpointPod(ctx context.Context, opts *options.RuntimeCheckpointOptions) error {
@@ -59,14 +64,171 @@ func RuntimeCheckpointPod(ctx context.Context, opts *options.RuntimeCheckpointOp
 		return fmt.Errorf("no containers found for pod %s/%s", opts.TargetPodNamespace, opts.TargetPodName)
 	}
 
+	ctx = namespaces.WithNamespace(ctx, "k8s.io")
+	if err := preflightCheckpoint(ctx, ctrClient, containers, opts); err != nil {
+		return err
+	}
+
+	// pause all containers of the pod, so processes of containers and pod volumes are checkpointed
+	// at the same point. containers are resumed after checkpointing.
+	tasks := make(map[string]containerd.Task, len(containers))
+	frozenTime := time.Now()
+	defer func() {
+		for id, task := range tasks {
+			if err := task.Resume(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "failed to resume task", "container", id)
+			}
+		}
+		if len(tasks) != 0 {
+			metrics.ObservePodFreeze(time.Since(frozenTime))
+		}
+	}()
+	for _, container := range containers {
+		task, err := pauseContainer(ctx, ctrClient, container.Id)
+		if err != nil {
+			return fmt.Errorf("failed to pause container %s: %w", container.Id, err)
+		}
+		tasks[container.Id] = task
+	}
+
 	// checkpoint each container
-	// TODO: consider consistency problems when checkpointing multiple containers
 	for _, container := range containers {
-		if err := runtimeCheckpointContainer(ctx, container, ctrClient, opts); err != nil {
+		if err := runtimeCheckpointContainer(ctx, container, ctrClient, tasks[container.Id], opts); err != nil {
 			return fmt.Errorf("failed to checkpoint container %s: %w", container.Id, err)
 		}
 	}
 
+	// record images which rootfs diffs are based on
+	if err := writeContainerImages(ctx, criClient, containers, opts); err != nil {
+		return fmt.Errorf("failed to write container images: %w", err)
+	}
+
+	// archive emptyDir and /dev/shm volumes of the pod
+	if err := writePodVolumes(ctx, containers, ctrClient, opts); err != nil {
+		return fmt.Errorf("failed to write pod volumes: %w", err)
+	}
+
+	// take snapshots of pvcs before containers are resumed
+	if len(opts.VolumeSnapshotClaims) != 0 {
+		sources, err := podMountSources(ctx, containers, ctrClient)
+		if err != nil {
+			return fmt.Errorf("failed to get mounts of pod: %w", err)
+		}
+		if err := snapshotVolumeClaims(ctx, sources, opts); err != nil {
+			return fmt.Errorf("failed to take volume snapshots: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func pauseContainer(ctx context.Context, client *containerd.Client, id string) (containerd.Task, error) {
+	container, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load container %s: %w", id, err)
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := task.Pause(ctx); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// writePodVolumes archives emptyDir volumes and /dev/shm of the pod into the volumes directory of
+// pod checkpoint. volumes are located by mounts in the oci spec of containers, and volumes which are
+// shared by multiple containers are archived only once.
+func writePodVolumes(ctx context.Context, containers []*runtimeapi.Container, client *containerd.Client, opts *options.RuntimeCheckpointOptions) error {
+	volumes, err := podVolumes(ctx, containers, client)
+	if err != nil {
+		return err
+	}
+
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(path.Join(opts.HostWorkPath, metadata.VolumesDirectory), 0755); err != nil {
+		return err
+	}
+	for name, source := range volumes {
+		log.FromContext(ctx).Info("Checkpointing pod", "step", "archive volume", "volume", name, "source", source)
+		if err := archive.TarDirectory(source, metadata.VolumeArchivePath(opts.HostWorkPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podVolumes returns emptyDir volumes and /dev/shm of the pod which are archived into pod checkpoint, it maps
+// the archived volume name to the volume directory on the host.
+func podVolumes(ctx context.Context, containers []*runtimeapi.Container, client *containerd.Client) (map[string]string, error) {
+	volumes := make(map[string]string)
+	for _, ctrmeta := range containers {
+		container, err := client.LoadContainer(ctx, ctrmeta.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load container %s: %w", ctrmeta.Id, err)
+		}
+		spec, err := container.Spec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get spec of container %s: %w", ctrmeta.Id, err)
+		}
+		for _, m := range spec.Mounts {
+			if name := metadata.ArchivedVolumeName(m.Destination, m.Source); len(name) != 0 {
+				volumes[name] = m.Source
+			}
+		}
+	}
+	return volumes, nil
+}
+
+// podMountSources returns the mount sources on the host of all containers of the pod.
+func podMountSources(ctx context.Context, containers []*runtimeapi.Container, client *containerd.Client) ([]string, error) {
+	var sources []string
+	for _, ctrmeta := range containers {
+		container, err := client.LoadContainer(ctx, ctrmeta.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load container %s: %w", ctrmeta.Id, err)
+		}
+		spec, err := container.Spec(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get spec of container %s: %w", ctrmeta.Id, err)
+		}
+		for _, m := range spec.Mounts {
+			sources = append(sources, m.Source)
+		}
+	}
+	return sources, nil
+}
+
+// writeContainerImages records image of each container into the container checkpoint directory. image
+// reference in container status is resolved to repository digest by container runtime, so the restoration
+// container can be pinned to the same image even if the tag is re-pushed.
+func writeContainerImages(ctx context.Context, criClient internalapi.RuntimeService, containers []*runtimeapi.Container, opts *options.RuntimeCheckpointOptions) error {
+	for _, container := range containers {
+		resp, err := criClient.ContainerStatus(ctx, container.Id, false)
+		if err != nil {
+			return fmt.Errorf("failed to get status of container %s: %w", container.Id, err)
+		}
+
+		image := &metadata.ContainerImage{
+			Image:    resp.GetStatus().GetImage().GetUserSpecifiedImage(),
+			ImageRef: resp.GetStatus().GetImageRef(),
+		}
+		if len(image.Image) == 0 {
+			image.Image = resp.GetStatus().GetImage().GetImage()
+		}
+		if len(image.Digest()) == 0 {
+			log.FromContext(ctx).Info("image of container is not pinned by digest", "container", container.Id, "image", image.Image, "imageRef", image.ImageRef)
+		}
+
+		checkpointDir := path.Join(opts.HostWorkPath, container.GetMetadata().GetName())
+		if err := metadata.WriteContainerImage(checkpointDir, image); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -87,7 +249,7 @@ func getContainerdClient(ctx context.Context, opts *options.RuntimeCheckpointOpt
 	return containerd.New(opts.RuntimeEndpoint, ctrOpts...)
 }
 
-func runtimeCheckpointContainer(ctx context.Context, ctrmeta *runtimeapi.Container, client *containerd.Client, opts *options.RuntimeCheckpointOptions) error {
+func runtimeCheckpointContainer(ctx context.Context, ctrmeta *runtimeapi.Container, client *containerd.Client, task containerd.Task, opts *options.RuntimeCheckpointOptions) error {
 	// checkpoint to a temporary directory, then perform a rename to ensure atomicity
 	workPath := path.Join(opts.HostWorkPath, ctrmeta.GetMetadata().GetName()+"-work")
 	logger := log.FromContext(ctx).WithValues("container", ctrmeta.Id, "workPath", workPath)
@@ -97,32 +259,19 @@ func runtimeCheckpointContainer(ctx context.Context, ctrmeta *runtimeapi.Contain
 		return fmt.Errorf("failed to create work path %s: %w", workPath, err)
 	}
 
-	logger.Info("Checkpointing container", "step", "pause container")
-	ctx = namespaces.WithNamespace(ctx, "k8s.io")
-	container, err := client.LoadContainer(ctx, ctrmeta.Id)
-	if err != nil {
-		return fmt.Errorf("failed to load container %s: %w", ctrmeta.Id, err)
-	}
-	task, err := container.Task(ctx, nil)
-	if err != nil {
-		return err
-	}
-	// pause if running
-	if task != nil {
-		if err := task.Pause(ctx); err != nil {
-			return err
-		}
-		defer func() {
-			if err := task.Resume(ctx); err != nil {
-				log.FromContext(ctx).Error(err, "failed to resume task", "container", ctrmeta.Id)
-			}
-		}()
-	}
-
-	// dump criu image
+	// dump criu image, criu images are streamed into storage directly in stream mode.
 	logger.Info("Checkpointing container", "step", "criu dump")
 	checkpointPath := path.Join(workPath, crmetadata.CheckpointDirectory)
-	if err := writeCriuCheckpoint(ctx, task, checkpointPath, workPath); err != nil {
+	if len(opts.StreamDir) != 0 {
+		streamPath := metadata.ImagesStreamPath(path.Join(opts.StreamDir, ctrmeta.GetMetadata().GetName()))
+		waitCapture, err := captureImages(ctx, checkpointPath, streamPath)
+		if err != nil {
+			return fmt.Errorf("failed to capture criu images: %w", err)
+		}
+		if err := waitCapture(writeCriuCheckpoint(ctx, task, checkpointPath, workPath)); err != nil {
+			return fmt.Errorf("failed to write criu checkpoint: %w", err)
+		}
+	} else if err := writeCriuCheckpoint(ctx, task, checkpointPath, workPath); err != nil {
 		return fmt.Errorf("failed to write criu checkpoint: %w", err)
 	}
 
@@ -227,20 +376,46 @@ func getPodLogPath(opts *options.RuntimeCheckpointOptions) string {
 	return path.Join(opts.KubeletLogPath, fmt.Sprintf("%s_%s_%s", opts.TargetPodNamespace, opts.TargetPodName, opts.TargetPodUID))
 }
 
+// kubelet names container log files as <restart count>.log, and rotated log files as
+// <restart count>.log.<timestamp>, which may be compressed with gzip.
+var containerLogFileRegexp = regexp.MustCompile(`^(\d+)\.log(?:\.(\d{8}-\d{6}))?(\.gz)?$`)
+
+type containerLogFile struct {
+	name         string
+	restartCount int
+	// rotatedAt is empty for the log file which is being written.
+	rotatedAt  string
+	compressed bool
+}
+
+// writeContainerLog concatenates all log files of the container into savePath in the order of
+// restart count and rotation time, compressed log files are decompressed. an index file which
+// records the position of each log file is written next to savePath.
 func writeContainerLog(ctx context.Context, logdir, savePath string) error {
 	files, err := os.ReadDir(logdir)
 	if err != nil {
 		return fmt.Errorf("failed to read log directory %s: %w", logdir, err)
 	}
 
-	var logFiles []string
+	var logFiles []containerLogFile
 	for _, file := range files {
 		if file.IsDir() {
 			continue
 		}
-		if path.Ext(file.Name()) == ".log" {
-			logFiles = append(logFiles, file.Name())
+		matches := containerLogFileRegexp.FindStringSubmatch(file.Name())
+		if matches == nil {
+			continue
+		}
+		restartCount, err := strconv.Atoi(matches[1])
+		if err != nil {
+			continue
 		}
+		logFiles = append(logFiles, containerLogFile{
+			name:         file.Name(),
+			restartCount: restartCount,
+			rotatedAt:    matches[2],
+			compressed:   len(matches[3]) != 0,
+		})
 	}
 
 	if len(logFiles) == 0 {
@@ -248,15 +423,17 @@ func writeContainerLog(ctx context.Context, logdir, savePath string) error {
 		return nil
 	}
 
-	sort.Strings(logFiles)
-
-	srcPath := path.Join(logdir, logFiles[len(logFiles)-1])
-	log.FromContext(ctx).Info("Save log", "file", srcPath)
-	srcFile, err := os.Open(srcPath)
-	if err != nil {
-		return fmt.Errorf("failed to open log file %s: %w", srcPath, err)
-	}
-	defer srcFile.Close()
+	sort.Slice(logFiles, func(i, j int) bool {
+		if logFiles[i].restartCount != logFiles[j].restartCount {
+			return logFiles[i].restartCount < logFiles[j].restartCount
+		}
+		// rotated log files are older than the log file which is being written.
+		iCurrent, jCurrent := len(logFiles[i].rotatedAt) == 0, len(logFiles[j].rotatedAt) == 0
+		if iCurrent != jCurrent {
+			return jCurrent
+		}
+		return logFiles[i].rotatedAt < logFiles[j].rotatedAt
+	})
 
 	destFile, err := os.Create(savePath)
 	if err != nil {
@@ -264,9 +441,49 @@ func writeContainerLog(ctx context.Context, logdir, savePath string) error {
 	}
 	defer destFile.Close()
 
-	if _, err := io.Copy(destFile, srcFile); err != nil {
-		return fmt.Errorf("failed to copy log file to %s: %w", savePath, err)
+	index := &metadata.ContainerLogIndex{}
+	var offset int64
+	for _, logFile := range logFiles {
+		srcPath := path.Join(logdir, logFile.name)
+		log.FromContext(ctx).Info("Save log", "file", srcPath)
+		size, err := copyLogFile(destFile, srcPath, logFile.compressed)
+		if err != nil {
+			return err
+		}
+
+		index.Segments = append(index.Segments, metadata.ContainerLogSegment{
+			Source:       logFile.name,
+			RestartCount: logFile.restartCount,
+			Compressed:   logFile.compressed,
+			Offset:       offset,
+			Size:         size,
+		})
+		offset += size
 	}
 
-	return nil
+	return metadata.WriteContainerLogIndex(path.Dir(savePath), index)
+}
+
+func copyLogFile(dst io.Writer, srcPath string, compressed bool) (int64, error) {
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open log file %s: %w", srcPath, err)
+	}
+	defer srcFile.Close()
+
+	var src io.Reader = srcFile
+	if compressed {
+		gzReader, err := gzip.NewReader(srcFile)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decompress log file %s: %w", srcPath, err)
+		}
+		defer gzReader.Close()
+		src = gzReader
+	}
+
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		return n, fmt.Errorf("failed to copy log file %s: %w", srcPath, err)
+	}
+	return n, nil
 }
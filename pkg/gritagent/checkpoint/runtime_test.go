@@ -4,10 +4,16 @@
 package checkpoint
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"math"
 	"os"
 	"path"
 	"testing"
+
+	"github.com/kaito-project/grit/pkg/metadata"
 )
 
 func TestWriteContainerLog(t *testing.T) {
@@ -46,8 +52,42 @@ func TestWriteContainerLog(t *testing.T) {
 			t.Fatalf("failed to read saved log file: %v", err)
 		}
 
-		if string(content) != "log2" {
-			t.Fatalf("expected log2 content, got %s", string(content))
+		if string(content) != "log1log2" {
+			t.Fatalf("expected log1log2 content, got %s", string(content))
+		}
+	})
+
+	t.Run("log directory contains rotated log files", func(t *testing.T) {
+		tempDir := t.TempDir()
+		os.WriteFile(path.Join(tempDir, "0.log"), []byte("restart0\n"), 0644)
+		os.WriteFile(path.Join(tempDir, "1.log"), []byte("current\n"), 0644)
+		os.WriteFile(path.Join(tempDir, "1.log.20250102-000000"), []byte("rotated2\n"), 0644)
+
+		var buf bytes.Buffer
+		gzWriter := gzip.NewWriter(&buf)
+		gzWriter.Write([]byte("rotated1\n"))
+		gzWriter.Close()
+		os.WriteFile(path.Join(tempDir, "1.log.20250101-000000.gz"), buf.Bytes(), 0644)
+
+		saveDir := t.TempDir()
+		savePath := path.Join(saveDir, "saved.log")
+		err := writeContainerLog(ctx, tempDir, savePath)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		content, err := os.ReadFile(savePath)
+		if err != nil {
+			t.Fatalf("failed to read saved log file: %v", err)
+		}
+
+		expected := "restart0\nrotated1\nrotated2\ncurrent\n"
+		if string(content) != expected {
+			t.Fatalf("expected %q content, got %q", expected, string(content))
+		}
+
+		if _, err := os.Stat(path.Join(saveDir, metadata.ContainerLogIndexFile)); err != nil {
+			t.Fatalf("expected log index file, got %v", err)
 		}
 	})
 
@@ -68,3 +108,19 @@ func TestWriteContainerLog(t *testing.T) {
 		}
 	})
 }
+
+func TestCheckFreeSpace(t *testing.T) {
+	t.Run("directory is not created", func(t *testing.T) {
+		dir := path.Join(t.TempDir(), "ns", "ckpt")
+		if err := checkFreeSpace(dir, 1); err != nil {
+			t.Fatalf("expected free space of parent directory is checked, got %v", err)
+		}
+	})
+
+	t.Run("free space is not enough", func(t *testing.T) {
+		err := checkFreeSpace(t.TempDir(), math.MaxInt64)
+		if !errors.Is(err, metadata.ErrInsufficientDiskSpace) {
+			t.Fatalf("expected insufficient disk space error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/metadata"
+	"github.com/kaito-project/grit/pkg/util/volumesnapshot"
+)
+
+// snapshotVolumeClaims takes VolumeSnapshots for pvcs of the target pod, it's called while all containers
+// of the pod are paused, and returns after all snapshots are cut by csi driver, so the data in snapshots
+// matches the checkpointed process state. uploading snapshot data is not waited. filesystems of pvcs are
+// synced before snapshots are taken, so data written by the paused processes but still in page cache is
+// included. VolumeSnapshots are owned by the Checkpoint, they are removed with it.
+func snapshotVolumeClaims(ctx context.Context, mountSources []string, opts *options.RuntimeCheckpointOptions) error {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get kube config: %w", err)
+	}
+	kubeClient, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return fmt.Errorf("failed to create kube client: %w", err)
+	}
+
+	var ckpt v1alpha1.Checkpoint
+	if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: opts.TargetPodNamespace, Name: opts.CheckpointName}, &ckpt); err != nil {
+		return fmt.Errorf("failed to get checkpoint %s: %w", opts.CheckpointName, err)
+	}
+
+	for _, claimName := range opts.VolumeSnapshotClaims {
+		if err := syncVolumeClaim(ctx, kubeClient, opts.TargetPodNamespace, claimName, mountSources); err != nil {
+			return fmt.Errorf("failed to sync filesystem of pvc %s: %w", claimName, err)
+		}
+
+		snapshot := volumesnapshot.NewForClaim(opts.TargetPodNamespace, opts.CheckpointName, claimName, opts.VolumeSnapshotClassName)
+		snapshot.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       "Checkpoint",
+			Name:       ckpt.Name,
+			UID:        ckpt.UID,
+			Controller: ptr.To(true),
+		}})
+		// snapshot left by the previous attempt of grit agent job may be taken after the pod is resumed,
+		// so it's removed and taken again.
+		if err := recreateVolumeSnapshot(ctx, kubeClient, snapshot, opts.VolumeSnapshotTimeout); err != nil {
+			return fmt.Errorf("failed to create volume snapshot for pvc %s: %w", claimName, err)
+		}
+		log.FromContext(ctx).Info("volume snapshot is created", "namespace", snapshot.GetNamespace(), "name", snapshot.GetName(), "pvc", claimName)
+	}
+
+	return wait.PollUntilContextTimeout(ctx, time.Second, opts.VolumeSnapshotTimeout, true, func(ctx context.Context) (bool, error) {
+		for _, claimName := range opts.VolumeSnapshotClaims {
+			snapshot := volumesnapshot.New()
+			if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: opts.TargetPodNamespace, Name: volumesnapshot.Name(opts.CheckpointName, claimName)}, snapshot); err != nil {
+				return false, err
+			}
+
+			if message := volumesnapshot.Error(snapshot); len(message) != 0 {
+				return false, fmt.Errorf("failed to take volume snapshot for pvc %s: %s", claimName, message)
+			}
+			if !volumesnapshot.IsTaken(snapshot) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+func recreateVolumeSnapshot(ctx context.Context, kubeClient client.Client, snapshot *unstructured.Unstructured, timeout time.Duration) error {
+	err := kubeClient.Create(ctx, snapshot)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	existing := volumesnapshot.New()
+	existing.SetNamespace(snapshot.GetNamespace())
+	existing.SetName(snapshot.GetName())
+	if err := kubeClient.Delete(ctx, existing); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	return wait.PollUntilContextTimeout(ctx, time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		err := kubeClient.Create(ctx, snapshot.DeepCopy())
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// syncVolumeClaim flushes the filesystems which the pvc is mounted from in the pod, the pvc is located by the
+// name of its bound persistent volume in mount sources.
+func syncVolumeClaim(ctx context.Context, kubeClient client.Client, namespace, claimName string, mountSources []string) error {
+	var pvc corev1.PersistentVolumeClaim
+	if err := kubeClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: claimName}, &pvc); err != nil {
+		return err
+	}
+	if len(pvc.Spec.VolumeName) == 0 {
+		return fmt.Errorf("pvc %s is not bound", claimName)
+	}
+
+	synced := false
+	for _, source := range mountSources {
+		if !metadata.IsPersistentVolumeSource(source, pvc.Spec.VolumeName) {
+			continue
+		}
+		if err := syncfs(source); err != nil {
+			return err
+		}
+		synced = true
+	}
+	if !synced {
+		// block volumes are not mounted as directories, they are flushed by the csi driver.
+		log.FromContext(ctx).Info("mount of pvc is not found, skip syncing filesystem", "pvc", claimName, "volume", pvc.Spec.VolumeName)
+	}
+	return nil
+}
+
+func syncfs(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := unix.Syncfs(int(f.Fd())); err != nil {
+		return fmt.Errorf("syncfs %s: %w", dir, err)
+	}
+	return nil
+}
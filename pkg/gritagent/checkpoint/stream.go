@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+// streamerReadyTimeout is the max duration for waiting criu-image-streamer to create the capture socket.
+const streamerReadyTimeout = 30 * time.Second
+
+// captureImages starts criu-image-streamer in capture mode, criu images which are dumped in stream mode are
+// written into streamPath in storage directly instead of staging them on the disk of the node. the returned
+// function waits for the capture to be completed after criu dump finished, or stops it if dump failed.
+func captureImages(ctx context.Context, imagesDir, streamPath string) (func(dumpErr error) error, error) {
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(streamPath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	stream, err := os.Create(streamPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, metadata.ImageStreamerBinary, "--images-dir", imagesDir, "capture")
+	cmd.Stdout = stream
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		stream.Close()
+		return nil, fmt.Errorf("failed to start %s: %w", metadata.ImageStreamerBinary, err)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	wait := func(dumpErr error) error {
+		if dumpErr != nil {
+			cmd.Process.Kill()
+		}
+		err := <-exited
+		if dumpErr != nil {
+			stream.Close()
+			return dumpErr
+		} else if err != nil {
+			stream.Close()
+			return fmt.Errorf("%s failed: %w, %s", metadata.ImageStreamerBinary, err, strings.TrimSpace(stderr.String()))
+		}
+
+		// the stream is persisted before the checkpoint is regarded as completed.
+		if err := stream.Sync(); err != nil {
+			stream.Close()
+			return err
+		}
+		return stream.Close()
+	}
+
+	// criu-image-streamer may exit before the socket is created, like the binary is not compatible with the node.
+	readyCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case err := <-exited:
+			exited <- err
+			cancel()
+		case <-readyCtx.Done():
+		}
+	}()
+	if err := metadata.WaitForStreamerSocket(readyCtx, filepath.Join(imagesDir, metadata.StreamerCaptureSocket), streamerReadyTimeout); err != nil {
+		return nil, wait(err)
+	}
+
+	log.FromContext(ctx).Info("criu images are streamed into storage", "stream", streamPath)
+	return wait, nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package copy
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBurstBytes is the max size of data which is read at once by rate limited reader.
+const maxBurstBytes = 1 << 20
+
+type limiterKey struct{}
+
+// WithBandwidthLimit returns a context which limits the rate of transferring checkpointed data to bytesPerSecond,
+// the limit is shared by all files which are transferred with the context. it's unlimited if bytesPerSecond is 0.
+func WithBandwidthLimit(ctx context.Context, bytesPerSecond int64) context.Context {
+	if bytesPerSecond <= 0 {
+		return ctx
+	}
+	burst := int(min(bytesPerSecond, maxBurstBytes))
+	return context.WithValue(ctx, limiterKey{}, rate.NewLimiter(rate.Limit(bytesPerSecond), burst))
+}
+
+// LimitReader returns a reader which is throttled by the bandwidth limit in the context.
+func LimitReader(ctx context.Context, r io.Reader) io.Reader {
+	limiter, ok := ctx.Value(limiterKey{}).(*rate.Limiter)
+	if !ok {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -14,9 +14,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func TransferData(ctx context.Context, srcDir, dstDir string) error {
+// ProgressFunc is called with the size of file after the file is copied successfully.
+type ProgressFunc func(copiedBytes int64)
+
+func TransferData(ctx context.Context, srcDir, dstDir string, progress ProgressFunc) error {
 	var wg sync.WaitGroup
-	errs := make([]error, 20)
+	var mu sync.Mutex
+	var errs []error
 	workerChan := make(chan struct{}, 10)
 
 	log.FromContext(ctx).Info("start to transfer data", "src-dir", srcDir, "dst-dir", dstDir)
@@ -44,59 +48,55 @@ func TransferData(ctx context.Context, srcDir, dstDir string) error {
 				<-workerChan
 			}()
 
-			if err := copyFile(src, dst); err != nil {
+			size, err := copyFile(ctx, src, dst)
+			if err != nil {
+				mu.Lock()
 				errs = append(errs, err)
+				mu.Unlock()
+				return
 			}
 			log.FromContext(ctx).Info("copy file successfully", "src-file", src)
+			if progress != nil {
+				progress(size)
+			}
 		}(path, dstPath)
 
 		return nil
 	})
 
+	// wait for running workers even if walking failed, so no file is written after returning.
+	wg.Wait()
 	if err != nil {
 		return err
 	}
 
-	wg.Wait()
 	log.FromContext(ctx).Info("data transfer completed", "src-dir", srcDir, "dst-dir", dstDir)
 
 	return multierr.Combine(errs...)
 }
 
-func copyFile(srcFile, dstFile string) error {
+func copyFile(ctx context.Context, srcFile, dstFile string) (int64, error) {
 	src, err := os.Open(srcFile)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer src.Close()
 
 	dst, err := os.Create(dstFile)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer dst.Close()
 
-	_, err = io.Copy(dst, src)
+	n, err := io.Copy(dst, LimitReader(ctx, src))
 	if err != nil {
-		return err
+		return n, err
 	}
 
 	info, err := os.Stat(srcFile)
 	if err != nil {
-		return err
-	}
-
-	return os.Chmod(dstFile, info.Mode())
-}
-
-func CreateSentinelFile(dir, fileName string) error {
-	filePath := filepath.Join(dir, fileName)
-	f, err := os.Create(filePath)
-	if err != nil {
-		return err
+		return n, err
 	}
-	defer f.Close()
 
-	_, err = f.WriteString("Transfer Completed\n")
-	return err
+	return n, os.Chmod(dstFile, info.Mode())
 }
@@ -0,0 +1,342 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
+	"github.com/kaito-project/grit/pkg/gritagent/checkpoint"
+	"github.com/kaito-project/grit/pkg/gritagent/copy"
+	"github.com/kaito-project/grit/pkg/gritagent/janitor"
+	"github.com/kaito-project/grit/pkg/gritagent/metrics"
+	"github.com/kaito-project/grit/pkg/gritagent/peer"
+	"github.com/kaito-project/grit/pkg/gritagent/restore"
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+type operation struct {
+	status api.OperationStatus
+	// hostDir is the directory on the host which is used by the operation.
+	hostDir string
+}
+
+// AgentDaemon runs checkpoint and restore operations in background, and grit-manager
+// polls the operation status through grpc api instead of watching grit agent jobs.
+type AgentDaemon struct {
+	// ctx is the root context of daemon, operations are not canceled when grpc request completes.
+	ctx  context.Context
+	opts *options.GritAgentOptions
+
+	sync.Mutex
+	operations map[string]*operation
+}
+
+func NewAgentDaemon(ctx context.Context, opts *options.GritAgentOptions) *AgentDaemon {
+	return &AgentDaemon{
+		ctx:        ctx,
+		opts:       opts,
+		operations: make(map[string]*operation),
+	}
+}
+
+func RunDaemon(ctx context.Context, opts *options.GritAgentOptions) error {
+	if len(opts.APISecretFile) == 0 {
+		return errors.New("api secret file should be specified for grit agent daemon")
+	}
+	secret, err := api.LoadSecret(opts.APISecretFile)
+	if err != nil {
+		return fmt.Errorf("failed to load api secret: %w", err)
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", opts.GRPCPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", opts.GRPCPort, err)
+	}
+
+	d := NewAgentDaemon(ctx, opts)
+	server := grpc.NewServer(grpc.UnaryInterceptor(api.UnaryServerInterceptor(secret)))
+	api.RegisterAgentServer(server, d)
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	if len(opts.PeerSecretFile) != 0 {
+		if err := servePeer(ctx, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.MetricsPort > 0 {
+		if err := metrics.Serve(ctx, opts.MetricsPort); err != nil {
+			return err
+		}
+	}
+
+	if opts.JanitorInterval > 0 {
+		j, err := janitor.NewFromOptions(opts, d.isInUse)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "host path janitor is disabled")
+		} else {
+			go j.Run(ctx, opts.JanitorInterval)
+		}
+	}
+
+	log.FromContext(ctx).Info("grit agent daemon is serving", "address", lis.Addr().String())
+	return server.Serve(lis)
+}
+
+// servePeer serves checkpointed data on the node for grit agent daemons on other nodes.
+func servePeer(ctx context.Context, opts *options.GritAgentOptions) error {
+	secret, err := peer.LoadSecret(opts.PeerSecretFile)
+	if err != nil {
+		return fmt.Errorf("failed to load peer secret: %w", err)
+	}
+	if len(opts.PeerTLSDir) == 0 {
+		return errors.New("peer tls dir should be specified for serving checkpointed data")
+	}
+	tlsConfig, err := peer.LoadServerTLSConfig(opts.PeerTLSDir)
+	if err != nil {
+		return err
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", opts.PeerPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", opts.PeerPort, err)
+	}
+
+	// connection of peer is upgraded for tunneling criu page server, so http/2 is not negotiated.
+	tlsConfig.NextProtos = []string{"http/1.1"}
+	server := &http.Server{
+		Handler:           peer.NewServer(opts.HostPath, opts.NodeName, secret),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		log.FromContext(ctx).Info("grit agent daemon is serving checkpointed data for peers", "address", lis.Addr().String())
+		if err := server.ServeTLS(lis, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.FromContext(ctx).Error(err, "failed to serve checkpointed data for peers")
+		}
+	}()
+	return nil
+}
+
+func (d *AgentDaemon) Checkpoint(_ context.Context, req *api.CheckpointRequest) (*api.OperationStatus, error) {
+	if len(req.ID) == 0 || len(req.TargetPodName) == 0 || len(req.HostWorkPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "id, target pod name and host work path should be specified")
+	}
+	if err := d.validateDirs([]string{req.SrcDir, req.HostWorkPath}, []string{req.DstDir}); err != nil {
+		return nil, err
+	}
+
+	opts := *d.opts
+	opts.Action = options.ActionCheckpoint
+	opts.SrcDir = req.SrcDir
+	opts.DstDir = req.DstDir
+	opts.TargetPodNamespace = req.TargetPodNamespace
+	opts.TargetPodName = req.TargetPodName
+	opts.TargetPodUID = req.TargetPodUID
+	opts.HostWorkPath = req.HostWorkPath
+	opts.CheckpointName = req.CheckpointName
+	opts.VolumeSnapshotClaims = req.VolumeSnapshotClaims
+	opts.VolumeSnapshotClassName = req.VolumeSnapshotClassName
+	opts.Stream = req.Stream
+	if req.BandwidthLimit > 0 {
+		opts.BandwidthLimit = req.BandwidthLimit
+	}
+
+	return d.startOperation(req.ID, req.HostWorkPath, &opts, checkpoint.RunCheckpoint), nil
+}
+
+func (d *AgentDaemon) Restore(_ context.Context, req *api.RestoreRequest) (*api.OperationStatus, error) {
+	if len(req.ID) == 0 || len(req.DstDir) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "id and dst dir should be specified")
+	}
+	if err := d.validateDirs([]string{req.DstDir}, []string{req.SrcDir}); err != nil {
+		return nil, err
+	}
+
+	opts := *d.opts
+	opts.Action = options.ActionRestore
+	opts.SrcDir = req.SrcDir
+	opts.DstDir = req.DstDir
+	opts.PeerURL = req.PeerURL
+	opts.LazyPages = req.LazyPages
+	if req.BandwidthLimit > 0 {
+		opts.BandwidthLimit = req.BandwidthLimit
+	}
+
+	return d.startOperation(req.ID, req.DstDir, &opts, restore.RunRestore), nil
+}
+
+func (d *AgentDaemon) Prestage(_ context.Context, req *api.RestoreRequest) (*api.OperationStatus, error) {
+	if len(req.ID) == 0 || len(req.DstDir) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "id and dst dir should be specified")
+	}
+	if err := d.validateDirs([]string{req.DstDir}, []string{req.SrcDir}); err != nil {
+		return nil, err
+	}
+
+	opts := *d.opts
+	opts.Action = options.ActionPrestage
+	opts.SrcDir = req.SrcDir
+	opts.DstDir = req.DstDir
+	opts.PeerURL = req.PeerURL
+	if req.BandwidthLimit > 0 {
+		opts.BandwidthLimit = req.BandwidthLimit
+	}
+
+	return d.startOperation(req.ID, req.DstDir, &opts, restore.RunPrestage), nil
+}
+
+func (d *AgentDaemon) Status(_ context.Context, req *api.StatusRequest) (*api.OperationStatus, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	op, ok := d.operations[req.ID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "operation %s is not found", req.ID)
+	}
+	opStatus := op.status
+	return &opStatus, nil
+}
+
+func (d *AgentDaemon) Cleanup(_ context.Context, req *api.CleanupRequest) (*api.CleanupResponse, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	op, ok := d.operations[req.ID]
+	if !ok {
+		if req.RemoveData && len(req.HostDir) != 0 {
+			return &api.CleanupResponse{}, d.removeHostDir(req.HostDir)
+		}
+		return &api.CleanupResponse{}, nil
+	}
+
+	if op.status.Phase == api.OperationRunning {
+		return nil, status.Errorf(codes.FailedPrecondition, "operation %s is still running", req.ID)
+	}
+
+	if req.RemoveData {
+		if err := d.removeHostDir(op.hostDir); err != nil {
+			return nil, err
+		}
+	}
+
+	delete(d.operations, req.ID)
+	return &api.CleanupResponse{}, nil
+}
+
+// validateDirs checks directories on the host are under host path, so grit agent daemon never reads or writes
+// other paths on the node. volume claims of checkpoints are not mounted into grit agent daemon, operations which
+// transfer checkpointed data from or to cloud storage are run by grit agent jobs, so directories in cloud storage
+// should be empty.
+func (d *AgentDaemon) validateDirs(hostDirs, storageDirs []string) error {
+	for _, dir := range hostDirs {
+		if !restore.IsSubPath(d.opts.HostPath, dir) {
+			return status.Errorf(codes.PermissionDenied, "directory %s is not under host path %s", dir, d.opts.HostPath)
+		}
+	}
+	for _, dir := range storageDirs {
+		if len(dir) != 0 {
+			return status.Errorf(codes.InvalidArgument, "directory %s in cloud storage is not accessible by grit agent daemon", dir)
+		}
+	}
+	return nil
+}
+
+func (d *AgentDaemon) removeHostDir(dir string) error {
+	if !restore.IsSubPath(d.opts.HostPath, dir) {
+		return status.Errorf(codes.PermissionDenied, "directory %s is not under host path %s", dir, d.opts.HostPath)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return status.Errorf(codes.Internal, "failed to remove directory %s: %v", dir, err)
+	}
+	return nil
+}
+
+// isInUse checks whether the directory on the host is used by a running operation.
+func (d *AgentDaemon) isInUse(dir string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, op := range d.operations {
+		if op.status.Phase == api.OperationRunning && filepath.Clean(op.hostDir) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// startOperation starts the operation in background. if an operation with the same id exists,
+// the status of existing operation will be returned, so grit-manager can retry requests safely.
+func (d *AgentDaemon) startOperation(id, hostDir string, opts *options.GritAgentOptions, handler func(context.Context, *options.GritAgentOptions) error) *api.OperationStatus {
+	d.Lock()
+	defer d.Unlock()
+
+	if op, ok := d.operations[id]; ok {
+		opStatus := op.status
+		return &opStatus
+	}
+
+	op := &operation{
+		status: api.OperationStatus{
+			ID:        id,
+			Action:    opts.Action,
+			Phase:     api.OperationRunning,
+			StartTime: time.Now(),
+		},
+		hostDir: hostDir,
+	}
+	d.operations[id] = op
+
+	logger := log.FromContext(d.ctx).WithValues("operation", id, "action", opts.Action)
+	ctx := copy.WithBandwidthLimit(log.IntoContext(d.ctx, logger), opts.BandwidthLimit)
+	go func() {
+		logger.Info("start to run operation")
+		err := handler(ctx, opts)
+
+		d.Lock()
+		defer d.Unlock()
+		now := time.Now()
+		op.status.CompletionTime = &now
+		if opts.Action == options.ActionCheckpoint {
+			if estimate, err := metadata.ReadSizeEstimate(hostDir); err == nil {
+				op.status.EstimatedSize = estimate.Total()
+			}
+		}
+		if err != nil {
+			logger.Error(err, "failed to run operation")
+			op.status.Phase = api.OperationFailed
+			op.status.Message = err.Error()
+			return
+		}
+		logger.Info("operation completed")
+		op.status.Phase = api.OperationSucceeded
+	}()
+
+	opStatus := op.status
+	return &opStatus
+}
@@ -0,0 +1,224 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package janitor removes checkpointed data under host path of the node which is not needed anymore, like data
+// of deleted or failed checkpoints, and data which has been restored.
+package janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+)
+
+// gracePeriod protects directories which are just created, like data which is being downloaded for a restore
+// that is not observed yet.
+const gracePeriod = 10 * time.Minute
+
+// Janitor sweeps checkpointed data under host path, checkpointed data is located at <host path>/<namespace>/<name>
+// on both the node where the pod is checkpointed and the nodes where it's restored or pre-staged.
+type Janitor struct {
+	client   client.Reader
+	hostPath string
+	nodeName string
+	// inUse reports whether the directory is used by a running operation of grit agent daemon.
+	inUse func(dir string) bool
+}
+
+func New(c client.Reader, hostPath, nodeName string, inUse func(dir string) bool) *Janitor {
+	if inUse == nil {
+		inUse = func(string) bool { return false }
+	}
+	return &Janitor{
+		client:   c,
+		hostPath: hostPath,
+		nodeName: nodeName,
+		inUse:    inUse,
+	}
+}
+
+// NewFromOptions creates a janitor with kube client for the node of grit agent.
+func NewFromOptions(opts *options.GritAgentOptions, inUse func(dir string) bool) (*Janitor, error) {
+	if len(opts.NodeName) == 0 {
+		return nil, errors.New("node name should be specified for cleaning up host path")
+	}
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kube config: %w", err)
+	}
+	cfg.QPS = float32(opts.KubeClientQPS)
+	cfg.Burst = opts.KubeClientBurst
+	kubeClient, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kube client: %w", err)
+	}
+	return New(kubeClient, opts.HostPath, opts.NodeName, inUse), nil
+}
+
+// Run sweeps host path periodically until the context is done.
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if _, err := j.Sweep(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "failed to clean up host path", "host-path", j.hostPath)
+		}
+	}, interval)
+}
+
+// Sweep removes checkpointed data which is not needed anymore, and returns the size of reclaimed space in bytes.
+func (j *Janitor) Sweep(ctx context.Context) (int64, error) {
+	namespaces, err := os.ReadDir(j.hostPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var reclaimed int64
+	for _, ns := range namespaces {
+		// hidden directories are not namespaces, like sockets of criu page servers for lazy restore.
+		if !ns.IsDir() || strings.HasPrefix(ns.Name(), ".") {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(j.hostPath, ns.Name()))
+		if err != nil {
+			return reclaimed, err
+		}
+
+		var restores *v1alpha1.RestoreList
+		for _, entry := range entries {
+			dir := filepath.Join(j.hostPath, ns.Name(), entry.Name())
+			if !entry.IsDir() || j.inUse(dir) || isRecent(entry) {
+				continue
+			}
+
+			var ckpt v1alpha1.Checkpoint
+			if err := j.client.Get(ctx, client.ObjectKey{Namespace: ns.Name(), Name: entry.Name()}, &ckpt); apierrors.IsNotFound(err) {
+				reclaimed += j.remove(ctx, dir, "checkpoint is not found")
+				continue
+			} else if err != nil {
+				return reclaimed, err
+			}
+
+			if restores == nil {
+				restores = &v1alpha1.RestoreList{}
+				if err := j.client.List(ctx, restores, client.InNamespace(ns.Name())); err != nil {
+					return reclaimed, err
+				}
+			}
+			if needed, reason := isNeeded(&ckpt, restores.Items, j.nodeName); !needed {
+				reclaimed += j.remove(ctx, dir, reason)
+			}
+		}
+
+		// namespace directory is removed after all checkpointed data in it is removed.
+		os.Remove(filepath.Join(j.hostPath, ns.Name()))
+	}
+
+	log.FromContext(ctx).Info("host path is cleaned up", "host-path", j.hostPath, "reclaimedBytes", reclaimed)
+	return reclaimed, nil
+}
+
+// remove removes checkpointed data in dir, and returns the size of removed data.
+func (j *Janitor) remove(ctx context.Context, dir, reason string) int64 {
+	size := dirSize(dir)
+	if err := os.RemoveAll(dir); err != nil {
+		log.FromContext(ctx).Error(err, "failed to remove checkpointed data", "dir", dir)
+		return 0
+	}
+	log.FromContext(ctx).Info("checkpointed data is removed", "dir", dir, "reason", reason, "bytes", size)
+	return size
+}
+
+// isNeeded checks whether checkpointed data of the checkpoint on the node is still needed, the reason is
+// returned if it's not needed.
+func isNeeded(ckpt *v1alpha1.Checkpoint, restores []v1alpha1.Restore, nodeName string) (bool, string) {
+	switch ckpt.Status.Phase {
+	case v1alpha1.CheckpointFailed:
+		return false, "checkpoint is failed"
+	case v1alpha1.Checkpointed, v1alpha1.AutoMigrationSubmitting, v1alpha1.AutoMigrationSubmitted:
+	default:
+		return true, ""
+	}
+
+	if nodeName == ckpt.Status.NodeName {
+		// checkpointed data on the node is the only copy if cloud storage is not specified.
+		if ckpt.Spec.VolumeClaim == nil {
+			return true, ""
+		}
+		// checkpointed data of streamed checkpoint on the node is incomplete, it's never restored in place.
+		if ckpt.Spec.Streaming {
+			return false, "criu images are streamed into cloud storage"
+		}
+	}
+
+	// checkpointed data is kept for same-node restores and pre-staged restores until restores are finished.
+	finished := 0
+	for i := range restores {
+		if restores[i].Spec.CheckpointName != ckpt.Name {
+			continue
+		}
+		if !isRestoreFinished(&restores[i]) {
+			return true, ""
+		}
+		finished++
+	}
+	if finished == 0 {
+		return true, ""
+	}
+	return false, "restores of checkpoint are finished"
+}
+
+// isRestoreFinished checks whether checkpointed data is not used by the restore anymore. restore in FanOut mode
+// is never finished, and memory pages of lazy restore are still downloaded after the pod is restored.
+func isRestoreFinished(restore *v1alpha1.Restore) bool {
+	if restore.Spec.Mode == v1alpha1.RestoreModeFanOut {
+		return false
+	}
+
+	switch restore.Status.Phase {
+	case v1alpha1.RestoreFailed:
+		return true
+	case v1alpha1.Restored:
+		if !restore.Spec.LazyPages || restore.Status.PagesCompletedTime != nil {
+			return true
+		}
+		cond := meta.FindStatusCondition(restore.Status.Conditions, string(v1alpha1.Restored))
+		return cond != nil && cond.Reason == "LazyPagesFailed"
+	default:
+		return false
+	}
+}
+
+func isRecent(entry fs.DirEntry) bool {
+	info, err := entry.Info()
+	return err != nil || time.Since(info.ModTime()) < gracePeriod
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
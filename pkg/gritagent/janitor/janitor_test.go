@@ -0,0 +1,80 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package janitor
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+)
+
+func TestIsNeeded(t *testing.T) {
+	newCheckpoint := func(phase v1alpha1.CheckpointPhase, volumeClaim bool) *v1alpha1.Checkpoint {
+		ckpt := &v1alpha1.Checkpoint{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ckpt"},
+			Status:     v1alpha1.CheckpointStatus{NodeName: "node-1", Phase: phase},
+		}
+		if volumeClaim {
+			ckpt.Spec.VolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "ckpt-store"}
+		}
+		return ckpt
+	}
+	newRestore := func(phase v1alpha1.RestorePhase) v1alpha1.Restore {
+		return v1alpha1.Restore{
+			Spec:   v1alpha1.RestoreSpec{CheckpointName: "ckpt"},
+			Status: v1alpha1.RestoreStatus{Phase: phase},
+		}
+	}
+
+	t.Run("checkpoint is in progress or failed", func(t *testing.T) {
+		if needed, _ := isNeeded(newCheckpoint(v1alpha1.Checkpointing, true), nil, "node-1"); !needed {
+			t.Fatalf("expected data of checkpoint in progress is needed")
+		}
+		if needed, _ := isNeeded(newCheckpoint(v1alpha1.CheckpointFailed, false), nil, "node-1"); needed {
+			t.Fatalf("expected data of failed checkpoint is not needed")
+		}
+	})
+
+	t.Run("data on the node is the only copy", func(t *testing.T) {
+		restores := []v1alpha1.Restore{newRestore(v1alpha1.Restored)}
+		if needed, _ := isNeeded(newCheckpoint(v1alpha1.Checkpointed, false), restores, "node-1"); !needed {
+			t.Fatalf("expected data without cloud storage is needed")
+		}
+	})
+
+	t.Run("data is kept until restores are finished", func(t *testing.T) {
+		ckpt := newCheckpoint(v1alpha1.Checkpointed, true)
+		if needed, _ := isNeeded(ckpt, nil, "node-1"); !needed {
+			t.Fatalf("expected data is kept for same-node restore")
+		}
+		restores := []v1alpha1.Restore{newRestore(v1alpha1.RestoreFailed), newRestore(v1alpha1.Restoring)}
+		if needed, _ := isNeeded(ckpt, restores, "node-2"); !needed {
+			t.Fatalf("expected data is needed by restore in progress")
+		}
+		restores[1].Status.Phase = v1alpha1.Restored
+		if needed, _ := isNeeded(ckpt, restores, "node-2"); needed {
+			t.Fatalf("expected data is not needed after restores are finished")
+		}
+	})
+
+	t.Run("memory pages are not completed or restore is in FanOut mode", func(t *testing.T) {
+		ckpt := newCheckpoint(v1alpha1.Checkpointed, true)
+		restore := newRestore(v1alpha1.Restored)
+		restore.Spec.LazyPages = true
+		if needed, _ := isNeeded(ckpt, []v1alpha1.Restore{restore}, "node-1"); !needed {
+			t.Fatalf("expected data is needed by lazy restore")
+		}
+		restore.Status.PagesCompletedTime = &metav1.Time{}
+		if needed, _ := isNeeded(ckpt, []v1alpha1.Restore{restore}, "node-1"); needed {
+			t.Fatalf("expected data is not needed after memory pages are completed")
+		}
+		restore.Spec.Mode = v1alpha1.RestoreModeFanOut
+		if needed, _ := isNeeded(ckpt, []v1alpha1.Restore{restore}, "node-1"); !needed {
+			t.Fatalf("expected data is needed by restore in FanOut mode")
+		}
+	})
+}
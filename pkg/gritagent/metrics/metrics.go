@@ -0,0 +1,168 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package metrics defines prometheus metrics of grit agent. metrics are scraped from grit agent daemon, and
+// grit agent job reports them to grit-manager by termination message because the job exits after the operation
+// is completed, grit-manager records them into the same metrics.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/gritagent/api"
+)
+
+const (
+	// DirectionUpload is transferring checkpointed data from the node to cloud storage.
+	DirectionUpload = "upload"
+	// DirectionDownload is transferring checkpointed data to the node from cloud storage or the peer.
+	DirectionDownload = "download"
+)
+
+var (
+	// Registry is the registry of grit agent metrics.
+	Registry = prometheus.NewRegistry()
+
+	// sizeBuckets range from 1MiB to 1TiB.
+	sizeBuckets = prometheus.ExponentialBuckets(1<<20, 4, 11)
+
+	PodFreezeDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "grit_agent_pod_freeze_duration_seconds",
+		Help:    "Duration of containers of the pod being paused for checkpointing.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+	})
+	CheckpointSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "grit_agent_checkpoint_size_bytes",
+		Help:    "Size of checkpointed data of the pod.",
+		Buckets: sizeBuckets,
+	})
+	TransferDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grit_agent_transfer_duration_seconds",
+		Help:    "Duration of transferring checkpointed data, direction is upload or download.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"direction"})
+	TransferThroughput = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grit_agent_transfer_throughput_bytes_per_second",
+		Help:    "Throughput of transferring checkpointed data, direction is upload or download.",
+		Buckets: sizeBuckets,
+	}, []string{"direction"})
+	TransferredBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grit_agent_transferred_bytes_total",
+		Help: "Total bytes of checkpointed data which are transferred, direction is upload or download.",
+	}, []string{"direction"})
+)
+
+var (
+	// operationMetrics collects observations of the operation in grit agent job, it's nil if they are not collected.
+	operationMetrics   *api.OperationMetrics
+	operationMetricsMu sync.Mutex
+)
+
+func init() {
+	Registry.MustRegister(Collectors()...)
+}
+
+// Collectors returns all metrics of grit agent, grit-manager registers them for recording metrics of grit agent jobs.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{PodFreezeDuration, CheckpointSize, TransferDuration, TransferThroughput, TransferredBytes}
+}
+
+// CollectOperationMetrics starts collecting observations of the operation, it's used by grit agent job which runs
+// only one operation.
+func CollectOperationMetrics() {
+	operationMetricsMu.Lock()
+	defer operationMetricsMu.Unlock()
+	operationMetrics = &api.OperationMetrics{}
+}
+
+// CollectedOperationMetrics returns observations which are collected since CollectOperationMetrics is called.
+func CollectedOperationMetrics() *api.OperationMetrics {
+	operationMetricsMu.Lock()
+	defer operationMetricsMu.Unlock()
+	return operationMetrics
+}
+
+func collect(fn func(m *api.OperationMetrics)) {
+	operationMetricsMu.Lock()
+	defer operationMetricsMu.Unlock()
+	if operationMetrics != nil {
+		fn(operationMetrics)
+	}
+}
+
+// ObservePodFreeze records the duration of containers of the pod being paused.
+func ObservePodFreeze(duration time.Duration) {
+	PodFreezeDuration.Observe(duration.Seconds())
+	collect(func(m *api.OperationMetrics) { m.PodFreezeSeconds += duration.Seconds() })
+}
+
+// ObserveCheckpointSize records the size of checkpointed data of the pod.
+func ObserveCheckpointSize(bytes int64) {
+	CheckpointSize.Observe(float64(bytes))
+	collect(func(m *api.OperationMetrics) { m.CheckpointBytes += bytes })
+}
+
+// ObserveTransfer records a completed transfer of checkpointed data.
+func ObserveTransfer(direction string, bytes int64, duration time.Duration) {
+	observeTransfer(direction, bytes, duration.Seconds())
+	collect(func(m *api.OperationMetrics) {
+		m.Transfers = append(m.Transfers, api.TransferMetrics{Direction: direction, Bytes: bytes, Seconds: duration.Seconds()})
+	})
+}
+
+func observeTransfer(direction string, bytes int64, seconds float64) {
+	TransferDuration.WithLabelValues(direction).Observe(seconds)
+	TransferredBytes.WithLabelValues(direction).Add(float64(bytes))
+	if seconds > 0 {
+		TransferThroughput.WithLabelValues(direction).Observe(float64(bytes) / seconds)
+	}
+}
+
+// ObserveOperationMetrics records observations which are reported by grit agent job, it's used by grit-manager.
+func ObserveOperationMetrics(m *api.OperationMetrics) {
+	if m.PodFreezeSeconds > 0 {
+		PodFreezeDuration.Observe(m.PodFreezeSeconds)
+	}
+	if m.CheckpointBytes > 0 {
+		CheckpointSize.Observe(float64(m.CheckpointBytes))
+	}
+	for _, t := range m.Transfers {
+		observeTransfer(t.Direction, t.Bytes, t.Seconds)
+	}
+}
+
+// Serve serves metrics on the port for scraping until the context is done.
+func Serve(ctx context.Context, port int) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		log.FromContext(ctx).Info("grit agent is serving metrics", "address", lis.Addr().String())
+		if err := server.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.FromContext(ctx).Error(err, "failed to serve metrics")
+		}
+	}()
+	return nil
+}
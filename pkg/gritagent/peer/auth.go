@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package peer transfers checkpointed data between grit agent daemons over https, so checkpointed data can be
+// pulled from the node where the pod is checkpointed directly instead of downloading from cloud storage.
+package peer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaito-project/grit/pkg/util/nonce"
+)
+
+const (
+	// ExpiresHeader, NonceHeader and SignatureHeader are used for authenticating requests between grit agent
+	// daemons, the signature is the hmac-sha256 of request method, path, nonce and expiration time with the
+	// shared secret. path of the request contains the name of the target node, so a signed request can't be
+	// sent to other nodes, and the nonce can only be used once.
+	ExpiresHeader   = "X-Grit-Expires"
+	NonceHeader     = "X-Grit-Nonce"
+	SignatureHeader = "X-Grit-Signature"
+
+	// signatureTTL is the lifetime of a signed request, it also tolerates clock skew between nodes.
+	signatureTTL = 5 * time.Minute
+
+	// ServerName is the name in the serving certificate of grit agent daemons, certificates of all nodes are
+	// signed by the same ca, and the name is verified instead of the address of the node.
+	ServerName = "grit-agent-peer"
+	// files of the ca and the serving certificate in peer tls directory, they're mounted from a kubernetes secret.
+	caFile   = "ca.crt"
+	certFile = "tls.crt"
+	keyFile  = "tls.key"
+)
+
+// LoadSecret reads the shared secret of grit agent daemons from file, the secret is mounted from a kubernetes secret.
+func LoadSecret(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := []byte(strings.TrimSpace(string(data)))
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("peer secret in file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// LoadServerTLSConfig loads the serving certificate of grit agent daemon from tls directory.
+func LoadServerTLSConfig(dir string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, certFile), filepath.Join(dir, keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load peer certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// LoadClientTLSConfig loads the ca from tls directory for verifying certificates of grit agent daemons.
+func LoadClientTLSConfig(dir string) (*tls.Config, error) {
+	data, err := os.ReadFile(filepath.Join(dir, caFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load peer ca: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("there is no certificate in peer ca file %s", filepath.Join(dir, caFile))
+	}
+	return &tls.Config{RootCAs: pool, ServerName: ServerName, MinVersion: tls.VersionTLS12}, nil
+}
+
+func sign(secret []byte, method, path, nonce string, expires int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", method, path, nonce, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest adds expiration time, nonce and signature headers into the request.
+func signRequest(req *http.Request, secret []byte, now time.Time) error {
+	n, err := nonce.New()
+	if err != nil {
+		return err
+	}
+
+	expires := now.Add(signatureTTL).Unix()
+	req.Header.Set(ExpiresHeader, strconv.FormatInt(expires, 10))
+	req.Header.Set(NonceHeader, n)
+	req.Header.Set(SignatureHeader, sign(secret, req.Method, req.URL.Path, n, expires))
+	return nil
+}
+
+// verifyRequest checks the signature of the request is generated with the shared secret and not expired, and
+// the nonce of the request is recorded, so the request can't be replayed.
+func verifyRequest(req *http.Request, secret []byte, nonces *nonce.Cache, now time.Time) error {
+	expires, err := strconv.ParseInt(req.Header.Get(ExpiresHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header", ExpiresHeader)
+	}
+
+	if expires < now.Unix() || expires > now.Add(2*signatureTTL).Unix() {
+		return errors.New("request signature is expired")
+	}
+
+	n := req.Header.Get(NonceHeader)
+	if len(n) == 0 {
+		return fmt.Errorf("invalid %s header", NonceHeader)
+	}
+
+	signature, err := hex.DecodeString(req.Header.Get(SignatureHeader))
+	if err != nil {
+		return fmt.Errorf("invalid %s header", SignatureHeader)
+	}
+
+	expected, _ := hex.DecodeString(sign(secret, req.Method, req.URL.Path, n, expires))
+	if !hmac.Equal(signature, expected) {
+		return errors.New("request signature mismatch")
+	}
+
+	if !nonces.Add(n, time.Unix(expires, 0), now) {
+		return errors.New("request is replayed")
+	}
+	return nil
+}
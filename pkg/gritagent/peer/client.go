@@ -0,0 +1,175 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package peer
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/gritagent/copy"
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+// CheckpointURL returns the url of checkpointed data which is served by grit agent daemon on the node, address
+// is the address of grit agent daemon on the node.
+func CheckpointURL(address, nodeName, namespace, name string) string {
+	return fmt.Sprintf("https://%s/nodes/%s/checkpoints/%s/%s", address, nodeName, namespace, name)
+}
+
+// Client pulls checkpointed data from grit agent daemon on the node where the pod is checkpointed.
+type Client struct {
+	baseURL    string
+	secret     []byte
+	httpClient *http.Client
+}
+
+// NewClient creates a client for checkpointed data at baseURL, tlsConfig is used for verifying the certificate
+// of grit agent daemon on the source node.
+func NewClient(baseURL string, secret []byte, tlsConfig *tls.Config) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+		// no overall timeout for large files, the transfer is canceled by context. connection is upgraded for
+		// tunneling criu page server, so http/2 is not used.
+		httpClient: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSClientConfig:       tlsConfig,
+			ResponseHeaderTimeout: 30 * time.Second,
+			MaxIdleConnsPerHost:   10,
+		}},
+	}
+}
+
+func (c *Client) GetManifest(ctx context.Context) (*metadata.Manifest, error) {
+	body, err := c.get(ctx, "manifest")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var manifest metadata.Manifest
+	if err := json.NewDecoder(body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest from peer: %w", err)
+	}
+	return &manifest, nil
+}
+
+// Download pulls all files in manifest into dstDir, and the manifest is written into dstDir as well.
+func (c *Client) Download(ctx context.Context, manifest *metadata.Manifest, dstDir string, progress copy.ProgressFunc) error {
+	if err := c.DownloadFiles(ctx, manifest.Files, dstDir, progress); err != nil {
+		return err
+	}
+	return metadata.WriteManifest(dstDir, manifest)
+}
+
+// DownloadFiles pulls the specified files of checkpointed data into dstDir.
+func (c *Client) DownloadFiles(ctx context.Context, files []metadata.ManifestEntry, dstDir string, progress copy.ProgressFunc) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	workerChan := make(chan struct{}, 10)
+
+	log.FromContext(ctx).Info("start to pull data from peer", "url", c.baseURL, "dst-dir", dstDir)
+	for _, f := range files {
+		wg.Add(1)
+		workerChan <- struct{}{}
+		go func(entry metadata.ManifestEntry) {
+			defer func() {
+				wg.Done()
+				<-workerChan
+			}()
+
+			if err := c.downloadFile(ctx, entry, dstDir); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			if progress != nil {
+				progress(entry.Size)
+			}
+		}(f)
+	}
+	wg.Wait()
+
+	if err := multierr.Combine(errs...); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("pull data from peer completed", "url", c.baseURL, "dst-dir", dstDir)
+	return nil
+}
+
+func (c *Client) downloadFile(ctx context.Context, entry metadata.ManifestEntry, dstDir string) error {
+	relPath := filepath.Clean(entry.Path)
+	if filepath.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid file path %s in manifest", entry.Path)
+	}
+
+	body, err := c.get(ctx, "files/"+filepath.ToSlash(relPath))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dstFile := filepath.Join(dstDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(dstFile), os.ModePerm); err != nil {
+		return err
+	}
+	dst, err := os.Create(dstFile)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	n, err := io.Copy(dst, copy.LimitReader(ctx, body))
+	if err != nil {
+		return fmt.Errorf("failed to pull file %s from peer: %w", entry.Path, err)
+	} else if n != entry.Size {
+		return fmt.Errorf("size of file %s pulled from peer is %d, expected %d", entry.Path, n, entry.Size)
+	}
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, subPath string) (io.ReadCloser, error) {
+	return c.do(ctx, http.MethodGet, subPath)
+}
+
+func (c *Client) do(ctx context.Context, method, subPath string) (io.ReadCloser, error) {
+	u, err := url.Parse(c.baseURL + "/" + subPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := signRequest(req, c.secret, time.Now()); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to %s %s from peer, status code: %d, %s", method, subPath, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package peer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+	"github.com/kaito-project/grit/pkg/util/netutil"
+)
+
+const (
+	// pageServerTimeout is the max lifetime of criu page server. criu page server exits after all memory pages are
+	// transferred to the lazy-pages daemon on the restoring node, and it's killed if restoration is not completed.
+	pageServerTimeout = 2 * time.Hour
+
+	// pageServerProtocol is the protocol which the peer connection is upgraded to for tunneling criu page server.
+	pageServerProtocol = "criu-page-server"
+)
+
+// servePageServer starts a criu page server in lazy-pages mode for criu images directory in the request, so
+// memory pages can be pulled on demand by criu lazy-pages daemon on the node where the pod is restored. criu
+// page server has no authentication, so it doesn't listen on any port. it's started with one end of a connected
+// loopback socket pair, and memory pages are tunneled between the other end and the authenticated peer connection.
+func (s *Server) servePageServer(w http.ResponseWriter, req *http.Request) {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), pageServerProtocol) {
+		http.Error(w, "page server is only served through connection upgrade", http.StatusBadRequest)
+		return
+	}
+
+	manifest, ok := s.completedManifest(w, req)
+	if !ok {
+		return
+	}
+
+	imagesDir := path.Clean(req.PathValue("path"))
+	if !lo.ContainsBy(manifest.Files, func(f metadata.ManifestEntry) bool {
+		return metadata.IsPageImage(f.Path) && path.Dir(filepath.ToSlash(f.Path)) == imagesDir
+	}) {
+		http.Error(w, "memory pages are not found in checkpointed data", http.StatusNotFound)
+		return
+	}
+
+	pageServerConn, err := s.startPageServer(log.IntoContext(context.Background(), log.FromContext(req.Context())), filepath.Join(s.checkpointDir(req), filepath.FromSlash(imagesDir)))
+	if err != nil {
+		log.FromContext(req.Context()).Error(err, "failed to start criu page server", "images-dir", imagesDir)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		pageServerConn.Close()
+		log.FromContext(req.Context()).Error(err, "failed to upgrade peer connection", "images-dir", imagesDir)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(brw, "HTTP/1.1 %d %s\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", http.StatusSwitchingProtocols, http.StatusText(http.StatusSwitchingProtocols), pageServerProtocol)
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		pageServerConn.Close()
+		return
+	}
+
+	// criu page server exits after all memory pages are transferred, and the tunnel is closed with it.
+	go netutil.Tunnel(struct {
+		io.Reader
+		io.WriteCloser
+	}{brw.Reader, conn}, pageServerConn)
+}
+
+// startPageServer runs criu page server which serves memory pages from images in dir, and returns the connection
+// to it. criu page server uses the inherited socket(--ps-socket) instead of listening on a port.
+func (s *Server) startPageServer(ctx context.Context, dir string) (net.Conn, error) {
+	criuConn, conn, err := netutil.LoopbackConnPair()
+	if err != nil {
+		return nil, err
+	}
+	defer criuConn.Close()
+	criuFile, err := criuConn.File()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer criuFile.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, pageServerTimeout)
+	// the socket of page server is passed as fd 3.
+	cmd := exec.CommandContext(ctx, s.criuPath, "page-server", "--images-dir", dir, "--ps-socket", "3",
+		"--lazy-pages", "--log-file", filepath.Join(dir, "page-server.log"))
+	cmd.ExtraFiles = []*os.File{criuFile}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		conn.Close()
+		return nil, err
+	}
+
+	go func() {
+		defer cancel()
+		// connection is closed if criu page server exits before all memory pages are transferred.
+		defer conn.Close()
+		if err := cmd.Wait(); err != nil {
+			log.FromContext(ctx).Error(err, "criu page server exited", "images-dir", dir)
+			return
+		}
+		log.FromContext(ctx).Info("criu page server completed", "images-dir", dir)
+	}()
+	return conn, nil
+}
+
+// StartPageServer asks grit agent daemon on the source node to start criu page server for criu images
+// directory(relative to checkpointed data), and the peer connection is upgraded into a tunnel to criu page
+// server. the tunnel is handed over through the unix socket at socketPath which is only accessible by root,
+// criu lazy-pages daemon on this node connects to it and memory pages are forwarded through the tunnel.
+func (c *Client) StartPageServer(ctx context.Context, imagesDir, socketPath string) error {
+	subPath := "page-server/" + filepath.ToSlash(imagesDir)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/"+subPath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", pageServerProtocol)
+	if err := signRequest(req, c.secret, time.Now()); err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	peerConn, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to %s %s from peer, status code: %d, %s", req.Method, subPath, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	l, err := netutil.ListenUnix(socketPath)
+	if err != nil {
+		peerConn.Close()
+		return err
+	}
+
+	// criu page server serves only one lazy-pages daemon, so only the first connection is accepted.
+	go func() {
+		timer := time.AfterFunc(pageServerTimeout, func() { l.Close() })
+		defer timer.Stop()
+
+		conn, err := l.Accept()
+		l.Close()
+		if err != nil {
+			peerConn.Close()
+			log.FromContext(ctx).Error(err, "criu lazy-pages daemon didn't connect to page server", "images-dir", imagesDir)
+			return
+		}
+		netutil.Tunnel(peerConn, conn)
+	}()
+	return nil
+}
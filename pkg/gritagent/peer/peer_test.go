@@ -0,0 +1,291 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package peer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+	"github.com/kaito-project/grit/pkg/util/nonce"
+)
+
+func TestPeerTransfer(t *testing.T) {
+	secret := []byte("shared-secret")
+	hostPath := t.TempDir()
+	ckptDir := filepath.Join(hostPath, "default", "ckpt")
+	os.MkdirAll(filepath.Join(ckptDir, "rootfs"), os.ModePerm)
+	os.MkdirAll(filepath.Join(ckptDir, "app", "checkpoint"), os.ModePerm)
+	os.WriteFile(filepath.Join(ckptDir, "app", "checkpoint", "pages-1.img"), []byte("pages"), 0644)
+	os.WriteFile(filepath.Join(ckptDir, "rootfs", "diff.tar"), []byte("rootfs diff"), 0644)
+	os.WriteFile(filepath.Join(hostPath, "default", "secret.txt"), []byte("not checkpointed data"), 0644)
+	manifest, err := metadata.GenerateManifest(ckptDir)
+	if err != nil {
+		t.Fatalf("failed to generate manifest, %v", err)
+	}
+	if err := metadata.WriteManifest(ckptDir, manifest); err != nil {
+		t.Fatalf("failed to write manifest, %v", err)
+	}
+
+	tlsDir := t.TempDir()
+	writeTestCertificates(t, tlsDir)
+	serverTLSConfig, err := LoadServerTLSConfig(tlsDir)
+	if err != nil {
+		t.Fatalf("failed to load server tls config, %v", err)
+	}
+	clientTLSConfig, err := LoadClientTLSConfig(tlsDir)
+	if err != nil {
+		t.Fatalf("failed to load client tls config, %v", err)
+	}
+
+	peerServer := NewServer(hostPath, "node1", secret)
+	peerServer.criuPath = filepath.Join(t.TempDir(), "criu-not-found")
+	server := httptest.NewUnstartedServer(peerServer)
+	server.TLS = serverTLSConfig
+	server.StartTLS()
+	defer server.Close()
+	address := server.Listener.Addr().String()
+	baseURL := CheckpointURL(address, "node1", "default", "ckpt")
+
+	t.Run("pull checkpointed data from peer", func(t *testing.T) {
+		client := NewClient(baseURL, secret, clientTLSConfig)
+		got, err := client.GetManifest(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get manifest, %v", err)
+		}
+
+		dstDir := t.TempDir()
+		// files are downloaded concurrently, so progress is reported from multiple goroutines.
+		var transferred atomic.Int64
+		if err := client.Download(context.Background(), got, dstDir, func(n int64) { transferred.Add(n) }); err != nil {
+			t.Fatalf("failed to download, %v", err)
+		}
+		if err := metadata.VerifyManifest(dstDir, manifest); err != nil {
+			t.Fatalf("downloaded data is not verified, %v", err)
+		}
+		if transferred.Load() != manifest.TotalBytes() {
+			t.Fatalf("expected %d bytes transferred, got %d", manifest.TotalBytes(), transferred.Load())
+		}
+	})
+
+	t.Run("request with wrong secret is rejected", func(t *testing.T) {
+		if _, err := NewClient(baseURL, []byte("wrong"), clientTLSConfig).GetManifest(context.Background()); err == nil {
+			t.Fatalf("expected request with wrong secret to be rejected")
+		}
+	})
+
+	t.Run("request for another node is rejected", func(t *testing.T) {
+		if _, err := NewClient(CheckpointURL(address, "node2", "default", "ckpt"), secret, clientTLSConfig).GetManifest(context.Background()); err == nil {
+			t.Fatalf("expected request signed for another node to be rejected")
+		}
+	})
+
+	t.Run("server with untrusted certificate is rejected", func(t *testing.T) {
+		untrustedDir := t.TempDir()
+		writeTestCertificates(t, untrustedDir)
+		untrustedTLSConfig, err := LoadClientTLSConfig(untrustedDir)
+		if err != nil {
+			t.Fatalf("failed to load client tls config, %v", err)
+		}
+		if _, err := NewClient(baseURL, secret, untrustedTLSConfig).GetManifest(context.Background()); err == nil {
+			t.Fatalf("expected server with untrusted certificate to be rejected")
+		}
+	})
+
+	t.Run("unsigned request is rejected", func(t *testing.T) {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+		resp, err := httpClient.Get(baseURL + "/manifest")
+		if err != nil {
+			t.Fatalf("failed to send request, %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status code %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("file out of manifest is not served", func(t *testing.T) {
+		client := NewClient(baseURL, secret, clientTLSConfig)
+		for _, path := range []string{"files/../secret.txt", "files/%2e%2e/secret.txt", "files/manifest.json"} {
+			body, err := client.get(context.Background(), path)
+			if err == nil {
+				body.Close()
+				t.Fatalf("expected %s not to be served", path)
+			}
+		}
+	})
+
+	t.Run("page server is not started for directory without memory pages", func(t *testing.T) {
+		if err := NewClient(baseURL, secret, clientTLSConfig).StartPageServer(context.Background(), "rootfs", filepath.Join(t.TempDir(), "0.sock")); err == nil {
+			t.Fatalf("expected page server not to be started for directory without memory pages")
+		}
+	})
+
+	t.Run("page server failure is reported", func(t *testing.T) {
+		if err := NewClient(baseURL, secret, clientTLSConfig).StartPageServer(context.Background(), "app/checkpoint", filepath.Join(t.TempDir(), "0.sock")); err == nil {
+			t.Fatalf("expected failure of page server to be reported")
+		}
+	})
+
+	t.Run("page server is tunneled through unix socket", func(t *testing.T) {
+		// fake criu page server echoes data on the inherited socket.
+		criuPath := filepath.Join(t.TempDir(), "criu")
+		os.WriteFile(criuPath, []byte("#!/bin/sh\nexec cat <&3 >&3\n"), 0755)
+		criuPathBackup := peerServer.criuPath
+		peerServer.criuPath = criuPath
+		defer func() { peerServer.criuPath = criuPathBackup }()
+
+		socketPath := filepath.Join(t.TempDir(), "page-servers", "0.sock")
+		if err := NewClient(baseURL, secret, clientTLSConfig).StartPageServer(context.Background(), "app/checkpoint", socketPath); err != nil {
+			t.Fatalf("failed to start page server, %v", err)
+		}
+		if info, err := os.Stat(socketPath); err != nil || info.Mode().Perm() != 0600 {
+			t.Fatalf("expected socket of page server is only accessible by owner, %v, %v", info, err)
+		}
+
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			t.Fatalf("failed to connect page server, %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("pages"))
+		buf := make([]byte, len("pages"))
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pages" {
+			t.Fatalf("expected data is tunneled to page server, but got %q, %v", buf, err)
+		}
+	})
+
+	t.Run("page server is not served without connection upgrade", func(t *testing.T) {
+		body, err := NewClient(baseURL, secret, clientTLSConfig).do(context.Background(), http.MethodPost, "page-server/app/checkpoint")
+		if err == nil {
+			body.Close()
+			t.Fatalf("expected page server request without connection upgrade to be rejected")
+		}
+	})
+
+	t.Run("checkpointed data which is being downloaded is not served", func(t *testing.T) {
+		if err := metadata.WriteDownloadState(ckptDir, &metadata.DownloadState{Phase: metadata.DownloadPhaseDownloading}); err != nil {
+			t.Fatalf("failed to write download state, %v", err)
+		}
+		defer os.Remove(filepath.Join(ckptDir, metadata.DownloadSentinelFile))
+
+		if _, err := NewClient(baseURL, secret, clientTLSConfig).GetManifest(context.Background()); err == nil {
+			t.Fatalf("expected incomplete data not to be served")
+		}
+	})
+}
+
+func TestVerifyRequest(t *testing.T) {
+	secret := []byte("shared-secret")
+	now := time.Now()
+	path := "/nodes/node1/checkpoints/default/ckpt/manifest"
+
+	t.Run("signed request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		signRequest(req, secret, now)
+		if err := verifyRequest(req, secret, nonce.NewCache(), now); err != nil {
+			t.Fatalf("expected request to be verified, %v", err)
+		}
+	})
+
+	t.Run("expired request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		signRequest(req, secret, now.Add(-time.Hour))
+		if err := verifyRequest(req, secret, nonce.NewCache(), now); err == nil {
+			t.Fatalf("expected expired request to be rejected")
+		}
+	})
+
+	t.Run("signature for another path", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		signRequest(req, secret, now)
+		req.URL.Path = "/nodes/node2/checkpoints/default/ckpt/manifest"
+		if err := verifyRequest(req, secret, nonce.NewCache(), now); err == nil {
+			t.Fatalf("expected request with mismatched signature to be rejected")
+		}
+	})
+
+	t.Run("replayed request", func(t *testing.T) {
+		nonces := nonce.NewCache()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		signRequest(req, secret, now)
+		if err := verifyRequest(req, secret, nonces, now); err != nil {
+			t.Fatalf("expected request to be verified, %v", err)
+		}
+		if err := verifyRequest(req, secret, nonces, now.Add(time.Second)); err == nil {
+			t.Fatalf("expected replayed request to be rejected")
+		}
+	})
+}
+
+// writeTestCertificates writes a self-signed ca and a serving certificate for ServerName into dir.
+func writeTestCertificates(t *testing.T, dir string) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ca key, %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "grit-agent-peer-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create ca certificate, %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key, %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: ServerName},
+		DNSNames:     []string{ServerName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate, %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key, %v", err)
+	}
+
+	for file, block := range map[string]*pem.Block{
+		caFile:   {Type: "CERTIFICATE", Bytes: caDER},
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("failed to write %s, %v", file, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+	"github.com/kaito-project/grit/pkg/util/nonce"
+)
+
+// Server serves checkpointed data under host path to grit agent daemons on other nodes. only completed
+// checkpointed data(which has a manifest) is served, and only files recorded in the manifest can be read.
+// memory pages can also be served by criu page server for lazy restore. requests are only accepted if they're
+// signed for this node.
+type Server struct {
+	hostPath string
+	nodeName string
+	secret   []byte
+	nonces   *nonce.Cache
+	criuPath string
+	mux      *http.ServeMux
+	now      func() time.Time
+}
+
+func NewServer(hostPath, nodeName string, secret []byte) *Server {
+	s := &Server{
+		hostPath: hostPath,
+		nodeName: nodeName,
+		secret:   secret,
+		nonces:   nonce.NewCache(),
+		criuPath: "criu",
+		mux:      http.NewServeMux(),
+		now:      time.Now,
+	}
+	s.mux.HandleFunc("GET /nodes/{node}/checkpoints/{namespace}/{name}/manifest", s.serveManifest)
+	s.mux.HandleFunc("GET /nodes/{node}/checkpoints/{namespace}/{name}/files/{path...}", s.serveFile)
+	s.mux.HandleFunc("POST /nodes/{node}/checkpoints/{namespace}/{name}/page-server/{path...}", s.servePageServer)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := verifyRequest(req, s.secret, s.nonces, s.now()); err != nil {
+		log.FromContext(req.Context()).Info("reject peer request", "path", req.URL.Path, "remote", req.RemoteAddr, "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// node name is covered by the signature, so the request signed for another node is rejected.
+	if !strings.HasPrefix(req.URL.Path, "/nodes/"+s.nodeName+"/") {
+		http.Error(w, fmt.Sprintf("request is not signed for node(%s)", s.nodeName), http.StatusMisdirectedRequest)
+		return
+	}
+	s.mux.ServeHTTP(w, req)
+}
+
+func (s *Server) serveManifest(w http.ResponseWriter, req *http.Request) {
+	manifest, ok := s.completedManifest(w, req)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, req *http.Request) {
+	manifest, ok := s.completedManifest(w, req)
+	if !ok {
+		return
+	}
+
+	filePath := req.PathValue("path")
+	if !lo.ContainsBy(manifest.Files, func(f metadata.ManifestEntry) bool { return filepath.ToSlash(f.Path) == filePath }) {
+		http.Error(w, "file is not found in checkpointed data", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(s.checkpointDir(req), filepath.FromSlash(filePath)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := io.Copy(w, f); err != nil {
+		log.FromContext(req.Context()).Error(err, "failed to serve checkpointed file", "file", filePath)
+	}
+}
+
+// completedManifest returns manifest of checkpointed data in the request. data which is being downloaded
+// on this node is not served, because it may be incomplete.
+func (s *Server) completedManifest(w http.ResponseWriter, req *http.Request) (*metadata.Manifest, bool) {
+	namespace, name := req.PathValue("namespace"), req.PathValue("name")
+	if !isValidSegment(namespace) || !isValidSegment(name) {
+		http.Error(w, "invalid checkpoint path", http.StatusBadRequest)
+		return nil, false
+	}
+
+	dir := s.checkpointDir(req)
+	if state, err := metadata.ReadDownloadState(dir); err == nil && state.Phase != metadata.DownloadPhaseReady {
+		http.Error(w, "checkpointed data is not ready", http.StatusNotFound)
+		return nil, false
+	}
+
+	manifest, err := metadata.ReadManifest(dir)
+	if err != nil {
+		http.Error(w, "checkpointed data is not found", http.StatusNotFound)
+		return nil, false
+	}
+	return manifest, true
+}
+
+func (s *Server) checkpointDir(req *http.Request) string {
+	return filepath.Join(s.hostPath, req.PathValue("namespace"), req.PathValue("name"))
+}
+
+func isValidSegment(segment string) bool {
+	return len(segment) != 0 && segment != "." && segment != ".." && path.Base(segment) == segment
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/gritagent/peer"
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+// pageServerSocketDir is the directory under host path for unix sockets of criu page servers. sockets are not
+// created in the download dir, because the path of unix socket is limited to 108 bytes.
+const pageServerSocketDir = ".page-servers"
+
+// restoreLazily pulls checkpointed data except memory pages from the peer, then marks the data as MetadataReady
+// with unix sockets of criu page servers on the source node. restoration pod is started with criu lazy-pages while
+// memory pages are downloaded in the background, and the data is marked as Ready after all pages arrived.
+// started is false if restoration pod is not allowed to start yet, so the caller can fall back to pulling
+// all data before restoring.
+func restoreLazily(ctx context.Context, hostPath, dstDir string, client *peer.Client, manifest *metadata.Manifest) (started bool, reason string, err error) {
+	if isDownloaded(dstDir, manifest) {
+		log.FromContext(ctx).Info("checkpointed data has been downloaded, reuse it", "dst-dir", dstDir)
+		return true, "", nil
+	}
+
+	pages, others := manifest.PageImages()
+	if len(pages) == 0 {
+		return false, ReasonDownloadFailed, errors.New("there are no memory pages in checkpointed data")
+	}
+
+	// sockets are only accessible by root, and they're removed after all memory pages are downloaded.
+	if err := os.MkdirAll(filepath.Join(hostPath, pageServerSocketDir), 0700); err != nil {
+		return false, ReasonDownloadFailed, err
+	}
+	socketDir, err := os.MkdirTemp(filepath.Join(hostPath, pageServerSocketDir), "")
+	if err != nil {
+		return false, ReasonDownloadFailed, err
+	}
+	defer os.RemoveAll(socketDir)
+
+	// criu page server is started for each criu images directory, there is one for each container.
+	pageServers := make(map[string]string)
+	for _, f := range pages {
+		dir := filepath.Dir(f.Path)
+		if _, ok := pageServers[dir]; ok {
+			continue
+		}
+		socketPath := filepath.Join(socketDir, fmt.Sprintf("%d.sock", len(pageServers)))
+		if err := client.StartPageServer(ctx, dir, socketPath); err != nil {
+			return false, ReasonDownloadFailed, err
+		}
+		pageServers[dir] = socketPath
+	}
+
+	state := &metadata.DownloadState{Phase: metadata.DownloadPhaseDownloading, TotalBytes: manifest.TotalBytes()}
+	if err := metadata.WriteDownloadState(dstDir, state); err != nil {
+		return false, ReasonDownloadFailed, err
+	}
+
+	var mu sync.Mutex
+	progress := func(copiedBytes int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		state.TransferredBytes += copiedBytes
+		if err := metadata.WriteDownloadState(dstDir, state); err != nil {
+			log.FromContext(ctx).Error(err, "failed to update download state")
+		}
+	}
+
+	if err := client.DownloadFiles(ctx, others, dstDir, progress); err != nil {
+		return false, ReasonDownloadFailed, err
+	}
+	if err := metadata.VerifyFiles(dstDir, others); err != nil {
+		return false, ReasonVerificationFailed, err
+	}
+
+	mu.Lock()
+	state.Phase = metadata.DownloadPhaseMetadataReady
+	state.PageServers = pageServers
+	err = metadata.WriteDownloadState(dstDir, state)
+	mu.Unlock()
+	if err != nil {
+		return false, ReasonDownloadFailed, err
+	}
+	log.FromContext(ctx).Info("restoration can be started, memory pages are downloaded in the background", "dst-dir", dstDir, "page-servers", pageServers)
+
+	// phase is kept as MetadataReady until all pages are verified, so restoration pod can start at any time.
+	if err := client.DownloadFiles(ctx, pages, dstDir, progress); err != nil {
+		return true, ReasonDownloadFailed, err
+	}
+	if err := metadata.VerifyManifest(dstDir, manifest); err != nil {
+		return true, ReasonVerificationFailed, err
+	}
+	if err := metadata.WriteManifest(dstDir, manifest); err != nil {
+		return true, ReasonDownloadFailed, err
+	}
+
+	state.Phase = metadata.DownloadPhaseReady
+	state.PageServers = nil
+	if err := metadata.WriteDownloadState(dstDir, state); err != nil {
+		return true, ReasonDownloadFailed, err
+	}
+	log.FromContext(ctx).Info("all memory pages are downloaded", "dst-dir", dstDir)
+	return true, "", nil
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
+	"github.com/kaito-project/grit/pkg/gritagent/janitor"
+)
+
+// RunPrestage downloads checkpointed data to a candidate node of restoration pod before the checkpointed pod is
+// deleted, restore on this node will find the data under host path and only verify it.
+func RunPrestage(ctx context.Context, opts *options.GritAgentOptions) error {
+	if err := RunRestore(ctx, opts); err != nil {
+		// the failed download state is removed with partial data, otherwise restoration pod which is scheduled
+		// to this node later will fail fast instead of waiting for the data to be downloaded again.
+		if removeErr := os.RemoveAll(opts.DstDir); removeErr != nil {
+			log.FromContext(ctx).Error(removeErr, "failed to remove partial pre-staged data", "dst-dir", opts.DstDir)
+		}
+		return err
+	}
+	return nil
+}
+
+// RunCleanup removes pre-staged checkpointed data which is not used by restoration pod. if dst dir is not
+// specified, all checkpointed data under host path which is not needed anymore is removed.
+func RunCleanup(ctx context.Context, opts *options.GritAgentOptions) error {
+	if len(opts.DstDir) == 0 {
+		j, err := janitor.NewFromOptions(opts, nil)
+		if err != nil {
+			return err
+		}
+		_, err = j.Sweep(ctx)
+		return err
+	}
+
+	if !IsSubPath(opts.HostPath, opts.DstDir) {
+		return fmt.Errorf("directory %s is not under host path %s", opts.DstDir, opts.HostPath)
+	}
+
+	log.FromContext(ctx).Info("remove pre-staged checkpointed data", "dst-dir", opts.DstDir)
+	return os.RemoveAll(opts.DstDir)
+}
+
+// IsSubPath checks dir is under root, and it's not root itself.
+func IsSubPath(root, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(dir))
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -5,17 +5,193 @@ package restore
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
 	"github.com/kaito-project/grit/pkg/gritagent/copy"
+	"github.com/kaito-project/grit/pkg/gritagent/metrics"
+	"github.com/kaito-project/grit/pkg/gritagent/peer"
 	"github.com/kaito-project/grit/pkg/metadata"
 )
 
+const (
+	ReasonDownloadFailed     = "DownloadFailed"
+	ReasonVerificationFailed = "VerificationFailed"
+	ReasonDataNotFound       = "DataNotFound"
+)
+
 func RunRestore(ctx context.Context, opts *options.GritAgentOptions) error {
-	// download checkpointed data from cloud storage
-	if err := copy.TransferData(ctx, opts.SrcDir, opts.DstDir); err != nil {
+	if err := os.MkdirAll(opts.DstDir, os.ModePerm); err != nil {
 		return err
 	}
 
-	return copy.CreateSentinelFile(opts.DstDir, metadata.DownloadSentinelFile)
+	// checkpointed data is pulled from grit agent daemon on the source node directly if peer url is specified.
+	// grit agent daemon never mounts cloud storage, so grit-manager falls back to a grit agent job which
+	// downloads from cloud storage if pulling from the peer failed.
+	if len(opts.PeerURL) != 0 {
+		if reason, err := restoreFromPeer(ctx, opts); err != nil {
+			return failRestore(opts.DstDir, reason, err)
+		}
+		return nil
+	}
+
+	// checkpointed data is already on this node when the pod is restored on the node where it's checkpointed,
+	// or cloud storage is not used by the checkpoint. so the data is verified in place instead of downloading.
+	if len(opts.SrcDir) == 0 {
+		return restoreLocalData(ctx, opts.DstDir)
+	}
+
+	// manifest doesn't exist for checkpointed data generated by previous grit-agent,
+	// so download progress is reported without total size in this case.
+	manifest, err := metadata.ReadManifest(opts.SrcDir)
+	if err != nil && !os.IsNotExist(err) {
+		return failRestore(opts.DstDir, ReasonDownloadFailed, err)
+	}
+
+	// download checkpointed data from cloud storage
+	reason, err := downloadData(ctx, opts.DstDir, manifest, func(progress copy.ProgressFunc) error {
+		return copy.TransferData(ctx, opts.SrcDir, opts.DstDir, progress)
+	})
+	if err != nil {
+		return failRestore(opts.DstDir, reason, err)
+	}
+	return nil
+}
+
+// restoreFromPeer pulls checkpointed data from grit agent daemon on the node where the pod is checkpointed,
+// the reason is returned with error for recording into download state.
+func restoreFromPeer(ctx context.Context, opts *options.GritAgentOptions) (string, error) {
+	if len(opts.PeerSecretFile) == 0 {
+		return ReasonDownloadFailed, fmt.Errorf("peer secret is not specified")
+	}
+	secret, err := peer.LoadSecret(opts.PeerSecretFile)
+	if err != nil {
+		return ReasonDownloadFailed, err
+	}
+	tlsConfig, err := peer.LoadClientTLSConfig(opts.PeerTLSDir)
+	if err != nil {
+		return ReasonDownloadFailed, err
+	}
+
+	client := peer.NewClient(opts.PeerURL, secret, tlsConfig)
+	manifest, err := client.GetManifest(ctx)
+	if err != nil {
+		return ReasonDownloadFailed, err
+	}
+
+	if opts.LazyPages {
+		started, reason, err := restoreLazily(ctx, opts.HostPath, opts.DstDir, client, manifest)
+		if started || err == nil {
+			return reason, err
+		}
+		log.FromContext(ctx).Error(err, "lazy restore is unavailable, pull all checkpointed data before restoring", "peer-url", opts.PeerURL)
+	}
+
+	return downloadData(ctx, opts.DstDir, manifest, func(progress copy.ProgressFunc) error {
+		return client.Download(ctx, manifest, opts.DstDir, progress)
+	})
+}
+
+// downloadData transfers checkpointed data into dstDir and records the progress into download state, the data
+// is verified with manifest if manifest is provided. the reason is returned with error, download state is not
+// marked as failed here, so the caller can fall back to another source.
+func downloadData(ctx context.Context, dstDir string, manifest *metadata.Manifest, transfer func(copy.ProgressFunc) error) (string, error) {
+	// checkpointed data may have been downloaded on this node for another restoration pod, like restoration
+	// pods of fan-out restore, so the downloaded data is reused if it's the same as checkpointed data.
+	if manifest != nil && isDownloaded(dstDir, manifest) {
+		log.FromContext(ctx).Info("checkpointed data has been downloaded, reuse it", "dst-dir", dstDir)
+		return "", nil
+	}
+
+	state := &metadata.DownloadState{Phase: metadata.DownloadPhaseDownloading}
+	if manifest != nil {
+		state.TotalBytes = manifest.TotalBytes()
+	}
+	if err := metadata.WriteDownloadState(dstDir, state); err != nil {
+		return ReasonDownloadFailed, err
+	}
+
+	var mu sync.Mutex
+	start := time.Now()
+	err := transfer(func(copiedBytes int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		state.TransferredBytes += copiedBytes
+		if err := metadata.WriteDownloadState(dstDir, state); err != nil {
+			log.FromContext(ctx).Error(err, "failed to update download state")
+		}
+	})
+	if err != nil {
+		return ReasonDownloadFailed, err
+	}
+	metrics.ObserveTransfer(metrics.DirectionDownload, state.TransferredBytes, time.Since(start))
+
+	if manifest != nil {
+		state.Phase = metadata.DownloadPhaseVerifying
+		if err := metadata.WriteDownloadState(dstDir, state); err != nil {
+			return ReasonDownloadFailed, err
+		}
+		if err := metadata.VerifyManifest(dstDir, manifest); err != nil {
+			return ReasonVerificationFailed, err
+		}
+	}
+
+	state.Phase = metadata.DownloadPhaseReady
+	if err := metadata.WriteDownloadState(dstDir, state); err != nil {
+		return ReasonDownloadFailed, err
+	}
+	return "", nil
+}
+
+// restoreLocalData verifies checkpointed data on the node, and marks it as ready for restoration pod.
+func restoreLocalData(ctx context.Context, dir string) error {
+	manifest, err := metadata.ReadManifest(dir)
+	if err != nil {
+		return failRestore(dir, ReasonDataNotFound, fmt.Errorf("checkpointed data is not found on the node, %w", err))
+	}
+
+	if err := metadata.VerifyManifest(dir, manifest); err != nil {
+		return failRestore(dir, ReasonVerificationFailed, err)
+	}
+
+	log.FromContext(ctx).Info("checkpointed data is on the node, skip downloading", "dst-dir", dir)
+	return metadata.WriteDownloadState(dir, &metadata.DownloadState{
+		Phase:            metadata.DownloadPhaseReady,
+		TotalBytes:       manifest.TotalBytes(),
+		TransferredBytes: manifest.TotalBytes(),
+	})
+}
+
+// isDownloaded checks whether checkpointed data described by manifest has been downloaded into dir.
+func isDownloaded(dir string, manifest *metadata.Manifest) bool {
+	state, err := metadata.ReadDownloadState(dir)
+	if err != nil || state.Phase != metadata.DownloadPhaseReady {
+		return false
+	}
+
+	downloaded, err := metadata.ReadManifest(dir)
+	if err != nil || !reflect.DeepEqual(downloaded, manifest) {
+		return false
+	}
+	return metadata.VerifyManifest(dir, manifest) == nil
+}
+
+// failRestore records the failure into download state, so restoration pod can fail fast
+// instead of waiting for the checkpointed data until timeout.
+func failRestore(dir, reason string, err error) error {
+	state := &metadata.DownloadState{
+		Phase:   metadata.DownloadPhaseFailed,
+		Reason:  reason,
+		Message: err.Error(),
+	}
+	if writeErr := metadata.WriteDownloadState(dir, state); writeErr != nil {
+		return fmt.Errorf("%s: %w, failed to write download state: %v", reason, err, writeErr)
+	}
+	return fmt.Errorf("%s: %w", reason, err)
 }
@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package restore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaito-project/grit/pkg/metadata"
+)
+
+func TestIsDownloaded(t *testing.T) {
+	manifest := &metadata.Manifest{Files: []metadata.ManifestEntry{{Path: "pages-1.img", Size: 4}}}
+	prepare := func(t *testing.T, phase metadata.DownloadPhase) string {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "pages-1.img"), []byte("data"), 0644)
+		if err := metadata.WriteManifest(dir, manifest); err != nil {
+			t.Fatalf("failed to write manifest, %v", err)
+		}
+		if err := metadata.WriteDownloadState(dir, &metadata.DownloadState{Phase: phase}); err != nil {
+			t.Fatalf("failed to write download state, %v", err)
+		}
+		return dir
+	}
+
+	t.Run("data has been downloaded", func(t *testing.T) {
+		dir := prepare(t, metadata.DownloadPhaseReady)
+		if !isDownloaded(dir, manifest) {
+			t.Fatalf("expected downloaded data to be reused")
+		}
+	})
+
+	t.Run("download is not completed", func(t *testing.T) {
+		dir := prepare(t, metadata.DownloadPhaseDownloading)
+		if isDownloaded(dir, manifest) {
+			t.Fatalf("expected downloading data not to be reused")
+		}
+	})
+
+	t.Run("checkpointed data is changed", func(t *testing.T) {
+		dir := prepare(t, metadata.DownloadPhaseReady)
+		changed := &metadata.Manifest{Files: []metadata.ManifestEntry{{Path: "pages-1.img", Size: 8}}}
+		if isDownloaded(dir, changed) {
+			t.Fatalf("expected stale data not to be reused")
+		}
+	})
+
+	t.Run("nothing is downloaded", func(t *testing.T) {
+		if isDownloaded(t.TempDir(), manifest) {
+			t.Fatalf("expected empty directory not to be reused")
+		}
+	})
+}
+
+func TestRestoreLocalData(t *testing.T) {
+	t.Run("checkpointed data is on the node", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "pages-1.img"), []byte("data"), 0644)
+		manifest, err := metadata.GenerateManifest(dir)
+		if err != nil {
+			t.Fatalf("failed to generate manifest, %v", err)
+		}
+		if err := metadata.WriteManifest(dir, manifest); err != nil {
+			t.Fatalf("failed to write manifest, %v", err)
+		}
+
+		if err := restoreLocalData(context.Background(), dir); err != nil {
+			t.Fatalf("expected local data to be restored, %v", err)
+		}
+		state, err := metadata.ReadDownloadState(dir)
+		if err != nil || state.Phase != metadata.DownloadPhaseReady {
+			t.Fatalf("expected download state to be ready, got %v, %v", state, err)
+		}
+	})
+
+	t.Run("checkpointed data is corrupted", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "pages-1.img"), []byte("data"), 0644)
+		manifest, err := metadata.GenerateManifest(dir)
+		if err != nil {
+			t.Fatalf("failed to generate manifest, %v", err)
+		}
+		if err := metadata.WriteManifest(dir, manifest); err != nil {
+			t.Fatalf("failed to write manifest, %v", err)
+		}
+		os.WriteFile(filepath.Join(dir, "pages-1.img"), []byte("atad"), 0644)
+
+		if err := restoreLocalData(context.Background(), dir); err == nil {
+			t.Fatalf("expected restore to fail with corrupted data")
+		}
+		state, err := metadata.ReadDownloadState(dir)
+		if err != nil || state.Phase != metadata.DownloadPhaseFailed || state.Reason != ReasonVerificationFailed {
+			t.Fatalf("expected download state to be failed with %s, got %v, %v", ReasonVerificationFailed, state, err)
+		}
+	})
+
+	t.Run("checkpointed data is not on the node", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := restoreLocalData(context.Background(), dir); err == nil {
+			t.Fatalf("expected restore to fail without checkpointed data")
+		}
+		state, err := metadata.ReadDownloadState(dir)
+		if err != nil || state.Phase != metadata.DownloadPhaseFailed || state.Reason != ReasonDataNotFound {
+			t.Fatalf("expected download state to be failed with %s, got %v, %v", ReasonDataNotFound, state, err)
+		}
+	})
+}
@@ -0,0 +1,363 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package agentmanager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+)
+
+const (
+	// MaxConcurrentOperationsKey, MaxConcurrentOperationsPerNodeKey and MaxConcurrentOperationsPerStorageKey cap
+	// the number of checkpoint and restore operations which transfer checkpointed data at the same time in the
+	// cluster, on each node and in each storage backend. it's unlimited if the key is not specified or 0.
+	MaxConcurrentOperationsKey           = "max-concurrent-operations"
+	MaxConcurrentOperationsPerNodeKey    = "max-concurrent-operations-per-node"
+	MaxConcurrentOperationsPerStorageKey = "max-concurrent-operations-per-storage"
+	// BandwidthLimitKey is the max rate of transferring checkpointed data for each operation, like "100Mi",
+	// it's in bytes per second.
+	BandwidthLimitKey = "bandwidth-limit"
+
+	// admittedTTL covers the delay of informer cache, operations which are just admitted may not be observed
+	// in Checkpointing or Restoring phase when the next operation is admitted.
+	admittedTTL = time.Minute
+)
+
+// AdmissionLimits is the max number of concurrent checkpoint and restore operations, 0 means unlimited.
+type AdmissionLimits struct {
+	Total      int
+	PerNode    int
+	PerStorage int
+}
+
+func (l AdmissionLimits) unlimited() bool {
+	return l.Total <= 0 && l.PerNode <= 0 && l.PerStorage <= 0
+}
+
+// AdmissionResult is the result of admitting a checkpoint or restore operation.
+type AdmissionResult struct {
+	Admitted bool
+	// Reason is the limit which is reached when the operation is not admitted.
+	Reason string
+	// QueuePosition is the position of the operation in the queue when it's not admitted, it starts from 1.
+	QueuePosition int32
+}
+
+// runningOperation is a checkpoint or restore operation which is transferring checkpointed data on the node.
+// storage is the backend of cloud storage, it's empty if checkpointed data is kept on the node.
+type runningOperation struct {
+	id      string
+	node    string
+	storage string
+}
+
+// queuedOperation is a checkpoint or restore operation which is waiting for admission, queued operations
+// are admitted in order of priority, deadline and creation time.
+type queuedOperation struct {
+	runningOperation
+	priority     int32
+	deadline     *metav1.Time
+	creationTime metav1.Time
+}
+
+// before checks whether q should be admitted before other.
+func (q *queuedOperation) before(other *queuedOperation) bool {
+	if q.priority != other.priority {
+		return q.priority > other.priority
+	}
+	if (q.deadline == nil) != (other.deadline == nil) {
+		return q.deadline != nil
+	}
+	if q.deadline != nil && !q.deadline.Equal(other.deadline) {
+		return q.deadline.Before(other.deadline)
+	}
+	if !q.creationTime.Equal(&other.creationTime) {
+		return q.creationTime.Before(&other.creationTime)
+	}
+	return q.id < other.id
+}
+
+type admittedOperation struct {
+	runningOperation
+	admittedTime time.Time
+}
+
+// admission tracks operations which are admitted but not observed in informer cache yet.
+type admission struct {
+	sync.Mutex
+	admitted map[string]admittedOperation
+}
+
+// getAdmissionLimits returns limits of concurrent operations in grit-agent-config.
+func (m *AgentManager) getAdmissionLimits() AdmissionLimits {
+	cm, err := m.lister.ConfigMaps(m.namespace).Get(GritAgentConfigMapName)
+	if err != nil {
+		return AdmissionLimits{}
+	}
+
+	limit := func(key string) int {
+		n, err := strconv.Atoi(strings.TrimSpace(cm.Data[key]))
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	return AdmissionLimits{
+		Total:      limit(MaxConcurrentOperationsKey),
+		PerNode:    limit(MaxConcurrentOperationsPerNodeKey),
+		PerStorage: limit(MaxConcurrentOperationsPerStorageKey),
+	}
+}
+
+// getBandwidthLimit returns the max rate of transferring checkpointed data in bytes per second, 0 is returned
+// if it's not specified or invalid.
+func (m *AgentManager) getBandwidthLimit() int64 {
+	cm, err := m.lister.ConfigMaps(m.namespace).Get(GritAgentConfigMapName)
+	if err != nil {
+		return 0
+	}
+
+	value := strings.TrimSpace(cm.Data[BandwidthLimitKey])
+	if len(value) == 0 {
+		return 0
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil || quantity.Sign() <= 0 {
+		return 0
+	}
+	return quantity.Value()
+}
+
+// AdmitCheckpoint checks whether checkpoint operation can be started now. the checkpoint should be queued if it's
+// not admitted, because any limit of concurrent operations is reached or queued operations ahead of it take
+// the remaining capacity.
+func (m *AgentManager) AdmitCheckpoint(ctx context.Context, ckpt *v1alpha1.Checkpoint) (AdmissionResult, error) {
+	storage, err := m.storageKey(ctx, ckpt)
+	if err != nil {
+		return AdmissionResult{}, err
+	}
+	return m.admit(ctx, queuedOperation{
+		runningOperation: runningOperation{id: OperationID(ckpt, nil), node: ckpt.Status.NodeName, storage: storage},
+		priority:         ckpt.Status.Priority,
+		deadline:         ckpt.Spec.Deadline,
+		creationTime:     ckpt.CreationTimestamp,
+	})
+}
+
+// AdmitRestore checks whether restore operation can be started now. local restore is always admitted because
+// checkpointed data is not transferred.
+func (m *AgentManager) AdmitRestore(ctx context.Context, ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore) (AdmissionResult, error) {
+	if IsLocalRestore(ckpt, restore.Status.NodeName) {
+		return AdmissionResult{Admitted: true}, nil
+	}
+
+	storage, err := m.storageKey(ctx, ckpt)
+	if err != nil {
+		return AdmissionResult{}, err
+	}
+	return m.admit(ctx, queuedOperation{
+		runningOperation: runningOperation{id: OperationID(ckpt, restore), node: restore.Status.NodeName, storage: storage},
+		priority:         restore.Status.Priority,
+		deadline:         restore.Spec.Deadline,
+		creationTime:     restore.CreationTimestamp,
+	})
+}
+
+func (m *AgentManager) admit(ctx context.Context, op queuedOperation) (AdmissionResult, error) {
+	limits := m.getAdmissionLimits()
+	if limits.unlimited() {
+		return AdmissionResult{Admitted: true}, nil
+	}
+
+	m.admission.Lock()
+	defer m.admission.Unlock()
+
+	running, queued, err := m.listOperations(ctx)
+	if err != nil {
+		return AdmissionResult{}, err
+	}
+
+	// operations which are admitted recently are counted until they are observed as running.
+	observed := make(map[string]bool, len(running))
+	for _, r := range running {
+		observed[r.id] = true
+	}
+	now := time.Now()
+	for id, admitted := range m.admission.admitted {
+		if now.Sub(admitted.admittedTime) > admittedTTL {
+			delete(m.admission.admitted, id)
+		} else if !observed[id] {
+			running = append(running, admitted.runningOperation)
+			observed[id] = true
+		}
+	}
+	queued = lo.Filter(queued, func(q queuedOperation, _ int) bool {
+		return !observed[q.id]
+	})
+
+	reason, position := admitInOrder(running, queued, op, limits)
+	if len(reason) != 0 {
+		return AdmissionResult{Reason: reason, QueuePosition: position}, nil
+	}
+	m.admission.admitted[op.id] = admittedOperation{runningOperation: op.runningOperation, admittedTime: now}
+	return AdmissionResult{Admitted: true}, nil
+}
+
+// admitInOrder admits queued operations and op in order, so capacity is taken by operations ahead of op first.
+// operation which is blocked by its own node or storage doesn't block operations on other nodes or storages.
+// the reason and position of op in the queue are returned if op is not admitted.
+func admitInOrder(running []runningOperation, queued []queuedOperation, op queuedOperation, limits AdmissionLimits) (string, int32) {
+	queue := lo.Filter(queued, func(q queuedOperation, _ int) bool {
+		return q.id != op.id
+	})
+	queue = append(queue, op)
+	sort.SliceStable(queue, func(i, j int) bool {
+		return queue[i].before(&queue[j])
+	})
+
+	admitted := append([]runningOperation{}, running...)
+	for i := range queue {
+		reason := checkLimits(admitted, queue[i].runningOperation, limits)
+		if queue[i].id == op.id {
+			return reason, int32(i + 1)
+		}
+		if len(reason) == 0 {
+			admitted = append(admitted, queue[i].runningOperation)
+		}
+	}
+	return "", 0
+}
+
+// checkLimits returns the limit which is reached if op is started, empty string is returned if op can be started.
+// op itself is excluded from running operations, so an admitted operation is never blocked by itself.
+func checkLimits(running []runningOperation, op runningOperation, limits AdmissionLimits) string {
+	var total, onNode, inStorage int
+	for _, r := range running {
+		if r.id == op.id {
+			continue
+		}
+		total++
+		if r.node == op.node {
+			onNode++
+		}
+		if len(op.storage) != 0 && r.storage == op.storage {
+			inStorage++
+		}
+	}
+
+	switch {
+	case limits.Total > 0 && total >= limits.Total:
+		return fmt.Sprintf("%d operations are running or admitted ahead in the cluster, limit is %d", total, limits.Total)
+	case limits.PerNode > 0 && onNode >= limits.PerNode:
+		return fmt.Sprintf("%d operations are running or admitted ahead on node(%s), limit is %d", onNode, op.node, limits.PerNode)
+	case limits.PerStorage > 0 && len(op.storage) != 0 && inStorage >= limits.PerStorage:
+		return fmt.Sprintf("%d operations are running or admitted ahead in storage(%s), limit is %d", inStorage, op.storage, limits.PerStorage)
+	}
+	return ""
+}
+
+// listOperations lists running and queued operations. checkpoints in Checkpointing phase and restores in Restoring
+// phase which download checkpointed data from cloud storage are running. restore in FanOut mode is not counted,
+// because data is downloaded once on each node by its own jobs.
+func (m *AgentManager) listOperations(ctx context.Context) ([]runningOperation, []queuedOperation, error) {
+	var running []runningOperation
+	var queued []queuedOperation
+	storages := make(map[string]string)
+	checkpoints := make(map[string]*v1alpha1.Checkpoint)
+
+	var ckptList v1alpha1.CheckpointList
+	if err := m.kubeClient.List(ctx, &ckptList); err != nil {
+		return nil, nil, err
+	}
+	for i := range ckptList.Items {
+		ckpt := &ckptList.Items[i]
+		checkpoints[ckpt.Namespace+"/"+ckpt.Name] = ckpt
+		if ckpt.Status.Phase != v1alpha1.Checkpointing && ckpt.Status.Phase != v1alpha1.CheckpointQueued {
+			continue
+		}
+		storage, err := m.cachedStorageKey(ctx, ckpt, storages)
+		if err != nil {
+			return nil, nil, err
+		}
+		op := runningOperation{id: OperationID(ckpt, nil), node: ckpt.Status.NodeName, storage: storage}
+		if ckpt.Status.Phase == v1alpha1.Checkpointing {
+			running = append(running, op)
+		} else {
+			queued = append(queued, queuedOperation{runningOperation: op, priority: ckpt.Status.Priority, deadline: ckpt.Spec.Deadline, creationTime: ckpt.CreationTimestamp})
+		}
+	}
+
+	var restoreList v1alpha1.RestoreList
+	if err := m.kubeClient.List(ctx, &restoreList); err != nil {
+		return nil, nil, err
+	}
+	for i := range restoreList.Items {
+		restore := &restoreList.Items[i]
+		if (restore.Status.Phase != v1alpha1.Restoring && restore.Status.Phase != v1alpha1.RestoreQueued) ||
+			restore.Spec.Mode == v1alpha1.RestoreModeFanOut {
+			continue
+		}
+		ckpt, ok := checkpoints[restore.Namespace+"/"+restore.Spec.CheckpointName]
+		if !ok || IsLocalRestore(ckpt, restore.Status.NodeName) {
+			continue
+		}
+		storage, err := m.cachedStorageKey(ctx, ckpt, storages)
+		if err != nil {
+			return nil, nil, err
+		}
+		op := runningOperation{id: OperationID(ckpt, restore), node: restore.Status.NodeName, storage: storage}
+		if restore.Status.Phase == v1alpha1.Restoring {
+			running = append(running, op)
+		} else {
+			queued = append(queued, queuedOperation{runningOperation: op, priority: restore.Status.Priority, deadline: restore.Spec.Deadline, creationTime: restore.CreationTimestamp})
+		}
+	}
+	return running, queued, nil
+}
+
+func (m *AgentManager) cachedStorageKey(ctx context.Context, ckpt *v1alpha1.Checkpoint, storages map[string]string) (string, error) {
+	if ckpt.Spec.VolumeClaim == nil {
+		return "", nil
+	}
+	claim := ckpt.Namespace + "/" + ckpt.Spec.VolumeClaim.ClaimName
+	if storage, ok := storages[claim]; ok {
+		return storage, nil
+	}
+	storage, err := m.storageKey(ctx, ckpt)
+	if err != nil {
+		return "", err
+	}
+	storages[claim] = storage
+	return storage, nil
+}
+
+// storageKey identifies the storage backend of checkpoint by the persistent volume which is bound to the pvc,
+// so checkpoints in different namespaces which share the same volume are counted together. <namespace>/<pvc name>
+// is used if pvc is not bound yet.
+func (m *AgentManager) storageKey(ctx context.Context, ckpt *v1alpha1.Checkpoint) (string, error) {
+	if ckpt.Spec.VolumeClaim == nil {
+		return "", nil
+	}
+
+	var pvc corev1.PersistentVolumeClaim
+	if err := m.kubeClient.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: ckpt.Spec.VolumeClaim.ClaimName}, &pvc); client.IgnoreNotFound(err) != nil {
+		return "", err
+	} else if err == nil && len(pvc.Spec.VolumeName) != 0 {
+		return pvc.Spec.VolumeName, nil
+	}
+	return ckpt.Namespace + "/" + ckpt.Spec.VolumeClaim.ClaimName, nil
+}
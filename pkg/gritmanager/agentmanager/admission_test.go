@@ -0,0 +1,106 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package agentmanager
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckLimits(t *testing.T) {
+	running := []runningOperation{
+		{id: "checkpoint/default/a", node: "node-1", storage: "pv-1"},
+		{id: "checkpoint/default/b", node: "node-1", storage: "pv-2"},
+		{id: "restore/default/c", node: "node-2", storage: "pv-1"},
+	}
+
+	t.Run("unlimited", func(t *testing.T) {
+		if reason := checkLimits(running, runningOperation{id: "checkpoint/default/d", node: "node-1", storage: "pv-1"}, AdmissionLimits{}); len(reason) != 0 {
+			t.Fatalf("expected operation is admitted, but got %s", reason)
+		}
+	})
+
+	t.Run("cluster-wide limit", func(t *testing.T) {
+		op := runningOperation{id: "checkpoint/default/d", node: "node-3"}
+		if reason := checkLimits(running, op, AdmissionLimits{Total: 3}); len(reason) == 0 {
+			t.Fatalf("expected operation is queued by cluster-wide limit")
+		}
+		if reason := checkLimits(running, op, AdmissionLimits{Total: 4}); len(reason) != 0 {
+			t.Fatalf("expected operation is admitted, but got %s", reason)
+		}
+	})
+
+	t.Run("per node and per storage limits", func(t *testing.T) {
+		limits := AdmissionLimits{PerNode: 2, PerStorage: 2}
+		if reason := checkLimits(running, runningOperation{id: "checkpoint/default/d", node: "node-1"}, limits); len(reason) == 0 {
+			t.Fatalf("expected operation is queued by per node limit")
+		}
+		if reason := checkLimits(running, runningOperation{id: "checkpoint/default/d", node: "node-2", storage: "pv-1"}, limits); len(reason) == 0 {
+			t.Fatalf("expected operation is queued by per storage limit")
+		}
+		// operation without cloud storage is not limited by per storage limit.
+		if reason := checkLimits(running, runningOperation{id: "checkpoint/default/d", node: "node-2"}, limits); len(reason) != 0 {
+			t.Fatalf("expected operation is admitted, but got %s", reason)
+		}
+	})
+
+	t.Run("operation is not blocked by itself", func(t *testing.T) {
+		if reason := checkLimits(running, running[0], AdmissionLimits{PerNode: 2}); len(reason) != 0 {
+			t.Fatalf("expected operation is admitted, but got %s", reason)
+		}
+	})
+}
+
+func TestAdmitInOrder(t *testing.T) {
+	now := time.Now()
+	newQueued := func(id, node string, priority int32, deadline *time.Time, created time.Time) queuedOperation {
+		q := queuedOperation{
+			runningOperation: runningOperation{id: id, node: node},
+			priority:         priority,
+			creationTime:     metav1.NewTime(created),
+		}
+		if deadline != nil {
+			q.deadline = &metav1.Time{Time: *deadline}
+		}
+		return q
+	}
+	soon := now.Add(time.Minute)
+	later := now.Add(time.Hour)
+
+	t.Run("higher priority is admitted first", func(t *testing.T) {
+		queued := []queuedOperation{newQueued("checkpoint/default/high", "node-1", 100, nil, now)}
+		op := newQueued("checkpoint/default/low", "node-2", 0, nil, now.Add(-time.Hour))
+		if reason, position := admitInOrder(nil, queued, op, AdmissionLimits{Total: 1}); len(reason) == 0 || position != 2 {
+			t.Fatalf("expected operation is queued at position 2, but got %d(%s)", position, reason)
+		}
+		if reason, _ := admitInOrder(nil, nil, op, AdmissionLimits{Total: 1}); len(reason) != 0 {
+			t.Fatalf("expected operation is admitted, but got %s", reason)
+		}
+	})
+
+	t.Run("earlier deadline is admitted first with the same priority", func(t *testing.T) {
+		queued := []queuedOperation{
+			newQueued("checkpoint/default/later", "node-1", 0, &later, now.Add(-time.Hour)),
+			newQueued("checkpoint/default/none", "node-1", 0, nil, now.Add(-2*time.Hour)),
+		}
+		op := newQueued("checkpoint/default/soon", "node-1", 0, &soon, now)
+		if reason, position := admitInOrder(nil, queued, op, AdmissionLimits{Total: 1}); len(reason) != 0 || position != 1 {
+			t.Fatalf("expected operation is admitted at position 1, but got %d(%s)", position, reason)
+		}
+		if reason, position := admitInOrder(nil, append(queued, op), queued[1], AdmissionLimits{Total: 2}); len(reason) == 0 || position != 3 {
+			t.Fatalf("expected operation without deadline is queued at position 3, but got %d(%s)", position, reason)
+		}
+	})
+
+	t.Run("operation blocked by its node doesn't block other nodes", func(t *testing.T) {
+		running := []runningOperation{{id: "checkpoint/default/a", node: "node-1"}}
+		queued := []queuedOperation{newQueued("checkpoint/default/b", "node-1", 100, nil, now)}
+		op := newQueued("checkpoint/default/c", "node-2", 0, nil, now)
+		if reason, _ := admitInOrder(running, queued, op, AdmissionLimits{PerNode: 1}); len(reason) != 0 {
+			t.Fatalf("expected operation is admitted, but got %s", reason)
+		}
+	})
+}
@@ -8,18 +8,27 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/samber/lo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	corev1listers "k8s.io/client-go/listers/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
+	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
 	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
+	"github.com/kaito-project/grit/pkg/gritagent/peer"
 	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
 )
 
@@ -27,20 +36,49 @@ const (
 	GritAgentConfigMapName = "grit-agent-config"
 	HostPathKey            = "host-path"
 	GritAgentYamlKey       = "grit-agent-template.yaml"
-	PvcDirInContainer      = "/mnt/pvc-data/"
+	AgentModeKey           = "agent-mode"
+	AgentPortKey           = "agent-port"
+	// PeerPortKey is the port which grit agent daemons serve checkpointed data on for other nodes,
+	// peer transfer is disabled if it's not specified.
+	PeerPortKey       = "peer-port"
+	PvcDirInContainer = "/mnt/pvc-data/"
+
+	// GritAgentServiceAccountName is the service account of grit agent job which takes volume snapshots,
+	// it's bound to GritAgentClusterRoleName in the namespace of Checkpoint.
+	GritAgentServiceAccountName = "grit-agent"
+	GritAgentClusterRoleName    = "grit-agent-clusterrole"
+
+	// AgentModeJob means a grit agent job is created for each checkpoint and restore.
+	AgentModeJob = "job"
+	// AgentModeDaemon means grit agent runs as a DaemonSet, and checkpoint and restore operations
+	// are submitted to the grit agent on the node through grpc api.
+	AgentModeDaemon = "daemon"
+
+	defaultAgentPort    = 10360
+	agentRequestTimeout = 10 * time.Second
 )
 
 type AgentManager struct {
-	namespace string
-	lister    corev1listers.ConfigMapLister
+	namespace  string
+	lister     corev1listers.ConfigMapLister
+	kubeClient client.Reader
+	admission  admission
+	// apiSecret is used for signing requests to grit agent daemons.
+	apiSecret []byte
 }
 
 // +kubebuilder:rbac:groups="",resources=configmaps,verbs=list;watch;get
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=list;watch;get
 
-func NewAgentManager(ns string, lister corev1listers.ConfigMapLister) *AgentManager {
+func NewAgentManager(ns string, lister corev1listers.ConfigMapLister, kubeClient client.Reader, apiSecret []byte) *AgentManager {
 	return &AgentManager{
-		namespace: ns,
-		lister:    lister,
+		namespace:  ns,
+		lister:     lister,
+		kubeClient: kubeClient,
+		apiSecret:  apiSecret,
+		admission: admission{
+			admitted: make(map[string]admittedOperation),
+		},
 	}
 }
 
@@ -52,7 +90,328 @@ func (m *AgentManager) GetHostPath() string {
 	return strings.TrimSpace(cm.Data[HostPathKey])
 }
 
+// GetAgentMode returns the mode of grit agent, AgentModeJob is used by default. grit agent daemons are only
+// used when the api secret for signing requests is configured.
+func (m *AgentManager) GetAgentMode() string {
+	cm, err := m.lister.ConfigMaps(m.namespace).Get(GritAgentConfigMapName)
+	if err != nil {
+		return AgentModeJob
+	}
+
+	if strings.TrimSpace(cm.Data[AgentModeKey]) == AgentModeDaemon && len(m.apiSecret) != 0 {
+		return AgentModeDaemon
+	}
+	return AgentModeJob
+}
+
+func (m *AgentManager) getAgentPort() int {
+	cm, err := m.lister.ConfigMaps(m.namespace).Get(GritAgentConfigMapName)
+	if err != nil {
+		return defaultAgentPort
+	}
+
+	port, err := strconv.Atoi(strings.TrimSpace(cm.Data[AgentPortKey]))
+	if err != nil || port <= 0 {
+		return defaultAgentPort
+	}
+	return port
+}
+
+// getPeerPort returns the port of peer transfer in grit agent daemon, 0 is returned if peer transfer is disabled.
+func (m *AgentManager) getPeerPort() int {
+	cm, err := m.lister.ConfigMaps(m.namespace).Get(GritAgentConfigMapName)
+	if err != nil {
+		return 0
+	}
+
+	port, err := strconv.Atoi(strings.TrimSpace(cm.Data[PeerPortKey]))
+	if err != nil || port <= 0 {
+		return 0
+	}
+	return port
+}
+
+// PeerTransferEnabled checks whether checkpointed data can be pulled from grit agent daemon on the node where
+// the pod is checkpointed, so checkpoint without cloud storage can be restored on other nodes.
+func (m *AgentManager) PeerTransferEnabled() bool {
+	return m.GetAgentMode() == AgentModeDaemon && m.getPeerPort() != 0
+}
+
+// CanRestoreOnNode checks whether checkpointed data is reachable from the node. checkpointed data without cloud
+// storage is kept on the node where the pod is checkpointed, it's only reachable from other nodes through peer transfer.
+func (m *AgentManager) CanRestoreOnNode(ckpt *v1alpha1.Checkpoint, nodeName string) bool {
+	return ckpt.Spec.VolumeClaim != nil || nodeName == ckpt.Status.NodeName || m.PeerTransferEnabled()
+}
+
+// UseAgentDaemon checks whether the operation of the checkpoint on the node can be run by grit agent daemon.
+// volume claim of the checkpoint can't be mounted into grit agent daemon, so operations which transfer
+// checkpointed data from or to the volume claim are run by grit agent jobs which mount it. checkpointed data
+// in volume claim is still restored by grit agent daemon if it can be pulled from the peer, and grit agent job
+// which downloads from the volume claim is only used as a fallback. nodeName is empty for checkpoint operation.
+func (m *AgentManager) UseAgentDaemon(ckpt *v1alpha1.Checkpoint, nodeName string) bool {
+	if m.GetAgentMode() != AgentModeDaemon {
+		return false
+	}
+	return ckpt.Spec.VolumeClaim == nil || (len(nodeName) != 0 && (IsLocalRestore(ckpt, nodeName) || m.canPullFromPeer(ckpt)))
+}
+
+// canPullFromPeer checks whether checkpointed data can be pulled from grit agent daemon on the node where the
+// pod is checkpointed. criu images of streamed checkpoint are not kept on the source node, so it can't be
+// served by the peer.
+func (m *AgentManager) canPullFromPeer(ckpt *v1alpha1.Checkpoint) bool {
+	return m.PeerTransferEnabled() && len(ckpt.Status.NodeName) != 0 && !ckpt.Spec.Streaming
+}
+
+// OperationID returns the id of operation in grit agent daemon for checkpoint or restore.
+func OperationID(ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore) string {
+	if restore != nil {
+		return fmt.Sprintf("restore/%s/%s", restore.Namespace, restore.Name)
+	}
+	return fmt.Sprintf("checkpoint/%s/%s", ckpt.Namespace, ckpt.Name)
+}
+
+// PrestageOperationID returns the id of operation in grit agent daemon for pre-staging checkpointed data.
+func PrestageOperationID(ckpt *v1alpha1.Checkpoint) string {
+	return fmt.Sprintf("prestage/%s/%s", ckpt.Namespace, ckpt.Name)
+}
+
+// agentDataPaths returns the directory on the host and the directory in cloud storage for checkpointed data.
+// the directory in cloud storage is empty if checkpointed data is kept on the node.
+func (m *AgentManager) agentDataPaths(ckpt *v1alpha1.Checkpoint) (string, string, error) {
+	hostPath := m.GetHostPath()
+	if len(hostPath) == 0 {
+		return "", "", errors.New("There is no host-path in grit-agent-config")
+	}
+
+	if ckpt.Spec.VolumeClaim == nil {
+		return filepath.Join(hostPath, ckpt.Namespace, ckpt.Name), "", nil
+	}
+	return filepath.Join(hostPath, ckpt.Namespace, ckpt.Name), filepath.Join(PvcDirInContainer, ckpt.Namespace, ckpt.Name), nil
+}
+
+// IsLocalRestore checks whether checkpointed data is on the node where restoration pod is located, the data
+// is verified in place instead of downloading for local restore. criu images of streamed checkpoint are only
+// in cloud storage, so it's never restored in place.
+func IsLocalRestore(ckpt *v1alpha1.Checkpoint, nodeName string) bool {
+	if len(nodeName) == 0 || nodeName != ckpt.Status.NodeName {
+		return false
+	}
+	return ckpt.Spec.VolumeClaim == nil || !ckpt.Spec.Streaming
+}
+
+func (m *AgentManager) GenerateCheckpointRequest(ckpt *v1alpha1.Checkpoint) (*api.CheckpointRequest, error) {
+	hostPath, pvcDataPath, err := m.agentDataPaths(ckpt)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &api.CheckpointRequest{
+		ID:                 OperationID(ckpt, nil),
+		TargetPodNamespace: ckpt.Namespace,
+		TargetPodName:      ckpt.Spec.PodName,
+		TargetPodUID:       ckpt.Status.PodUID,
+		SrcDir:             hostPath,
+		DstDir:             pvcDataPath,
+		HostWorkPath:       hostPath,
+		CheckpointName:     ckpt.Name,
+		Stream:             ckpt.Spec.Streaming,
+		BandwidthLimit:     m.getBandwidthLimit(),
+	}
+	if ckpt.Spec.VolumeSnapshot != nil {
+		req.VolumeSnapshotClaims = ckpt.Spec.VolumeSnapshot.ClaimNames
+		req.VolumeSnapshotClassName = ckpt.Spec.VolumeSnapshot.VolumeSnapshotClassName
+	}
+	return req, nil
+}
+
+// GenerateRestoreRequest generates restore request for grit agent daemon on the node. checkpointed data is
+// verified in place for local restore, otherwise it's pulled from grit agent daemon on the node where the pod
+// is checkpointed. grit agent daemon never mounts cloud storage, so the caller falls back to grit agent job
+// for checkpointed data in volume claim if the request can't be generated or pulling from the peer failed.
+func (m *AgentManager) GenerateRestoreRequest(ctx context.Context, ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore, nodeName string) (*api.RestoreRequest, error) {
+	req, err := m.generateDownloadRequest(ctx, ckpt, OperationID(ckpt, restore), nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	// memory pages are served by criu page server on the source node, so lazy restore requires peer transfer.
+	// restore for local data or without peer transfer ignores lazy pages and waits for all data instead.
+	req.LazyPages = restore.Spec.LazyPages && len(req.PeerURL) != 0
+	if restore.Spec.LazyPages && !req.LazyPages {
+		log.FromContext(ctx).Info("lazy restore requires peer transfer, all checkpointed data is downloaded before restoring", "restore", restore.Name, "node", nodeName)
+	}
+	return req, nil
+}
+
+// GeneratePrestageRequest generates request for pre-staging checkpointed data to the candidate node.
+func (m *AgentManager) GeneratePrestageRequest(ctx context.Context, ckpt *v1alpha1.Checkpoint, nodeName string) (*api.RestoreRequest, error) {
+	return m.generateDownloadRequest(ctx, ckpt, PrestageOperationID(ckpt), nodeName)
+}
+
+func (m *AgentManager) generateDownloadRequest(ctx context.Context, ckpt *v1alpha1.Checkpoint, id, nodeName string) (*api.RestoreRequest, error) {
+	hostPath, _, err := m.agentDataPaths(ckpt)
+	if err != nil {
+		return nil, err
+	}
+
+	// source directory is always empty, because volume claim of checkpoint is never mounted into grit agent daemon.
+	req := &api.RestoreRequest{
+		ID:             id,
+		DstDir:         hostPath,
+		BandwidthLimit: m.getBandwidthLimit(),
+	}
+	if IsLocalRestore(ckpt, nodeName) {
+		return req, nil
+	}
+
+	if !m.canPullFromPeer(ckpt) {
+		return nil, fmt.Errorf("checkpointed data on node(%s) can't be pulled to node(%s) by peer transfer", ckpt.Status.NodeName, nodeName)
+	}
+	// source node maybe has been removed, the data can only be downloaded from cloud storage in this case.
+	nodeIP, err := m.nodeInternalIP(ctx, ckpt.Status.NodeName)
+	if err != nil {
+		return nil, fmt.Errorf("checkpointed data can't be pulled from node(%s), %w", ckpt.Status.NodeName, err)
+	}
+	req.PeerURL = peer.CheckpointURL(net.JoinHostPort(nodeIP, strconv.Itoa(m.getPeerPort())), ckpt.Status.NodeName, ckpt.Namespace, ckpt.Name)
+	return req, nil
+}
+
+// nodeInternalIP returns internal ip of the node, grit agent daemon runs with host network,
+// so internal ip of node is used for accessing it.
+func (m *AgentManager) nodeInternalIP(ctx context.Context, nodeName string) (string, error) {
+	var node corev1.Node
+	if err := m.kubeClient.Get(ctx, client.ObjectKey{Name: nodeName}, &node); err != nil {
+		return "", err
+	}
+
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP {
+			return addr.Address, nil
+		}
+	}
+	return "", fmt.Errorf("there is no internal ip for node(%s)", nodeName)
+}
+
+// withAgentClient connects to grit agent daemon on the specified node.
+func (m *AgentManager) withAgentClient(ctx context.Context, nodeName string, fn func(context.Context, *api.AgentClient) error) error {
+	nodeIP, err := m.nodeInternalIP(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+
+	// requests are authenticated by the signature with the api secret instead of transport credentials.
+	conn, err := grpc.NewClient(net.JoinHostPort(nodeIP, strconv.Itoa(m.getAgentPort())),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(api.UnaryClientInterceptor(m.apiSecret)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, agentRequestTimeout)
+	defer cancel()
+	return fn(ctx, api.NewAgentClient(conn))
+}
+
+func (m *AgentManager) SubmitCheckpoint(ctx context.Context, nodeName string, req *api.CheckpointRequest) (*api.OperationStatus, error) {
+	var opStatus *api.OperationStatus
+	err := m.withAgentClient(ctx, nodeName, func(ctx context.Context, c *api.AgentClient) error {
+		var err error
+		opStatus, err = c.Checkpoint(ctx, req)
+		return err
+	})
+	return opStatus, err
+}
+
+func (m *AgentManager) SubmitRestore(ctx context.Context, nodeName string, req *api.RestoreRequest) (*api.OperationStatus, error) {
+	var opStatus *api.OperationStatus
+	err := m.withAgentClient(ctx, nodeName, func(ctx context.Context, c *api.AgentClient) error {
+		var err error
+		opStatus, err = c.Restore(ctx, req)
+		return err
+	})
+	return opStatus, err
+}
+
+func (m *AgentManager) SubmitPrestage(ctx context.Context, nodeName string, req *api.RestoreRequest) (*api.OperationStatus, error) {
+	var opStatus *api.OperationStatus
+	err := m.withAgentClient(ctx, nodeName, func(ctx context.Context, c *api.AgentClient) error {
+		var err error
+		opStatus, err = c.Prestage(ctx, req)
+		return err
+	})
+	return opStatus, err
+}
+
+func (m *AgentManager) GetOperationStatus(ctx context.Context, nodeName, id string) (*api.OperationStatus, error) {
+	var opStatus *api.OperationStatus
+	err := m.withAgentClient(ctx, nodeName, func(ctx context.Context, c *api.AgentClient) error {
+		var err error
+		opStatus, err = c.Status(ctx, &api.StatusRequest{ID: id})
+		return err
+	})
+	return opStatus, err
+}
+
+func (m *AgentManager) CleanupOperation(ctx context.Context, nodeName, id string) error {
+	return m.withAgentClient(ctx, nodeName, func(ctx context.Context, c *api.AgentClient) error {
+		_, err := c.Cleanup(ctx, &api.CleanupRequest{ID: id})
+		return err
+	})
+}
+
+// RemovePrestagedData removes pre-staged checkpointed data on the node by grit agent daemon.
+func (m *AgentManager) RemovePrestagedData(ctx context.Context, nodeName string, ckpt *v1alpha1.Checkpoint) error {
+	hostPath, _, err := m.agentDataPaths(ckpt)
+	if err != nil {
+		return err
+	}
+
+	return m.withAgentClient(ctx, nodeName, func(ctx context.Context, c *api.AgentClient) error {
+		_, err := c.Cleanup(ctx, &api.CleanupRequest{ID: PrestageOperationID(ckpt), RemoveData: true, HostDir: hostPath})
+		return err
+	})
+}
+
 func (m *AgentManager) GenerateGritAgentJob(ctx context.Context, ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore) (*batchv1.Job, error) {
+	if restore != nil {
+		return m.generateGritAgentJob(ctx, ckpt, options.ActionRestore, util.GritAgentJobName(nil, restore), restore.Status.NodeName)
+	}
+	return m.generateGritAgentJob(ctx, ckpt, options.ActionCheckpoint, util.GritAgentJobName(ckpt, nil), ckpt.Status.NodeName)
+}
+
+// GeneratePrestageGritAgentJob generates grit agent job for pre-staging checkpointed data to the candidate node,
+// or removing pre-staged data from the node if action is cleanup. the job is labeled with checkpoint name
+// because there is a job for each node.
+func (m *AgentManager) GeneratePrestageGritAgentJob(ctx context.Context, ckpt *v1alpha1.Checkpoint, action, nodeName string) (*batchv1.Job, error) {
+	gritAgentJob, err := m.generateGritAgentJob(ctx, ckpt, action, util.PrestageGritAgentJobName(ckpt, action, nodeName), nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if gritAgentJob.Labels == nil {
+		gritAgentJob.Labels = make(map[string]string)
+	}
+	gritAgentJob.Labels[v1alpha1.CheckpointNameLabel] = ckpt.Name
+	return gritAgentJob, nil
+}
+
+// GenerateFanOutGritAgentJob generates grit agent job for downloading checkpointed data on the node for
+// restore in FanOut mode, the job is labeled with restore name because there is a job for each node.
+func (m *AgentManager) GenerateFanOutGritAgentJob(ctx context.Context, ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore, nodeName string) (*batchv1.Job, error) {
+	gritAgentJob, err := m.generateGritAgentJob(ctx, ckpt, options.ActionRestore, util.FanOutGritAgentJobName(restore, nodeName), nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if gritAgentJob.Labels == nil {
+		gritAgentJob.Labels = make(map[string]string)
+	}
+	gritAgentJob.Labels[v1alpha1.RestoreNameLabel] = restore.Name
+	return gritAgentJob, nil
+}
+
+func (m *AgentManager) generateGritAgentJob(ctx context.Context, ckpt *v1alpha1.Checkpoint, action, jobName, nodeName string) (*batchv1.Job, error) {
 	cm, err := m.lister.ConfigMaps(m.namespace).Get(GritAgentConfigMapName)
 	if err != nil {
 		return nil, err
@@ -65,13 +424,8 @@ func (m *AgentManager) GenerateGritAgentJob(ctx context.Context, ckpt *v1alpha1.
 	girtAgentJobTemplate := cm.Data[GritAgentYamlKey]
 	templateCtx := map[string]string{
 		"namespace": ckpt.Namespace,
-		"jobName":   util.GritAgentJobName(ckpt, nil),
-		"nodeName":  ckpt.Status.NodeName,
-	}
-
-	if restore != nil {
-		templateCtx["jobName"] = util.GritAgentJobName(nil, restore)
-		templateCtx["nodeName"] = restore.Status.NodeName
+		"jobName":   jobName,
+		"nodeName":  nodeName,
 	}
 
 	gritAgentJob, err := convertToGritAgentJob(girtAgentJobTemplate, templateCtx)
@@ -82,44 +436,58 @@ func (m *AgentManager) GenerateGritAgentJob(ctx context.Context, ckpt *v1alpha1.
 	}
 	log.FromContext(ctx).Info("grit manager job template", "object", *gritAgentJob)
 
-	// preare volumes and volume mount for job
-	pvcStorage := corev1.Volume{
-		Name: "pvc-data",
-		VolumeSource: corev1.VolumeSource{
-			PersistentVolumeClaim: ckpt.Spec.VolumeClaim,
-		},
+	hostPath, pvcDataPath, err := m.agentDataPaths(ckpt)
+	if err != nil {
+		return nil, err
+	}
+	isDownload := action == options.ActionRestore || action == options.ActionPrestage
+	// grit agent job doesn't pull checkpointed data from the peer, so checkpoint without cloud storage
+	// can only be restored by grit agent job on the node where the pod is checkpointed.
+	if isDownload && ckpt.Spec.VolumeClaim == nil && !IsLocalRestore(ckpt, nodeName) {
+		return nil, fmt.Errorf("checkpointed data is kept on node(%s), it can only be pulled to node(%s) by grit agent daemon", ckpt.Status.NodeName, nodeName)
+	}
+	// cloud storage is not used when checkpointed data is kept on the node, or restoration pod is on the node
+	// where checkpointed data is located.
+	if (isDownload && IsLocalRestore(ckpt, nodeName)) || action == options.ActionCleanup {
+		pvcDataPath = ""
 	}
 
-	hostPath := filepath.Join(strings.TrimSpace(cm.Data[HostPathKey]), ckpt.Namespace, ckpt.Name)
+	// preare volumes and volume mount for job. the root of host path is mounted for cleanup,
+	// because the mount point itself can't be removed.
+	hostMountPath := hostPath
+	if action == options.ActionCleanup {
+		hostMountPath = m.GetHostPath()
+	}
 	hostStorage := corev1.Volume{
 		Name: "host-data",
 		VolumeSource: corev1.VolumeSource{
 			HostPath: &corev1.HostPathVolumeSource{
-				Path: hostPath,
+				Path: hostMountPath,
 				Type: lo.ToPtr(corev1.HostPathDirectoryOrCreate),
 			},
 		},
 	}
-	gritAgentJob.Spec.Template.Spec.Volumes = append(gritAgentJob.Spec.Template.Spec.Volumes, pvcStorage, hostStorage)
+	gritAgentJob.Spec.Template.Spec.Volumes = append(gritAgentJob.Spec.Template.Spec.Volumes, hostStorage)
+	c := &gritAgentJob.Spec.Template.Spec.Containers[0]
+	c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+		Name:      "host-data",
+		MountPath: hostMountPath,
+	})
 
-	pvcDataPath := filepath.Join(PvcDirInContainer, ckpt.Namespace, ckpt.Name)
-	volumeMounts := []corev1.VolumeMount{
-		{
-			Name:      "host-data",
-			MountPath: hostPath,
-		},
-		{
+	if len(pvcDataPath) != 0 {
+		pvcStorage := corev1.Volume{
+			Name: "pvc-data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: ckpt.Spec.VolumeClaim,
+			},
+		}
+		gritAgentJob.Spec.Template.Spec.Volumes = append(gritAgentJob.Spec.Template.Spec.Volumes, pvcStorage)
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
 			Name:      "pvc-data",
 			MountPath: PvcDirInContainer,
-		},
+		})
 	}
-	c := &gritAgentJob.Spec.Template.Spec.Containers[0]
-	c.VolumeMounts = append(c.VolumeMounts, volumeMounts...)
 
-	action := "checkpoint"
-	if restore != nil {
-		action = "restore"
-	}
 	// prepare command args, like src-dir, dst-dir,etc.
 	args := map[string]string{
 		"action":         action,
@@ -128,12 +496,35 @@ func (m *AgentManager) GenerateGritAgentJob(ctx context.Context, ckpt *v1alpha1.
 		"host-work-path": hostPath,
 	}
 
-	if restore != nil {
+	if isDownload {
 		args["src-dir"] = pvcDataPath
 		args["dst-dir"] = hostPath
+	} else if action == options.ActionCleanup {
+		args["src-dir"] = ""
+		args["dst-dir"] = hostPath
+		args["host-path"] = hostMountPath
+	} else if ckpt.Spec.VolumeSnapshot != nil && len(ckpt.Spec.VolumeSnapshot.ClaimNames) != 0 {
+		// grit agent takes volume snapshots while the pod is frozen, so it runs with
+		// a service account which is allowed to create VolumeSnapshots.
+		args["checkpoint-name"] = ckpt.Name
+		args["volume-snapshot-claims"] = strings.Join(ckpt.Spec.VolumeSnapshot.ClaimNames, ",")
+		if len(ckpt.Spec.VolumeSnapshot.VolumeSnapshotClassName) != 0 {
+			args["volume-snapshot-class"] = ckpt.Spec.VolumeSnapshot.VolumeSnapshotClassName
+		}
+		gritAgentJob.Spec.Template.Spec.ServiceAccountName = GritAgentServiceAccountName
+	}
+	if action == options.ActionCheckpoint && ckpt.Spec.Streaming {
+		args["stream"] = "true"
+	}
+	if bandwidthLimit := m.getBandwidthLimit(); bandwidthLimit > 0 && action != options.ActionCleanup {
+		args["bandwidth-limit"] = strconv.FormatInt(bandwidthLimit, 10)
 	}
 
 	for k, v := range args {
+		// src-dir or dst-dir in cloud storage is not specified when checkpointed data is on the node.
+		if len(v) == 0 {
+			continue
+		}
 		c.Args = append(c.Args, fmt.Sprintf("--%s=%s", k, v))
 	}
 
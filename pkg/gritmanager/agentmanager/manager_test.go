@@ -0,0 +1,117 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package agentmanager
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+)
+
+func TestIsLocalRestore(t *testing.T) {
+	newCheckpoint := func(withClaim, streaming bool) *v1alpha1.Checkpoint {
+		ckpt := &v1alpha1.Checkpoint{}
+		ckpt.Spec.Streaming = streaming
+		ckpt.Status.NodeName = "node-1"
+		if withClaim {
+			ckpt.Spec.VolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "ckpt-data"}
+		}
+		return ckpt
+	}
+
+	t.Run("checkpoint without cloud storage", func(t *testing.T) {
+		ckpt := newCheckpoint(false, false)
+		if !IsLocalRestore(ckpt, "node-1") {
+			t.Fatalf("expected restore on the checkpointed node is local")
+		}
+		// checkpointed data is pulled from the peer for restore on other nodes.
+		if IsLocalRestore(ckpt, "node-2") {
+			t.Fatalf("expected restore on another node is not local")
+		}
+	})
+
+	t.Run("checkpoint with cloud storage", func(t *testing.T) {
+		if !IsLocalRestore(newCheckpoint(true, false), "node-1") {
+			t.Fatalf("expected restore on the checkpointed node is local")
+		}
+		if IsLocalRestore(newCheckpoint(true, true), "node-1") {
+			t.Fatalf("expected streamed checkpoint is never restored in place")
+		}
+		if IsLocalRestore(newCheckpoint(true, false), "") {
+			t.Fatalf("expected restore without node is not local")
+		}
+	})
+}
+
+func TestGenerateRestoreRequest(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "grit-system", Name: GritAgentConfigMapName},
+		Data:       map[string]string{HostPathKey: "/mnt/grit-agent", AgentModeKey: AgentModeDaemon, PeerPortKey: "10361"},
+	})
+	kubeClient := fake.NewClientBuilder().WithObjects(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}}},
+	}).Build()
+	m := NewAgentManager("grit-system", corev1listers.NewConfigMapLister(indexer), kubeClient, []byte("secret"))
+
+	ckpt := &v1alpha1.Checkpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ckpt"}}
+	ckpt.Spec.VolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "ckpt-data"}
+	ckpt.Status.NodeName = "node-1"
+	restore := &v1alpha1.Restore{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "restore"}}
+	restore.Spec.LazyPages = true
+
+	t.Run("cross node restore of checkpoint with cloud storage pulls from the peer", func(t *testing.T) {
+		if !m.UseAgentDaemon(ckpt, "node-2") {
+			t.Fatalf("expected cross node restore is run by grit agent daemon")
+		}
+		req, err := m.GenerateRestoreRequest(context.Background(), ckpt, restore, "node-2")
+		if err != nil {
+			t.Fatalf("failed to generate restore request, %v", err)
+		}
+		if expected := "https://10.0.0.1:10361/nodes/node-1/checkpoints/default/ckpt"; req.PeerURL != expected {
+			t.Fatalf("expected peer url %s, got %s", expected, req.PeerURL)
+		}
+		// volume claim is never mounted into grit agent daemon.
+		if len(req.SrcDir) != 0 || !req.LazyPages {
+			t.Fatalf("expected lazy restore from the peer only, got src dir %q and lazy pages %v", req.SrcDir, req.LazyPages)
+		}
+	})
+
+	t.Run("local restore doesn't pull from the peer", func(t *testing.T) {
+		req, err := m.GenerateRestoreRequest(context.Background(), ckpt, restore, "node-1")
+		if err != nil {
+			t.Fatalf("failed to generate restore request, %v", err)
+		}
+		if len(req.SrcDir) != 0 || len(req.PeerURL) != 0 || req.LazyPages {
+			t.Fatalf("expected checkpointed data is verified in place, got %+v", req)
+		}
+	})
+
+	t.Run("streamed checkpoint is restored by grit agent job", func(t *testing.T) {
+		streamed := ckpt.DeepCopy()
+		streamed.Spec.Streaming = true
+		if m.UseAgentDaemon(streamed, "node-2") {
+			t.Fatalf("expected streamed checkpoint is not restored by grit agent daemon")
+		}
+		if _, err := m.GenerateRestoreRequest(context.Background(), streamed, restore, "node-2"); err == nil {
+			t.Fatalf("expected request for streamed checkpoint can't be generated")
+		}
+	})
+
+	t.Run("source node is removed", func(t *testing.T) {
+		removed := ckpt.DeepCopy()
+		removed.Status.NodeName = "node-removed"
+		if _, err := m.GenerateRestoreRequest(context.Background(), removed, restore, "node-2"); err == nil {
+			t.Fatalf("expected request can't be generated when source node is removed")
+		}
+	})
+}
@@ -5,28 +5,41 @@ package checkpoint
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 
 	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/utils/clock"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
 	"github.com/kaito-project/grit/pkg/gritmanager/agentmanager"
 	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
+	"github.com/kaito-project/grit/pkg/gritmanager/metrics"
+	"github.com/kaito-project/grit/pkg/metadata"
+	"github.com/kaito-project/grit/pkg/util/volumesnapshot"
 )
 
 var (
@@ -45,25 +58,27 @@ type CheckpointStateHandler func(ctx context.Context, ckpt *v1alpha1.Checkpoint)
 type Controller struct {
 	client.Client
 	clock         clock.Clock
+	recorder      record.EventRecorder
 	agentManager  *agentmanager.AgentManager
 	statesMachine map[v1alpha1.CheckpointPhase]CheckpointStateHandler
 }
 
-func NewController(clk clock.Clock, kubeClient client.Client, agentManager *agentmanager.AgentManager) *Controller {
+func NewController(clk clock.Clock, kubeClient client.Client, recorder record.EventRecorder, agentManager *agentmanager.AgentManager) *Controller {
 	c := &Controller{
 		clock:        clk,
 		Client:       kubeClient,
+		recorder:     recorder,
 		agentManager: agentManager,
 	}
 
-	// v1alpha1.CheckpointFailed, v1alpha1.AutoMigrationSubmitted,
-	// these two states, girt-manager don't need to do anything.
+	// v1alpha1.CheckpointFailed state, girt-manager don't need to do anything.
 	c.statesMachine = map[v1alpha1.CheckpointPhase]CheckpointStateHandler{
 		v1alpha1.CheckpointCreated:       c.createdHandler,
 		v1alpha1.CheckpointPending:       c.pendingHandler,
 		v1alpha1.Checkpointing:           c.checkpointingHandler,
 		v1alpha1.Checkpointed:            c.checkpointedHandler,
 		v1alpha1.AutoMigrationSubmitting: c.submittingHandler,
+		v1alpha1.AutoMigrationSubmitted:  c.submittedHandler,
 	}
 
 	return c
@@ -80,8 +95,13 @@ func (c *Controller) Reconcile(ctx context.Context, ckpt *v1alpha1.Checkpoint) (
 		return reconcile.Result{}, nil
 	}
 
+	var result reconcile.Result
 	if err := stateHandler(ctx, updatedCkpt); err != nil {
-		return reconcile.Result{}, err
+		var requeueErr *util.RequeueError
+		if !errors.As(err, &requeueErr) {
+			return reconcile.Result{}, err
+		}
+		result.RequeueAfter = requeueErr.After
 	}
 
 	// if phase is not CheckpointFailed, we need to remove failed condition
@@ -90,9 +110,13 @@ func (c *Controller) Reconcile(ctx context.Context, ckpt *v1alpha1.Checkpoint) (
 	}
 
 	if !reflect.DeepEqual(ckpt, updatedCkpt) {
-		return reconcile.Result{}, c.Status().Update(ctx, updatedCkpt)
+		if err := c.Status().Update(ctx, updatedCkpt); err != nil {
+			return result, err
+		}
+		metrics.ObservePhaseTransition(metrics.KindCheckpoint, string(ckpt.Status.Phase), string(updatedCkpt.Status.Phase), ckpt.Status.Conditions, updatedCkpt.Status.Conditions, c.clock.Now())
+		c.recordPhaseEvents(ckpt, updatedCkpt)
 	}
-	return reconcile.Result{}, nil
+	return result, nil
 }
 
 // createdHandler is used for initializing pod spec hash for checkpoint resource, then upgraded state to CheckpointPending.
@@ -114,15 +138,23 @@ func (c *Controller) createdHandler(ctx context.Context, ckpt *v1alpha1.Checkpoi
 	log.FromContext(ctx).Info("pod metadata", "metadata", pod.ObjectMeta, "checkpoint", ckpt.Name)
 
 	ckpt.Status.NodeName = pod.Spec.NodeName
-	ckpt.Status.PodSpecHash = util.ComputeHash(&pod.Spec)
+	// the pod maybe is a restoration pod whose images are pinned by digest, the spec before pinning is
+	// recorded, so the pod can be restored again by pods created from the same template.
+	spec := util.UnpinnedPodSpec(&pod)
+	ckpt.Status.PodSpecHash = util.ComputeHash(spec)
+	ckpt.Status.PodSpecFieldDigests = util.PodSpecFieldDigests(spec)
+	ckpt.Status.PodSpecDigest = util.PodSpecDigest(ckpt.Status.PodSpecFieldDigests)
 	ckpt.Status.PodUID = string(pod.UID)
+	ckpt.Status.ContainerImages = util.ContainerImages(&pod)
+	ckpt.Status.Priority = util.ResolvePriority(ckpt.Spec.Priority, &pod)
 	ckpt.Status.Phase = v1alpha1.CheckpointPending
 	util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointPending), "InitializingCompleted", "pod spec hash has been configured")
 	return nil
 }
 
 // pendingHandler is used for distributing grit agent pod to specified node which has the pod for checkpointing.
-// checkpoint state will be upgraded to Checkpointing after grit agent pod created.
+// checkpoint state will be upgraded to Checkpointing after grit agent pod created, and it's Queued until
+// the operation is admitted by limits of concurrent operations.
 func (c *Controller) pendingHandler(ctx context.Context, ckpt *v1alpha1.Checkpoint) error {
 	// grit agent job is running, upgrade state to checkpointing when pod is ready
 	var job batchv1.Job
@@ -134,6 +166,27 @@ func (c *Controller) pendingHandler(ctx context.Context, ckpt *v1alpha1.Checkpoi
 		return err
 	}
 
+	// checkpoint is queued when limits of concurrent operations are reached, and admission is retried later.
+	if result, err := c.agentManager.AdmitCheckpoint(ctx, ckpt); err != nil {
+		return err
+	} else if !result.Admitted {
+		ckpt.Status.Phase = v1alpha1.CheckpointQueued
+		ckpt.Status.QueuePosition = result.QueuePosition
+		util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointQueued), "AdmissionLimitReached", fmt.Sprintf("checkpoint is queued at position %d, %s", result.QueuePosition, result.Reason))
+		return util.RequeueAfter(10*time.Second, "checkpoint is queued")
+	}
+	ckpt.Status.QueuePosition = 0
+	util.RemoveCondition(&ckpt.Status.Conditions, string(v1alpha1.CheckpointQueued))
+
+	// grit agent runs as daemon, submit checkpoint operation to grit agent on the node. checkpoint with volume
+	// claim is run by grit agent job which mounts the claim, and grit agent job will be used as a fallback if
+	// grit agent daemon is unavailable.
+	if c.agentManager.UseAgentDaemon(ckpt, "") {
+		if submitted, err := c.submitToAgentDaemon(ctx, ckpt); err != nil || submitted {
+			return err
+		}
+	}
+
 	gritAgentJob, err := c.agentManager.GenerateGritAgentJob(ctx, ckpt, nil)
 	if err != nil {
 		ckpt.Status.Phase = v1alpha1.CheckpointFailed
@@ -142,80 +195,221 @@ func (c *Controller) pendingHandler(ctx context.Context, ckpt *v1alpha1.Checkpoi
 	}
 	log.FromContext(ctx).Info("grit manager job", "object", *gritAgentJob)
 
+	if len(gritAgentJob.Spec.Template.Spec.ServiceAccountName) != 0 {
+		if err := c.ensureGritAgentServiceAccount(ctx, ckpt.Namespace); err != nil {
+			return err
+		}
+	}
+
 	// start to distribute grit agent job
 	return c.Create(ctx, gritAgentJob)
 }
 
+// ensureGritAgentServiceAccount prepares service account for grit agent job which takes volume snapshots.
+// grit agent job runs in the namespace of Checkpoint, so the service account is created in this namespace
+// and bound to the cluster role of grit agent.
+func (c *Controller) ensureGritAgentServiceAccount(ctx context.Context, namespace string) error {
+	sa := corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      agentmanager.GritAgentServiceAccountName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				v1alpha1.GritAgentLabel: v1alpha1.GritAgentName,
+			},
+		},
+	}
+	if err := c.Create(ctx, &sa); client.IgnoreAlreadyExists(err) != nil {
+		return err
+	}
+
+	roleBinding := rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      agentmanager.GritAgentServiceAccountName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				v1alpha1.GritAgentLabel: v1alpha1.GritAgentName,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     agentmanager.GritAgentClusterRoleName,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      agentmanager.GritAgentServiceAccountName,
+				Namespace: namespace,
+			},
+		},
+	}
+	return client.IgnoreAlreadyExists(c.Create(ctx, &roleBinding))
+}
+
+func (c *Controller) submitToAgentDaemon(ctx context.Context, ckpt *v1alpha1.Checkpoint) (bool, error) {
+	req, err := c.agentManager.GenerateCheckpointRequest(ckpt)
+	if err != nil {
+		ckpt.Status.Phase = v1alpha1.CheckpointFailed
+		util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointFailed), "GenerateGritAgentFailed", fmt.Sprintf("failed to generate grit agent request, %v", err))
+		return true, nil
+	}
+
+	if _, err := c.agentManager.SubmitCheckpoint(ctx, ckpt.Status.NodeName, req); err != nil {
+		log.FromContext(ctx).Error(err, "grit agent daemon is unavailable, fall back to grit agent job", "checkpoint", ckpt.Name, "node", ckpt.Status.NodeName)
+		return false, nil
+	}
+
+	ckpt.Status.Phase = v1alpha1.Checkpointing
+	util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Checkpointing), util.GritAgentDaemonAcceptedReason, fmt.Sprintf("checkpoint operation is accepted by grit agent daemon on node(%s)", ckpt.Status.NodeName))
+	return true, nil
+}
+
 func (c *Controller) checkpointingHandler(ctx context.Context, ckpt *v1alpha1.Checkpoint) error {
+	if util.IsAcceptedByAgentDaemon(ckpt.Status.Conditions, string(v1alpha1.Checkpointing)) {
+		return c.checkpointingByAgentDaemon(ctx, ckpt)
+	}
+
 	var gritAgentJob batchv1.Job
 	var isCompleted, isFailed bool
 	var err error
 	if err = c.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: util.GritAgentJobName(ckpt, nil)}, &gritAgentJob); client.IgnoreNotFound(err) != nil {
 		return err
 	} else if err == nil {
-		isCompleted, isFailed = jobCompletedOrFailed(&gritAgentJob)
-		if isCompleted {
-			var pvc corev1.PersistentVolumeClaim
-			if err = c.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: ckpt.Spec.VolumeClaim.ClaimName}, &pvc); err != nil {
-				return err
+		isCompleted, isFailed = util.JobCompletedOrFailed(&gritAgentJob)
+		// estimated size is also reported when checkpoint is refused for insufficient disk space.
+		if isCompleted || isFailed {
+			if size := util.GritAgentEstimatedSize(ctx, c.Client, &gritAgentJob); size > 0 {
+				ckpt.Status.EstimatedSize = resource.NewQuantity(size, resource.BinarySI)
 			}
-
-			ckpt.Status.DataPath = fmt.Sprintf("%s://%s/%s", pvc.Spec.VolumeName, ckpt.Namespace, ckpt.Name)
-			ckpt.Status.Phase = v1alpha1.Checkpointed
-			util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Checkpointed), "GritAgentJobCompleted", fmt.Sprintf("grit agent job(%s/%s) is completed", gritAgentJob.Namespace, gritAgentJob.Name))
-			return nil
+		}
+		if isCompleted {
+			return c.markCheckpointed(ctx, ckpt, "GritAgentJobCompleted", fmt.Sprintf("grit agent job(%s/%s) is completed", gritAgentJob.Namespace, gritAgentJob.Name))
 		}
 	}
 
 	// girt job is not found or failed
 	if err != nil || isFailed {
+		reason := "GritAgentJobFailed"
+		message := fmt.Sprintf("failed to execute grit agent job(%s/%s) in checkpointing state", gritAgentJob.Namespace, gritAgentJob.Name)
+		if isFailed {
+			if terminationMessage := util.GritAgentTerminationMessage(ctx, c.Client, &gritAgentJob); len(terminationMessage) != 0 {
+				reason = checkpointFailedReason(terminationMessage, reason)
+				message = fmt.Sprintf("%s, %s", message, terminationMessage)
+			}
+		}
 		ckpt.Status.Phase = v1alpha1.CheckpointFailed
-		util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointFailed), "GritAgentJobFailed", fmt.Sprintf("failed to execute grit agent job(%s/%s) in checkpointing state", gritAgentJob.Namespace, gritAgentJob.Name))
+		util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointFailed), reason, message)
 	}
 	return nil
 }
 
-func jobCompletedOrFailed(job *batchv1.Job) (bool, bool) {
-	if job == nil {
-		return false, false
+// checkpointingByAgentDaemon is used for checking status of checkpoint operation in grit agent daemon.
+func (c *Controller) checkpointingByAgentDaemon(ctx context.Context, ckpt *v1alpha1.Checkpoint) error {
+	opStatus, err := c.agentManager.GetOperationStatus(ctx, ckpt.Status.NodeName, agentmanager.OperationID(ckpt, nil))
+	if status.Code(err) == codes.NotFound {
+		ckpt.Status.Phase = v1alpha1.CheckpointFailed
+		util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointFailed), "GritAgentOperationLost", fmt.Sprintf("checkpoint operation is not found in grit agent daemon on node(%s)", ckpt.Status.NodeName))
+		return nil
+	} else if err != nil {
+		return err
 	}
 
-	if job.Status.Succeeded > 0 {
-		return true, false
+	if opStatus.EstimatedSize > 0 {
+		ckpt.Status.EstimatedSize = resource.NewQuantity(opStatus.EstimatedSize, resource.BinarySI)
 	}
 
-	if job.Status.Failed > 0 {
-		return false, true
+	switch opStatus.Phase {
+	case api.OperationSucceeded:
+		return c.markCheckpointed(ctx, ckpt, "GritAgentOperationCompleted", fmt.Sprintf("checkpoint operation in grit agent daemon on node(%s) is completed", ckpt.Status.NodeName))
+	case api.OperationFailed:
+		ckpt.Status.Phase = v1alpha1.CheckpointFailed
+		util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointFailed), checkpointFailedReason(opStatus.Message, "GritAgentOperationFailed"), fmt.Sprintf("checkpoint operation in grit agent daemon on node(%s) failed, %s", ckpt.Status.NodeName, opStatus.Message))
+		return nil
+	default:
+		return util.RequeueAfter(5*time.Second, "checkpoint operation is running in grit agent daemon")
 	}
+}
+
+// checkpointFailedReason returns InsufficientDiskSpace if grit agent refused to checkpoint the pod because there
+// is not enough free space for checkpointed data, otherwise the default reason is returned.
+func checkpointFailedReason(message, defaultReason string) string {
+	if strings.HasPrefix(message, metadata.ErrInsufficientDiskSpace.Error()) {
+		return "InsufficientDiskSpace"
+	}
+	return defaultReason
+}
 
-	for _, cond := range job.Status.Conditions {
-		if cond.Type == batchv1.JobComplete && cond.Status == "True" {
-			return true, false
+func (c *Controller) markCheckpointed(ctx context.Context, ckpt *v1alpha1.Checkpoint, reason, message string) error {
+	// checkpointed data is kept on the node if cloud storage is not specified.
+	dataPath := fmt.Sprintf("node://%s/%s/%s", ckpt.Status.NodeName, ckpt.Namespace, ckpt.Name)
+	if ckpt.Spec.VolumeClaim != nil {
+		var pvc corev1.PersistentVolumeClaim
+		if err := c.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: ckpt.Spec.VolumeClaim.ClaimName}, &pvc); err != nil {
+			return err
 		}
+		dataPath = fmt.Sprintf("%s://%s/%s", pvc.Spec.VolumeName, ckpt.Namespace, ckpt.Name)
+	}
 
-		if cond.Type == batchv1.JobFailed && cond.Status == "True" {
-			return false, true
+	// volume snapshots are taken by grit agent while the pod is frozen, record them for restoring.
+	if ckpt.Spec.VolumeSnapshot != nil {
+		refs := make([]v1alpha1.VolumeSnapshotReference, 0, len(ckpt.Spec.VolumeSnapshot.ClaimNames))
+		for _, claimName := range ckpt.Spec.VolumeSnapshot.ClaimNames {
+			snapshot := volumesnapshot.New()
+			if err := c.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: volumesnapshot.Name(ckpt.Name, claimName)}, snapshot); err != nil {
+				if apierrors.IsNotFound(err) {
+					ckpt.Status.Phase = v1alpha1.CheckpointFailed
+					util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointFailed), "VolumeSnapshotNotExist", fmt.Sprintf("volume snapshot for pvc(%s) doesn't exist", claimName))
+					return nil
+				}
+				return err
+			}
+			if message := volumesnapshot.Error(snapshot); len(message) != 0 {
+				ckpt.Status.Phase = v1alpha1.CheckpointFailed
+				util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.CheckpointFailed), "VolumeSnapshotFailed", fmt.Sprintf("failed to take volume snapshot for pvc(%s), %s", claimName, message))
+				return nil
+			}
+			refs = append(refs, v1alpha1.VolumeSnapshotReference{ClaimName: claimName, VolumeSnapshotName: snapshot.GetName()})
 		}
+		ckpt.Status.VolumeSnapshots = refs
 	}
-	return false, false
+
+	ckpt.Status.DataPath = dataPath
+	ckpt.Status.Phase = v1alpha1.Checkpointed
+	util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Checkpointed), reason, message)
+	return nil
 }
 
 // checkpointedHandler is used for garbage collecting grit agent pod. then pvc for cloud storage can be used for restoring.
-// if checkpoint.Spec.AutoMigration is true, upgrade phase to checkpoint Submitting.
+// if checkpoint.Spec.Prestage is specified, checkpointed data is pre-staged to candidate nodes.
+// if checkpoint.Spec.AutoMigration is true, upgrade phase to checkpoint Submitting after pre-staging is completed.
 func (c *Controller) checkpointedHandler(ctx context.Context, ckpt *v1alpha1.Checkpoint) error {
 	var gritAgentJob batchv1.Job
 	if err := c.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: util.GritAgentJobName(ckpt, nil)}, &gritAgentJob); client.IgnoreNotFound(err) != nil {
 		return err
 	} else if err == nil { // grit agent exist
 		if gritAgentJob.DeletionTimestamp.IsZero() { // skip deleting grit agent job
-			deletePolicy := metav1.DeletePropagationForeground
-			return c.Delete(ctx, &gritAgentJob, &client.DeleteOptions{PropagationPolicy: &deletePolicy})
+			return util.DeleteGritAgentJob(ctx, c.Client, &gritAgentJob)
 		}
 	} else { // grit agent job is deleted
+		if util.IsAcceptedByAgentDaemon(ckpt.Status.Conditions, string(v1alpha1.Checkpointing)) {
+			// checkpointed data on the host is kept for restoring, only operation record is removed.
+			if err := c.agentManager.CleanupOperation(ctx, ckpt.Status.NodeName, agentmanager.OperationID(ckpt, nil)); err != nil {
+				log.FromContext(ctx).Info("failed to cleanup operation in grit agent daemon", "checkpoint", ckpt.Name, "error", err)
+			}
+		}
+
+		if ckpt.Spec.Prestage != nil {
+			if completed, err := c.prestage(ctx, ckpt); err != nil || !completed {
+				return err
+			}
+		}
+
 		if ckpt.Spec.AutoMigration {
 			ckpt.Status.Phase = v1alpha1.AutoMigrationSubmitting
 			util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.AutoMigrationSubmitting), "CheckpointedCompleted", "auto migration is true and start to submit migration")
+			return nil
 		}
+		return c.cleanupPrestagedData(ctx, ckpt)
 	}
 
 	return nil
@@ -261,6 +455,7 @@ func (c *Controller) submittingHandler(ctx context.Context, ckpt *v1alpha1.Check
 		Spec: v1alpha1.RestoreSpec{
 			CheckpointName: ckpt.Name,
 			OwnerRef:       *ownerRef,
+			Priority:       ckpt.Spec.Priority,
 		},
 	}
 
@@ -281,17 +476,35 @@ func (c *Controller) submittingHandler(ctx context.Context, ckpt *v1alpha1.Check
 	return nil
 }
 
+// submittedHandler is used for removing pre-staged data which is not used by restoration pod after migration.
+func (c *Controller) submittedHandler(ctx context.Context, ckpt *v1alpha1.Checkpoint) error {
+	return c.cleanupPrestagedData(ctx, ckpt)
+}
+
 // +kubebuilder:rbac:groups=kaito.sh,resources=checkpoints,verbs=list;watch;get
 // +kubebuilder:rbac:groups=kaito.sh,resources=checkpoints/status,verbs=update
-// +kubebuilder:rbac:groups=kaito.sh,resources=restores,verbs=create
+// +kubebuilder:rbac:groups=kaito.sh,resources=restores,verbs=create;list;watch
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=list;watch;get;create;delete
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;delete
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=list;watch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=create
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=create
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=bind,resourceNames=grit-agent-clusterrole
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get
 
 func (c *Controller) Register(_ context.Context, m manager.Manager) error {
 	return controllerruntime.NewControllerManagedBy(m).
 		Named("checkpoint.lifecycle").
 		For(&v1alpha1.Checkpoint{}).
 		Watches(&batchv1.Job{}, util.GritAgentJobHandler, builder.WithPredicates(util.GritAgentJobPredicate)).
+		// pre-staged data is removed after restore of the checkpoint is completed.
+		Watches(&v1alpha1.Restore{}, handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+			restore, ok := obj.(*v1alpha1.Restore)
+			if !ok {
+				return nil
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: restore.Namespace, Name: restore.Spec.CheckpointName}}}
+		})).
 		WithOptions(controller.Options{
 			RateLimiter: workqueue.NewTypedMaxOfRateLimiter(
 				workqueue.NewTypedItemExponentialFailureRateLimiter[reconcile.Request](time.Second, 300*time.Second),
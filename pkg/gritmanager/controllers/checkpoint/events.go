@@ -0,0 +1,48 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package checkpoint
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
+)
+
+// recordPhaseEvents emits events on the checkpoint and the checkpointed pod after phase of checkpoint is changed,
+// so owners of the pod can find out that the pod is checkpointed or migrated by `kubectl describe pod`.
+func (c *Controller) recordPhaseEvents(old, ckpt *v1alpha1.Checkpoint) {
+	if old.Status.Phase == ckpt.Status.Phase {
+		return
+	}
+
+	eventType, message := util.PhaseEvent(ckpt.Status.Conditions, string(ckpt.Status.Phase), "checkpoint")
+	c.recorder.Event(ckpt, eventType, string(ckpt.Status.Phase), message)
+
+	var reason string
+	switch ckpt.Status.Phase {
+	case v1alpha1.CheckpointQueued:
+		reason, message = "CheckpointQueued", fmt.Sprintf("Checkpoint %s is queued at position %d", ckpt.Name, ckpt.Status.QueuePosition)
+	case v1alpha1.Checkpointing:
+		reason, message = "Checkpointing", fmt.Sprintf("Checkpoint %s started on node %s", ckpt.Name, ckpt.Status.NodeName)
+	case v1alpha1.Checkpointed:
+		reason, message = "Checkpointed", fmt.Sprintf("Pod checkpointed by checkpoint %s", ckpt.Name)
+	case v1alpha1.AutoMigrationSubmitted:
+		reason, message = "Migrated", fmt.Sprintf("Pod migrated by checkpoint %s, the pod is deleted and restored by restore %s", ckpt.Name, ckpt.Name)
+	case v1alpha1.CheckpointFailed:
+		reason, message = "CheckpointFailed", fmt.Sprintf("Checkpoint %s failed, %s", ckpt.Name, message)
+	default:
+		return
+	}
+	// pod uid is resolved when checkpoint is Pending, and event is not emitted on the pod which is not found.
+	if len(ckpt.Status.PodUID) == 0 {
+		return
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: ckpt.Namespace, Name: ckpt.Spec.PodName, UID: types.UID(ckpt.Status.PodUID)}}
+	c.recorder.Event(pod, eventType, reason, message)
+}
@@ -0,0 +1,267 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/cmd/grit-agent/app/options"
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
+	"github.com/kaito-project/grit/pkg/gritmanager/agentmanager"
+	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
+)
+
+// prestage copies checkpointed data to candidate nodes of restoration pod before the checkpointed pod is deleted.
+// candidate nodes are selected only once and recorded in status, true is returned when pre-staging is completed.
+// pre-staging failures are recorded in status, but checkpoint doesn't fail because restoration pod can still
+// download checkpointed data.
+func (c *Controller) prestage(ctx context.Context, ckpt *v1alpha1.Checkpoint) (bool, error) {
+	cond := util.GetCondition(ckpt.Status.Conditions, v1alpha1.CheckpointPrestaged)
+	if cond != nil && cond.Status == metav1.ConditionTrue {
+		return true, nil
+	}
+
+	if cond == nil {
+		nodeNames, err := c.prestageCandidateNodes(ctx, ckpt)
+		if err != nil {
+			return false, err
+		} else if len(nodeNames) == 0 {
+			util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, v1alpha1.CheckpointPrestaged, "NoCandidateNode", "there is no candidate node for pre-staging checkpointed data")
+			return true, nil
+		}
+
+		ckpt.Status.PrestagedNodes = lo.Map(nodeNames, func(nodeName string, _ int) v1alpha1.PrestagedNode {
+			return v1alpha1.PrestagedNode{NodeName: nodeName, Phase: v1alpha1.Prestaging}
+		})
+		// candidate nodes are recorded in status before operations are started.
+		util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionFalse, v1alpha1.CheckpointPrestaged, "Prestaging", fmt.Sprintf("checkpointed data is being pre-staged to nodes %v", nodeNames))
+		return false, nil
+	}
+
+	var operationRunning bool
+	for i := range ckpt.Status.PrestagedNodes {
+		if ckpt.Status.PrestagedNodes[i].Phase != v1alpha1.Prestaging {
+			continue
+		}
+		running, err := c.prestageToNode(ctx, ckpt, &ckpt.Status.PrestagedNodes[i])
+		if err != nil {
+			return false, err
+		}
+		operationRunning = operationRunning || running
+	}
+
+	if lo.ContainsBy(ckpt.Status.PrestagedNodes, func(node v1alpha1.PrestagedNode) bool { return node.Phase == v1alpha1.Prestaging }) {
+		if operationRunning {
+			// there is no event for operation in grit agent daemon, so poll the status periodically.
+			return false, util.RequeueAfter(5*time.Second, "prestage operation is running in grit agent daemon")
+		}
+		return false, nil
+	}
+
+	prestaged := lo.CountBy(ckpt.Status.PrestagedNodes, func(node v1alpha1.PrestagedNode) bool { return node.Phase == v1alpha1.Prestaged })
+	util.UpdateCondition(c.clock, &ckpt.Status.Conditions, metav1.ConditionTrue, v1alpha1.CheckpointPrestaged, "PrestagingCompleted", fmt.Sprintf("checkpointed data is pre-staged to %d of %d candidate nodes", prestaged, len(ckpt.Status.PrestagedNodes)))
+	return true, nil
+}
+
+func (c *Controller) prestageCandidateNodes(ctx context.Context, ckpt *v1alpha1.Checkpoint) ([]string, error) {
+	var pod corev1.Pod
+	if err := c.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: ckpt.Spec.PodName}, &pod); err != nil {
+		// restoration pod will be created by the pod owner, but scheduling constraints of it are unknown.
+		return nil, client.IgnoreNotFound(err)
+	}
+
+	var nodeList corev1.NodeList
+	if err := c.List(ctx, &nodeList); err != nil {
+		return nil, err
+	}
+
+	maxNodes := 1
+	if ckpt.Spec.Prestage.MaxNodes > 0 {
+		maxNodes = int(ckpt.Spec.Prestage.MaxNodes)
+	}
+	return util.PrestageCandidateNodes(&pod, nodeList.Items, maxNodes), nil
+}
+
+// prestageToNode copies checkpointed data to the node by grit agent daemon or grit agent job, and the node
+// status is updated when pre-staging is completed. true is returned if the operation is running in grit agent daemon.
+func (c *Controller) prestageToNode(ctx context.Context, ckpt *v1alpha1.Checkpoint, node *v1alpha1.PrestagedNode) (bool, error) {
+	// grit agent job will be used as a fallback if grit agent daemon is unavailable, or pulling checkpointed
+	// data in volume claim from the peer failed. the job is checked first, so the operation is not submitted
+	// to grit agent daemon again after falling back.
+	var job batchv1.Job
+	if err := c.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: util.PrestageGritAgentJobName(ckpt, options.ActionPrestage, node.NodeName)}, &job); apierrors.IsNotFound(err) && c.agentManager.UseAgentDaemon(ckpt, node.NodeName) {
+		if running, handled := c.prestageByAgentDaemon(ctx, ckpt, node); handled {
+			return running, nil
+		}
+	} else if err == nil {
+		completed, failed := util.JobCompletedOrFailed(&job)
+		if !completed && !failed {
+			return false, nil
+		}
+
+		node.Phase = v1alpha1.Prestaged
+		if failed {
+			node.Phase = v1alpha1.PrestageFailed
+			node.Message = fmt.Sprintf("grit agent job(%s/%s) failed to pre-stage checkpointed data", job.Namespace, job.Name)
+			if terminationMessage := util.GritAgentTerminationMessage(ctx, c.Client, &job); len(terminationMessage) != 0 {
+				node.Message = fmt.Sprintf("%s, %s", node.Message, terminationMessage)
+			}
+		}
+		// pre-staged data is kept on the node, so grit agent job can be removed.
+		return false, client.IgnoreNotFound(util.DeleteGritAgentJob(ctx, c.Client, &job))
+	} else if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	gritAgentJob, err := c.agentManager.GeneratePrestageGritAgentJob(ctx, ckpt, options.ActionPrestage, node.NodeName)
+	if err != nil {
+		node.Phase = v1alpha1.PrestageFailed
+		node.Message = fmt.Sprintf("failed to generate grit agent job, %v", err)
+		return false, nil
+	}
+	return false, c.Create(ctx, gritAgentJob)
+}
+
+// prestageByAgentDaemon submits prestage operation to grit agent daemon on the node or checks status of the
+// operation. false is returned for handled if grit agent daemon is unavailable, or checkpointed data in volume
+// claim can't be pulled from the peer.
+func (c *Controller) prestageByAgentDaemon(ctx context.Context, ckpt *v1alpha1.Checkpoint, node *v1alpha1.PrestagedNode) (running bool, handled bool) {
+	opID := agentmanager.PrestageOperationID(ckpt)
+	opStatus, err := c.agentManager.GetOperationStatus(ctx, node.NodeName, opID)
+	if status.Code(err) == codes.NotFound {
+		req, err := c.agentManager.GeneratePrestageRequest(ctx, ckpt, node.NodeName)
+		if err != nil && ckpt.Spec.VolumeClaim != nil {
+			log.FromContext(ctx).Info("checkpointed data can't be pulled from the peer, fall back to grit agent job", "checkpoint", ckpt.Name, "node", node.NodeName, "reason", err.Error())
+			return false, false
+		} else if err != nil {
+			node.Phase = v1alpha1.PrestageFailed
+			node.Message = fmt.Sprintf("failed to generate grit agent request, %v", err)
+			return false, true
+		}
+		if _, err := c.agentManager.SubmitPrestage(ctx, node.NodeName, req); err != nil {
+			log.FromContext(ctx).Error(err, "grit agent daemon is unavailable, fall back to grit agent job", "checkpoint", ckpt.Name, "node", node.NodeName)
+			return false, false
+		}
+		return true, true
+	} else if err != nil {
+		log.FromContext(ctx).Error(err, "grit agent daemon is unavailable, fall back to grit agent job", "checkpoint", ckpt.Name, "node", node.NodeName)
+		return false, false
+	}
+
+	if opStatus.Phase == api.OperationRunning {
+		return true, true
+	}
+
+	// pre-staged data on the host is kept for restoring, only operation record is removed.
+	if err := c.agentManager.CleanupOperation(ctx, node.NodeName, opID); err != nil {
+		log.FromContext(ctx).Info("failed to cleanup operation in grit agent daemon", "checkpoint", ckpt.Name, "node", node.NodeName, "error", err)
+	}
+	if opStatus.Phase == api.OperationFailed {
+		// checkpointed data in volume claim is pre-staged by grit agent job if pulling from the peer failed.
+		if ckpt.Spec.VolumeClaim != nil {
+			log.FromContext(ctx).Info("failed to pull checkpointed data from the peer, fall back to grit agent job", "checkpoint", ckpt.Name, "node", node.NodeName, "reason", opStatus.Message)
+			return false, false
+		}
+		node.Phase = v1alpha1.PrestageFailed
+		node.Message = fmt.Sprintf("prestage operation in grit agent daemon on node(%s) failed, %s", node.NodeName, opStatus.Message)
+		return false, true
+	}
+	node.Phase = v1alpha1.Prestaged
+	return false, true
+}
+
+// cleanupPrestagedData removes pre-staged data which is not used by restoration pod. pre-staged data is kept
+// until a restore of the checkpoint is restored, and the data on the node of restoration pod is kept.
+func (c *Controller) cleanupPrestagedData(ctx context.Context, ckpt *v1alpha1.Checkpoint) error {
+	if len(ckpt.Status.PrestagedNodes) == 0 {
+		return nil
+	}
+
+	var restoreList v1alpha1.RestoreList
+	if err := c.List(ctx, &restoreList, client.InNamespace(ckpt.Namespace)); err != nil {
+		return err
+	}
+	restores := lo.Filter(restoreList.Items, func(restore v1alpha1.Restore, _ int) bool {
+		return restore.Spec.CheckpointName == ckpt.Name
+	})
+
+	// restore in FanOut mode may bind new pods on pre-staged nodes at any time, so pre-staged data is kept.
+	if lo.ContainsBy(restores, func(restore v1alpha1.Restore) bool { return restore.Spec.Mode == v1alpha1.RestoreModeFanOut }) {
+		return nil
+	}
+	restored, found := lo.Find(restores, func(restore v1alpha1.Restore) bool { return restore.Status.Phase == v1alpha1.Restored })
+	if !found {
+		return nil
+	}
+
+	nodes := make([]v1alpha1.PrestagedNode, 0, len(ckpt.Status.PrestagedNodes))
+	for i := range ckpt.Status.PrestagedNodes {
+		node := &ckpt.Status.PrestagedNodes[i]
+		if node.NodeName == restored.Status.NodeName {
+			nodes = append(nodes, *node)
+			continue
+		}
+
+		// partial data has been removed by grit agent when pre-staging failed.
+		if node.Phase != v1alpha1.PrestageFailed {
+			removed, err := c.removePrestagedData(ctx, ckpt, node)
+			if err != nil {
+				return err
+			} else if !removed {
+				nodes = append(nodes, *node)
+				continue
+			}
+		}
+		log.FromContext(ctx).Info("pre-staged data is removed from the node", "checkpoint", ckpt.Name, "node", node.NodeName)
+	}
+	ckpt.Status.PrestagedNodes = nodes
+	return nil
+}
+
+// removePrestagedData removes pre-staged data on the node by grit agent daemon or grit agent job, true is
+// returned when the data is removed.
+func (c *Controller) removePrestagedData(ctx context.Context, ckpt *v1alpha1.Checkpoint, node *v1alpha1.PrestagedNode) (bool, error) {
+	if c.agentManager.GetAgentMode() == agentmanager.AgentModeDaemon && node.Phase != v1alpha1.PrestageCleaningUp {
+		err := c.agentManager.RemovePrestagedData(ctx, node.NodeName, ckpt)
+		if err == nil {
+			return true, nil
+		}
+		log.FromContext(ctx).Error(err, "grit agent daemon is unavailable, fall back to grit agent job", "checkpoint", ckpt.Name, "node", node.NodeName)
+	}
+
+	var job batchv1.Job
+	if err := c.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: util.PrestageGritAgentJobName(ckpt, options.ActionCleanup, node.NodeName)}, &job); err == nil {
+		completed, failed := util.JobCompletedOrFailed(&job)
+		if !completed && !failed {
+			return false, nil
+		} else if failed {
+			// node maybe has been removed, so cleanup is not retried.
+			log.FromContext(ctx).Info("grit agent job failed to remove pre-staged data", "checkpoint", ckpt.Name, "node", node.NodeName, "job", job.Name)
+		}
+		return true, client.IgnoreNotFound(util.DeleteGritAgentJob(ctx, c.Client, &job))
+	} else if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	gritAgentJob, err := c.agentManager.GeneratePrestageGritAgentJob(ctx, ckpt, options.ActionCleanup, node.NodeName)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to generate grit agent job for removing pre-staged data", "checkpoint", ckpt.Name, "node", node.NodeName)
+		return true, nil
+	}
+	node.Phase = v1alpha1.PrestageCleaningUp
+	return false, c.Create(ctx, gritAgentJob)
+}
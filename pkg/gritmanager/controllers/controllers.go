@@ -19,10 +19,12 @@ import (
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;patch;update
 
 func NewControllers(mgr manager.Manager, clock clock.Clock, opts *options.GritManagerOptions, agentManager *agentmanager.AgentManager) []controller.Controller {
+	// selectors have been validated when grit-manager starts.
+	namespaceSelector, objectSelector, _ := opts.PodWebhookSelectors()
 
 	return []controller.Controller{
-		secret.NewController(clock, mgr.GetClient(), opts.WorkingNamespace, opts.WebhookSecretName, opts.WebhookServiceName, opts.ExpirationDuration),
-		checkpoint.NewController(clock, mgr.GetClient(), agentManager),
-		restore.NewController(clock, mgr.GetClient(), agentManager),
+		secret.NewController(clock, mgr.GetClient(), opts.WorkingNamespace, opts.WebhookSecretName, opts.WebhookServiceName, opts.ExpirationDuration, namespaceSelector, objectSelector),
+		checkpoint.NewController(clock, mgr.GetClient(), mgr.GetEventRecorderFor("checkpoint-controller"), agentManager),
+		restore.NewController(clock, mgr.GetClient(), mgr.GetEventRecorderFor("restore-controller"), agentManager),
 	}
 }
@@ -0,0 +1,85 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
+)
+
+// recordPhaseEvents emits events on the restore and the restoration pod after phase of restore is changed, so owners
+// of the pod can find out that the pod is restored from a checkpoint by `kubectl describe pod`. for restore in FanOut
+// mode, events are emitted on every restoration pod when it's restored or failed.
+func (c *Controller) recordPhaseEvents(ctx context.Context, old, restore *v1alpha1.Restore) {
+	if restore.Spec.Mode == v1alpha1.RestoreModeFanOut {
+		c.recordFanOutPodEvents(old, restore)
+	}
+	if old.Status.Phase == restore.Status.Phase {
+		return
+	}
+
+	eventType, message := util.PhaseEvent(restore.Status.Conditions, string(restore.Status.Phase), "restore")
+	c.recorder.Event(restore, eventType, string(restore.Status.Phase), message)
+	if restore.Spec.Mode == v1alpha1.RestoreModeFanOut {
+		return
+	}
+
+	var reason string
+	switch restore.Status.Phase {
+	case v1alpha1.RestorePending:
+		reason, message = "RestorePending", fmt.Sprintf("Pod is selected for restoring from checkpoint %s by restore %s", restore.Spec.CheckpointName, restore.Name)
+	case v1alpha1.RestoreQueued:
+		reason, message = "RestoreQueued", fmt.Sprintf("Restore %s is queued at position %d", restore.Name, restore.Status.QueuePosition)
+	case v1alpha1.Restoring:
+		reason, message = "Restoring", fmt.Sprintf("Restoring from checkpoint %s on node %s", restore.Spec.CheckpointName, restore.Status.NodeName)
+	case v1alpha1.Restored:
+		reason, message = "Restored", fmt.Sprintf("Restored from checkpoint %s", restore.Spec.CheckpointName)
+	case v1alpha1.RestoreFailed:
+		reason, message = "RestoreFailed", fmt.Sprintf("Restore from checkpoint %s failed, %s", restore.Spec.CheckpointName, message)
+	default:
+		return
+	}
+
+	podName := restore.Status.TargetPod
+	if len(podName) == 0 {
+		podName = old.Status.TargetPod
+	}
+	if len(podName) == 0 {
+		return
+	}
+	// uid of the pod is needed for the event to be shown by `kubectl describe pod`.
+	var pod corev1.Pod
+	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: podName}, &pod); err != nil {
+		log.FromContext(ctx).Info("skip emitting event on restoration pod", "restore", restore.Name, "pod", podName, "error", err)
+		return
+	}
+	c.recorder.Event(&pod, eventType, reason, message)
+}
+
+// recordFanOutPodEvents emits events on restoration pods of restore in FanOut mode which are restored or failed.
+func (c *Controller) recordFanOutPodEvents(old, restore *v1alpha1.Restore) {
+	for _, podStatus := range restore.Status.Pods {
+		oldStatus, _ := lo.Find(old.Status.Pods, func(pod v1alpha1.RestorationPodStatus) bool { return pod.PodUID == podStatus.PodUID })
+		if oldStatus.Phase == podStatus.Phase {
+			continue
+		}
+
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: restore.Namespace, Name: podStatus.PodName, UID: podStatus.PodUID}}
+		switch podStatus.Phase {
+		case v1alpha1.Restored:
+			c.recorder.Event(pod, corev1.EventTypeNormal, "Restored", fmt.Sprintf("Restored from checkpoint %s by restore %s", restore.Spec.CheckpointName, restore.Name))
+		case v1alpha1.RestoreFailed:
+			c.recorder.Event(pod, corev1.EventTypeWarning, "RestoreFailed", fmt.Sprintf("Restore from checkpoint %s failed, %s", restore.Spec.CheckpointName, podStatus.Message))
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/samber/lo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
+	"github.com/kaito-project/grit/pkg/gritmanager/agentmanager"
+	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
+)
+
+const (
+	// fanOutMaxAttempts is the max number of attempts for downloading checkpointed data to a node.
+	fanOutMaxAttempts = 5
+	// fanOutRetryBaseDelay and fanOutRetryMaxDelay bound the backoff between download attempts.
+	fanOutRetryBaseDelay = 10 * time.Second
+	fanOutRetryMaxDelay  = 5 * time.Minute
+)
+
+// fanOutHandler is used for restore in FanOut mode. every matching pod is bound to the restore by pod webhook,
+// checkpointed data is downloaded once on each node where restoration pods are located, and restore results
+// of nodes and pods are recorded in restore status. restore stays in Restoring phase until it's deleted.
+func (c *Controller) fanOutHandler(ctx context.Context, restore *v1alpha1.Restore) error {
+	if restore.Status.Phase == v1alpha1.RestoreFailed {
+		return nil
+	} else if restore.Status.Phase == "" {
+		restore.Status.Phase = v1alpha1.RestoreCreated
+		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreCreated), "RestoreIsCreated", "restore resource is created")
+		return nil
+	}
+
+	var ckpt v1alpha1.Checkpoint
+	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.CheckpointName}, &ckpt); err != nil {
+		if apierrors.IsNotFound(err) {
+			restore.Status.Phase = v1alpha1.RestoreFailed
+			util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "CheckpointNotExist", fmt.Sprintf("checkpoint(%s/%s) which is used for restore(%s) doesn't exist", restore.Namespace, restore.Spec.CheckpointName, restore.Name))
+			return nil
+		}
+		return err
+	}
+
+	var podList corev1.PodList
+	if err := c.List(ctx, &podList, &client.ListOptions{Namespace: restore.Namespace}); err != nil {
+		return err
+	}
+	pods := lo.Filter(podList.Items, func(pod corev1.Pod, _ int) bool {
+		return pod.Annotations[v1alpha1.RestoreNameLabel] == restore.Name && pod.DeletionTimestamp.IsZero()
+	})
+
+	// download checkpointed data on nodes where restoration pods are scheduled. nodes which have downloaded
+	// data are kept in status, so the data is not downloaded again for new restoration pods on these nodes
+	// unless the data on node should be verified again. failed download is retried with backoff.
+	var operationRunning bool
+	var retryAfter time.Duration
+	now := c.clock.Now()
+	for _, nodeName := range lo.Uniq(lo.FilterMap(pods, func(pod corev1.Pod, _ int) (string, bool) {
+		return pod.Spec.NodeName, len(pod.Spec.NodeName) != 0
+	})) {
+		idx := lo.IndexOf(lo.Map(restore.Status.Nodes, func(node v1alpha1.RestoreNodeStatus, _ int) string { return node.NodeName }), nodeName)
+		if idx < 0 {
+			restore.Status.Nodes = append(restore.Status.Nodes, v1alpha1.RestoreNodeStatus{NodeName: nodeName, Phase: v1alpha1.Restoring, Attempts: 1, LastTransitionTime: &metav1.Time{Time: now}})
+			idx = len(restore.Status.Nodes) - 1
+		}
+		nodeStatus := &restore.Status.Nodes[idx]
+
+		switch nodeStatus.Phase {
+		case v1alpha1.RestoreFailed:
+			if nodeStatus.Attempts >= fanOutMaxAttempts {
+				continue
+			}
+			if delay := fanOutRetryDelay(nodeStatus, now); delay > 0 {
+				if retryAfter == 0 || delay < retryAfter {
+					retryAfter = delay
+				}
+				continue
+			}
+			// the stale grit agent job should be removed before download is retried.
+			if err := c.deleteFanOutJob(ctx, restore, nodeName); err != nil {
+				return err
+			}
+			nodeStatus.Attempts++
+			transitNodePhase(nodeStatus, v1alpha1.Restoring, now)
+		case v1alpha1.Restored:
+			// checkpointed data on the node may be removed or corrupted after it's downloaded, so verify the data
+			// again when new restoration pods are scheduled to the node. downloaded data is only verified by the
+			// grit agent and not transferred again.
+			if !hasNewPendingPods(pods, nodeStatus) {
+				continue
+			}
+			nodeStatus.Attempts = 1
+			transitNodePhase(nodeStatus, v1alpha1.Restoring, now)
+		}
+
+		if nodeStatus.Phase != v1alpha1.Restoring {
+			continue
+		}
+		running, err := c.downloadToNode(ctx, &ckpt, restore, nodeStatus)
+		if err != nil {
+			return err
+		}
+		if nodeStatus.Phase != v1alpha1.Restoring {
+			nodeStatus.LastTransitionTime = &metav1.Time{Time: now}
+			if nodeStatus.Phase == v1alpha1.RestoreFailed && nodeStatus.Attempts < fanOutMaxAttempts {
+				if delay := fanOutRetryDelay(nodeStatus, now); retryAfter == 0 || delay < retryAfter {
+					retryAfter = delay
+				}
+			}
+		}
+		operationRunning = operationRunning || running
+	}
+	sort.Slice(restore.Status.Nodes, func(i, j int) bool { return restore.Status.Nodes[i].NodeName < restore.Status.Nodes[j].NodeName })
+
+	restore.Status.Pods = make([]v1alpha1.RestorationPodStatus, 0, len(pods))
+	for i := range pods {
+		restore.Status.Pods = append(restore.Status.Pods, restorationPodStatus(&pods[i], restore.Status.Nodes))
+	}
+	sort.Slice(restore.Status.Pods, func(i, j int) bool { return restore.Status.Pods[i].PodName < restore.Status.Pods[j].PodName })
+
+	restore.Status.RestoredPods = int32(lo.CountBy(restore.Status.Pods, func(pod v1alpha1.RestorationPodStatus) bool {
+		return pod.Phase == v1alpha1.Restored
+	}))
+	failedPods := lo.CountBy(restore.Status.Pods, func(pod v1alpha1.RestorationPodStatus) bool {
+		return pod.Phase == v1alpha1.RestoreFailed
+	})
+	restore.Status.Phase = v1alpha1.Restoring
+	util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Restoring), "FanOutRestoring", fmt.Sprintf("%d pods are bound to restore(%s), %d pods are restored and %d pods failed", len(pods), restore.Name, restore.Status.RestoredPods, failedPods))
+
+	if operationRunning {
+		// there is no event for operation in grit agent daemon, so poll the status periodically.
+		return util.RequeueAfter(5*time.Second, "restore operation is running in grit agent daemon")
+	} else if retryAfter > 0 {
+		return util.RequeueAfter(retryAfter, "failed download of checkpointed data will be retried")
+	}
+	return nil
+}
+
+// fanOutRetryDelay returns the remaining time before failed download on the node is retried, the backoff
+// doubles for each attempt.
+func fanOutRetryDelay(nodeStatus *v1alpha1.RestoreNodeStatus, now time.Time) time.Duration {
+	backoff := fanOutRetryBaseDelay
+	for i := int32(1); i < nodeStatus.Attempts && backoff < fanOutRetryMaxDelay; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, fanOutRetryMaxDelay)
+	if nodeStatus.LastTransitionTime == nil {
+		return 0
+	}
+	return max(nodeStatus.LastTransitionTime.Add(backoff).Sub(now), 0)
+}
+
+// transitNodePhase updates phase of the node and records the transition time.
+func transitNodePhase(nodeStatus *v1alpha1.RestoreNodeStatus, phase v1alpha1.RestorePhase, now time.Time) {
+	nodeStatus.Phase = phase
+	nodeStatus.Message = ""
+	nodeStatus.LastTransitionTime = &metav1.Time{Time: now}
+}
+
+// hasNewPendingPods checks whether restoration pods which are not started yet are created on the node after
+// the checkpointed data is downloaded.
+func hasNewPendingPods(pods []corev1.Pod, nodeStatus *v1alpha1.RestoreNodeStatus) bool {
+	if nodeStatus.LastTransitionTime == nil {
+		return false
+	}
+	return lo.ContainsBy(pods, func(pod corev1.Pod) bool {
+		return pod.Spec.NodeName == nodeStatus.NodeName &&
+			pod.Status.Phase == corev1.PodPending &&
+			nodeStatus.LastTransitionTime.Before(&pod.CreationTimestamp)
+	})
+}
+
+// deleteFanOutJob removes grit agent job which downloaded checkpointed data to the node.
+func (c *Controller) deleteFanOutJob(ctx context.Context, restore *v1alpha1.Restore, nodeName string) error {
+	var job batchv1.Job
+	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: util.FanOutGritAgentJobName(restore, nodeName)}, &job); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return client.IgnoreNotFound(util.DeleteGritAgentJob(ctx, c.Client, &job))
+}
+
+// downloadToNode downloads checkpointed data to the node by grit agent daemon or grit agent job, and the node
+// status is updated when download is completed. true is returned if the operation is running in grit agent daemon.
+func (c *Controller) downloadToNode(ctx context.Context, ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore, nodeStatus *v1alpha1.RestoreNodeStatus) (bool, error) {
+	// checkpointed data without cloud storage is kept on the node where the pod is checkpointed, it can only be
+	// downloaded to other nodes by peer transfer.
+	if !c.agentManager.CanRestoreOnNode(ckpt, nodeStatus.NodeName) {
+		nodeStatus.Phase = v1alpha1.RestoreFailed
+		nodeStatus.Message = fmt.Sprintf("checkpointed data is kept on node(%s), it can't be restored on node(%s)", ckpt.Status.NodeName, nodeStatus.NodeName)
+		// the failure is not transient, so download is not retried.
+		nodeStatus.Attempts = fanOutMaxAttempts
+		return false, nil
+	}
+
+	// grit agent job will be used as a fallback if grit agent daemon is unavailable, or pulling checkpointed
+	// data in volume claim from the peer failed. the job is checked first, so the operation is not submitted
+	// to grit agent daemon again after falling back.
+	var job batchv1.Job
+	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: util.FanOutGritAgentJobName(restore, nodeStatus.NodeName)}, &job); apierrors.IsNotFound(err) && c.agentManager.UseAgentDaemon(ckpt, nodeStatus.NodeName) {
+		if running, handled := c.downloadByAgentDaemon(ctx, ckpt, restore, nodeStatus); handled {
+			return running, nil
+		}
+	} else if err == nil {
+		// result of the job which is being deleted belongs to the previous attempt, wait until it's removed.
+		if !job.DeletionTimestamp.IsZero() {
+			return false, nil
+		}
+		completed, failed := util.JobCompletedOrFailed(&job)
+		if failed {
+			message := fmt.Sprintf("grit agent job(%s/%s) failed to download checkpointed data", job.Namespace, job.Name)
+			if terminationMessage := util.GritAgentTerminationMessage(ctx, c.Client, &job); len(terminationMessage) != 0 {
+				message = fmt.Sprintf("%s, %s", message, terminationMessage)
+			}
+			nodeStatus.Phase = v1alpha1.RestoreFailed
+			nodeStatus.Message = message
+		} else if completed {
+			// checkpointed data is kept on the node, so grit agent job can be removed.
+			nodeStatus.Phase = v1alpha1.Restored
+			return false, client.IgnoreNotFound(util.DeleteGritAgentJob(ctx, c.Client, &job))
+		}
+		return false, nil
+	} else if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	gritAgentJob, err := c.agentManager.GenerateFanOutGritAgentJob(ctx, ckpt, restore, nodeStatus.NodeName)
+	if err != nil {
+		nodeStatus.Phase = v1alpha1.RestoreFailed
+		nodeStatus.Message = fmt.Sprintf("failed to generate grit agent job, %v", err)
+		return false, nil
+	}
+	return false, c.Create(ctx, gritAgentJob)
+}
+
+// downloadByAgentDaemon submits restore operation to grit agent daemon on the node or checks status of the
+// operation. false is returned for handled if grit agent daemon is unavailable, or checkpointed data in volume
+// claim can't be pulled from the peer.
+func (c *Controller) downloadByAgentDaemon(ctx context.Context, ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore, nodeStatus *v1alpha1.RestoreNodeStatus) (running bool, handled bool) {
+	opID := agentmanager.OperationID(nil, restore)
+	opStatus, err := c.agentManager.GetOperationStatus(ctx, nodeStatus.NodeName, opID)
+	if status.Code(err) == codes.NotFound {
+		req, err := c.agentManager.GenerateRestoreRequest(ctx, ckpt, restore, nodeStatus.NodeName)
+		if err != nil && ckpt.Spec.VolumeClaim != nil {
+			log.FromContext(ctx).Info("checkpointed data can't be pulled from the peer, fall back to grit agent job", "restore", restore.Name, "node", nodeStatus.NodeName, "reason", err.Error())
+			return false, false
+		} else if err != nil {
+			nodeStatus.Phase = v1alpha1.RestoreFailed
+			nodeStatus.Message = fmt.Sprintf("failed to generate grit agent request, %v", err)
+			return false, true
+		}
+		if _, err := c.agentManager.SubmitRestore(ctx, nodeStatus.NodeName, req); err != nil {
+			log.FromContext(ctx).Error(err, "grit agent daemon is unavailable, fall back to grit agent job", "restore", restore.Name, "node", nodeStatus.NodeName)
+			return false, false
+		}
+		return true, true
+	} else if err != nil {
+		log.FromContext(ctx).Error(err, "grit agent daemon is unavailable, fall back to grit agent job", "restore", restore.Name, "node", nodeStatus.NodeName)
+		return false, false
+	}
+
+	if opStatus.Phase == api.OperationRunning {
+		return true, true
+	}
+
+	if err := c.agentManager.CleanupOperation(ctx, nodeStatus.NodeName, opID); err != nil {
+		log.FromContext(ctx).Info("failed to cleanup operation in grit agent daemon", "restore", restore.Name, "node", nodeStatus.NodeName, "error", err)
+	}
+	if opStatus.Phase == api.OperationFailed {
+		// checkpointed data in volume claim is downloaded by grit agent job if pulling from the peer failed.
+		if ckpt.Spec.VolumeClaim != nil && !agentmanager.IsLocalRestore(ckpt, nodeStatus.NodeName) {
+			log.FromContext(ctx).Info("failed to pull checkpointed data from the peer, fall back to grit agent job", "restore", restore.Name, "node", nodeStatus.NodeName, "reason", opStatus.Message)
+			return false, false
+		}
+		nodeStatus.Phase = v1alpha1.RestoreFailed
+		nodeStatus.Message = fmt.Sprintf("restore operation in grit agent daemon on node(%s) failed, %s", nodeStatus.NodeName, opStatus.Message)
+		return false, true
+	}
+	nodeStatus.Phase = v1alpha1.Restored
+	return false, true
+}
+
+// restorationPodStatus resolves restore result of the pod from pod phase and download result of the node.
+func restorationPodStatus(pod *corev1.Pod, nodes []v1alpha1.RestoreNodeStatus) v1alpha1.RestorationPodStatus {
+	podStatus := v1alpha1.RestorationPodStatus{
+		PodName:  pod.Name,
+		PodUID:   pod.UID,
+		NodeName: pod.Spec.NodeName,
+		Phase:    v1alpha1.Restoring,
+	}
+
+	node, _ := lo.Find(nodes, func(node v1alpha1.RestoreNodeStatus) bool { return node.NodeName == pod.Spec.NodeName })
+	switch {
+	case pod.Status.Phase == corev1.PodFailed:
+		podStatus.Phase = v1alpha1.RestoreFailed
+		podStatus.Message = fmt.Sprintf("restoration pod(%s) failed to start", pod.Name)
+	case pod.Status.Phase == corev1.PodRunning:
+		podStatus.Phase = v1alpha1.Restored
+	case len(pod.Spec.NodeName) == 0:
+		podStatus.Phase = v1alpha1.RestorePending
+	case node.Phase == v1alpha1.RestoreFailed && node.Attempts >= fanOutMaxAttempts:
+		// download to the node is retried until the max attempts.
+		podStatus.Phase = v1alpha1.RestoreFailed
+		podStatus.Message = node.Message
+	}
+	return podStatus
+}
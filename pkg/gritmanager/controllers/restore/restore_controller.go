@@ -5,17 +5,21 @@ package restore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"time"
 
 	"github.com/samber/lo"
 	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/utils/clock"
 	controllerruntime "sigs.k8s.io/controller-runtime"
@@ -28,8 +32,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
 	"github.com/kaito-project/grit/pkg/gritmanager/agentmanager"
 	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
+	"github.com/kaito-project/grit/pkg/gritmanager/metrics"
+	"github.com/kaito-project/grit/pkg/util/volumesnapshot"
 )
 
 var (
@@ -41,19 +48,28 @@ var (
 	}
 )
 
+const (
+	// noMatchingPodGracePeriod is the period for waiting restoration pod to be selected before NoMatchingPod is reported.
+	noMatchingPodGracePeriod = 5 * time.Minute
+	// restorationPodBindingTimeout is the period for waiting selected pod to be created before the binding is released.
+	restorationPodBindingTimeout = time.Minute
+)
+
 type RestoreStateHandler func(ctx context.Context, restore *v1alpha1.Restore) error
 
 type Controller struct {
 	client.Client
 	clock         clock.Clock
+	recorder      record.EventRecorder
 	agentManager  *agentmanager.AgentManager
 	statesMachine map[v1alpha1.RestorePhase]RestoreStateHandler
 }
 
-func NewController(clk clock.Clock, kubeClient client.Client, agentManager *agentmanager.AgentManager) *Controller {
+func NewController(clk clock.Clock, kubeClient client.Client, recorder record.EventRecorder, agentManager *agentmanager.AgentManager) *Controller {
 	c := &Controller{
 		clock:        clk,
 		Client:       kubeClient,
+		recorder:     recorder,
 		agentManager: agentManager,
 	}
 
@@ -74,12 +90,20 @@ func (c *Controller) Reconcile(ctx context.Context, restore *v1alpha1.Restore) (
 	phase := v1alpha1.RestorePhase(util.ResolveLastPhaseFromConditions(updatedRestore.Status.Conditions, restoreConditionOrder, string(v1alpha1.RestoreCreated)))
 	log.FromContext(ctx).Info("the last pahse of restore", "namespace", restore.Namespace, "restore", restore.Name, "phase", phase)
 	stateHandler, ok := c.statesMachine[phase]
+	if restore.Spec.Mode == v1alpha1.RestoreModeFanOut {
+		stateHandler, ok = c.fanOutHandler, true
+	}
 	if !ok {
 		return reconcile.Result{}, nil
 	}
 
+	var result reconcile.Result
 	if err := stateHandler(ctx, updatedRestore); err != nil {
-		return reconcile.Result{}, err
+		var requeueErr *util.RequeueError
+		if !errors.As(err, &requeueErr) {
+			return reconcile.Result{}, err
+		}
+		result.RequeueAfter = requeueErr.After
 	}
 
 	// if phase is not RestoreFailed, we need to remove failed condition
@@ -88,9 +112,13 @@ func (c *Controller) Reconcile(ctx context.Context, restore *v1alpha1.Restore) (
 	}
 
 	if !reflect.DeepEqual(restore, updatedRestore) {
-		return reconcile.Result{}, c.Status().Update(ctx, updatedRestore)
+		if err := c.Status().Update(ctx, updatedRestore); err != nil {
+			return result, err
+		}
+		metrics.ObservePhaseTransition(metrics.KindRestore, string(restore.Status.Phase), string(updatedRestore.Status.Phase), restore.Status.Conditions, updatedRestore.Status.Conditions, c.clock.Now())
+		c.recordPhaseEvents(ctx, restore, updatedRestore)
 	}
-	return reconcile.Result{}, nil
+	return result, nil
 }
 
 // createdHandler is used for waiting to select the restoration pod, then upgraded state to RestorePending.
@@ -101,72 +129,189 @@ func (c *Controller) createdHandler(ctx context.Context, restore *v1alpha1.Resto
 		return nil
 	}
 
-	// waiting restoration pod is selected
-	if restore.Annotations[v1alpha1.RestorationPodSelectedLabel] != "true" {
+	// waiting restoration pod is selected, and report NoMatchingPod if no pod is selected after a grace period.
+	bindingID := restore.Annotations[v1alpha1.RestorationPodSelectedLabel]
+	if len(bindingID) == 0 {
+		waited := c.clock.Since(restore.CreationTimestamp.Time)
+		if waited < noMatchingPodGracePeriod {
+			return util.RequeueAfter(noMatchingPodGracePeriod-waited, "waiting restoration pod is selected")
+		}
+		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreCreated), "NoMatchingPod", fmt.Sprintf("no pod is matched by restore(%s) in %v, check ownerRef and selector of restore and pod spec of restoration pod", restore.Name, noMatchingPodGracePeriod))
 		return nil
 	}
 
+	pod, err := c.findRestorationPod(ctx, restore, bindingID)
+	if err != nil {
+		return err
+	} else if pod == nil {
+		// restoration pod may be not synced into cache yet, or it's not created at all because it's rejected
+		// by other admission webhooks after it's selected. so the binding is released if pod doesn't show up.
+		cond := util.GetCondition(restore.Status.Conditions, string(v1alpha1.RestoreCreated))
+		if cond == nil || cond.Reason != "WaitingRestorationPod" {
+			util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreCreated), "WaitingRestorationPod", fmt.Sprintf("pod is selected for restore(%s), waiting pod is created", restore.Name))
+			return util.RequeueAfter(restorationPodBindingTimeout, "waiting restoration pod is created")
+		}
+		if waited := c.clock.Since(cond.LastTransitionTime.Time); waited < restorationPodBindingTimeout {
+			return util.RequeueAfter(restorationPodBindingTimeout-waited, "waiting restoration pod is created")
+		}
+		return c.releaseBinding(ctx, restore, bindingID, "RestorationPodNotCreated", fmt.Sprintf("selected pod for restore(%s) is not created in %v", restore.Name, restorationPodBindingTimeout))
+	} else if !pod.DeletionTimestamp.IsZero() {
+		return c.releaseBinding(ctx, restore, bindingID, "RestorationPodDeleted", fmt.Sprintf("selected pod(%s) for restore(%s) is deleted before restoring starts", pod.Name, restore.Name))
+	}
+
+	// pvcs of restoration pod have been rewritten by pod webhook, provision them from volume snapshots.
+	if provisioned, err := c.provisionVolumeClaims(ctx, restore); err != nil || !provisioned {
+		return err
+	}
+
+	if len(pod.Spec.NodeName) != 0 {
+		restore.Status.NodeName = pod.Spec.NodeName
+	}
+	restore.Status.TargetPod = pod.Name
+	restore.Status.Binding = &v1alpha1.RestorationPodBinding{
+		ID:        bindingID,
+		PodName:   pod.Name,
+		PodUID:    pod.UID,
+		BoundTime: metav1.NewTime(c.clock.Now()),
+	}
+	restore.Status.Phase = v1alpha1.RestorePending
+	util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestorePending), "RestorationPodSelected", fmt.Sprintf("pod(%s) is selected as a restoration pod", pod.Name))
+	return nil
+}
+
+// findRestorationPod returns the pod which has the same binding id as restore, nil is returned if the pod is not
+// found. the binding id is unique for each selection, so at most one pod can be found. restores which are
+// selected before binding id is introduced are marked with "true", and the pod is found by restore name.
+func (c *Controller) findRestorationPod(ctx context.Context, restore *v1alpha1.Restore, bindingID string) (*corev1.Pod, error) {
 	var podList corev1.PodList
 	if err := c.List(ctx, &podList, &client.ListOptions{Namespace: restore.Namespace}); err != nil {
-		return err
+		return nil, err
 	}
 
-	pods := lo.Filter(podList.Items, func(pod corev1.Pod, _ int) bool {
-		return pod.Annotations[v1alpha1.RestoreNameLabel] == restore.Name
+	pod, found := lo.Find(podList.Items, func(pod corev1.Pod) bool {
+		if pod.Annotations[v1alpha1.RestoreNameLabel] != restore.Name {
+			return false
+		}
+		return bindingID == "true" || pod.Annotations[v1alpha1.RestoreBindingLabel] == bindingID
 	})
-
-	if len(pods) == 0 {
-		return fmt.Errorf("there is no pod for selected restore(%s), wait pod created", restore.Name)
-	} else if len(pods) > 1 {
-		restore.Status.Phase = v1alpha1.RestoreFailed
-		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "MultiplePodsSelected", fmt.Sprintf("%d pods are selected as restoration pod for restore(%s)", len(pods), restore.Name))
-		return nil
+	if !found {
+		return nil, nil
 	}
+	return &pod, nil
+}
 
-	if len(pods[0].Spec.NodeName) != 0 {
-		restore.Status.NodeName = pods[0].Spec.NodeName
+// releaseBinding releases the binding between restore and restoration pod before restoring starts, restore
+// goes back to Created phase and another pod can be selected by pod webhook. the selected mark of restore is
+// kept if it has been replaced by a new selection.
+func (c *Controller) releaseBinding(ctx context.Context, restore *v1alpha1.Restore, bindingID, reason, message string) error {
+	if restore.Annotations[v1alpha1.RestorationPodSelectedLabel] == bindingID {
+		patch := client.MergeFromWithOptions(restore.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		delete(restore.Annotations, v1alpha1.RestorationPodSelectedLabel)
+		if err := c.Patch(ctx, restore, patch); err != nil {
+			return err
+		}
 	}
-	restore.Status.TargetPod = pods[0].Name
-	restore.Status.Phase = v1alpha1.RestorePending
-	util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestorePending), "RestorationPodSelected", fmt.Sprintf("pod(%s) is selected as a restoration pod", pods[0].Name))
+
+	restore.Status.Binding = nil
+	restore.Status.TargetPod = ""
+	restore.Status.NodeName = ""
+	restore.Status.Phase = v1alpha1.RestoreCreated
+	util.RemoveCondition(&restore.Status.Conditions, string(v1alpha1.RestorePending))
+	restore.Status.QueuePosition = 0
+	util.RemoveCondition(&restore.Status.Conditions, string(v1alpha1.RestoreQueued))
+	util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreCreated), reason, message)
 	return nil
 }
 
+// provisionVolumeClaims creates pvcs from volume snapshots which are taken with the checkpoint. spec of
+// new pvc is copied from the checkpointed pvc, and restoration pod will not be scheduled until pvc is bound.
+// false is returned when restore is failed.
+func (c *Controller) provisionVolumeClaims(ctx context.Context, restore *v1alpha1.Restore) (bool, error) {
+	var ckpt v1alpha1.Checkpoint
+	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.CheckpointName}, &ckpt); err != nil {
+		// checkpoint not found error will be handled in pending state.
+		return true, client.IgnoreNotFound(err)
+	}
+
+	for _, ref := range ckpt.Status.VolumeSnapshots {
+		var sourcePVC corev1.PersistentVolumeClaim
+		if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: ref.ClaimName}, &sourcePVC); err != nil {
+			if apierrors.IsNotFound(err) {
+				restore.Status.Phase = v1alpha1.RestoreFailed
+				util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "SourceVolumeClaimNotExist", fmt.Sprintf("checkpointed pvc(%s) doesn't exist, pvc can't be provisioned from volume snapshot(%s)", ref.ClaimName, ref.VolumeSnapshotName))
+				return false, nil
+			}
+			return false, err
+		}
+
+		pvc := corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      volumesnapshot.RestoredClaimName(restore.Name, ref.ClaimName),
+				Namespace: restore.Namespace,
+				Labels: map[string]string{
+					v1alpha1.RestoreNameLabel: restore.Name,
+				},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      sourcePVC.Spec.AccessModes,
+				Resources:        sourcePVC.Spec.Resources,
+				StorageClassName: sourcePVC.Spec.StorageClassName,
+				VolumeMode:       sourcePVC.Spec.VolumeMode,
+				DataSource:       volumesnapshot.DataSource(ref.VolumeSnapshotName),
+			},
+		}
+		if err := c.Create(ctx, &pvc); client.IgnoreAlreadyExists(err) != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 // pendingHandler is used for distributing grit agent pod to specified node which has the pod for restoring.
-// restore state will be upgraded to restoring after grit agent pod created.
+// restore state will be upgraded to restoring after grit agent pod created, and it's Queued until the
+// operation is admitted by limits of concurrent operations.
 func (c *Controller) pendingHandler(ctx context.Context, restore *v1alpha1.Restore) error {
 	// Target pod is selected
 	if len(restore.Status.TargetPod) == 0 {
 		return nil
 	}
 
-	// waiting target pod is scheduled
-	if len(restore.Status.NodeName) == 0 {
-		var pod corev1.Pod
-		if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Status.TargetPod}, &pod); err == nil {
-			if len(pod.Spec.NodeName) != 0 {
-				restore.Status.NodeName = pod.Spec.NodeName
-			}
-			return nil
-		} else if apierrors.IsNotFound(err) {
-			restore.Status.Phase = v1alpha1.RestoreFailed
-			util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "TargetPodNotExist", fmt.Sprintf("target pod(%s) for restore(%s) doesn't exist", restore.Status.TargetPod, restore.Name))
-			return nil
-		} else {
-			return err
-		}
-	}
-
 	// grit agent job is running, upgrade state to checkpointing when job is ready
 	var job batchv1.Job
 	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: util.GritAgentJobName(nil, restore)}, &job); err == nil {
+		message := fmt.Sprintf("grit agent job(%s/%s) for restore is created", job.Namespace, job.Name)
+		if restore.Spec.LazyPages {
+			message += ", lazy restore requires peer transfer by grit agent daemon, all checkpointed data is downloaded before restoring"
+		}
 		restore.Status.Phase = v1alpha1.Restoring
-		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Restoring), "GritAgentIsCreated", fmt.Sprintf("grit agent job(%s/%s) for restore is created", job.Namespace, job.Name))
+		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Restoring), "GritAgentIsCreated", message)
 		return nil
 	} else if !apierrors.IsNotFound(err) {
 		return err
 	}
 
+	// restoring doesn't start yet, so the binding is released if bound pod is deleted(or replaced by a pod
+	// with the same name), and another pod can be selected for restoring.
+	var pod corev1.Pod
+	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Status.TargetPod}, &pod); client.IgnoreNotFound(err) != nil {
+		return err
+	} else if apierrors.IsNotFound(err) || !pod.DeletionTimestamp.IsZero() ||
+		(restore.Status.Binding != nil && restore.Status.Binding.PodUID != pod.UID) {
+		bindingID := restore.Annotations[v1alpha1.RestorationPodSelectedLabel]
+		if restore.Status.Binding != nil {
+			bindingID = restore.Status.Binding.ID
+		}
+		return c.releaseBinding(ctx, restore, bindingID, "RestorationPodDeleted", fmt.Sprintf("bound pod(%s) for restore(%s) is deleted before restoring starts", restore.Status.TargetPod, restore.Name))
+	}
+
+	// waiting target pod is scheduled
+	if len(restore.Status.NodeName) == 0 {
+		if len(pod.Spec.NodeName) != 0 {
+			restore.Status.NodeName = pod.Spec.NodeName
+		}
+		return nil
+	}
+
 	// grit agent doesn't exist, create a grit agent job based on restore and checkpoint.
 	var ckpt v1alpha1.Checkpoint
 	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.CheckpointName}, &ckpt); err != nil {
@@ -178,6 +323,39 @@ func (c *Controller) pendingHandler(ctx context.Context, restore *v1alpha1.Resto
 		return err
 	}
 
+	// checkpointed data without cloud storage is kept on the node where the pod is checkpointed, it can only be
+	// restored on other nodes by peer transfer.
+	if !c.agentManager.CanRestoreOnNode(&ckpt, restore.Status.NodeName) {
+		restore.Status.Phase = v1alpha1.RestoreFailed
+		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "CheckpointDataNotOnNode", fmt.Sprintf("checkpointed data is kept on node(%s), it can't be restored on node(%s)", ckpt.Status.NodeName, restore.Status.NodeName))
+		return nil
+	} else if agentmanager.IsLocalRestore(&ckpt, restore.Status.NodeName) {
+		log.FromContext(ctx).Info("checkpointed data is on the node of restoration pod, skip downloading", "restore", restore.Name, "node", restore.Status.NodeName)
+	}
+
+	// restore is queued when limits of concurrent operations are reached, and admission is retried later.
+	restore.Status.Priority = util.ResolvePriority(restore.Spec.Priority, &pod)
+	if result, err := c.agentManager.AdmitRestore(ctx, &ckpt, restore); err != nil {
+		return err
+	} else if !result.Admitted {
+		restore.Status.Phase = v1alpha1.RestoreQueued
+		restore.Status.QueuePosition = result.QueuePosition
+		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreQueued), "AdmissionLimitReached", fmt.Sprintf("restore is queued at position %d, %s", result.QueuePosition, result.Reason))
+		return util.RequeueAfter(10*time.Second, "restore is queued")
+	}
+	restore.Status.QueuePosition = 0
+	util.RemoveCondition(&restore.Status.Conditions, string(v1alpha1.RestoreQueued))
+
+	// grit agent runs as daemon, submit restore operation to grit agent on the node. checkpointed data in volume
+	// claim of checkpoint is pulled from the peer by grit agent daemon if possible, otherwise it's downloaded by
+	// grit agent job which mounts the claim. grit agent job will be used as a fallback if grit agent daemon is
+	// unavailable.
+	if c.agentManager.UseAgentDaemon(&ckpt, restore.Status.NodeName) {
+		if submitted, err := c.submitToAgentDaemon(ctx, &ckpt, restore); err != nil || submitted {
+			return err
+		}
+	}
+
 	gritAgentJob, err := c.agentManager.GenerateGritAgentJob(ctx, &ckpt, restore)
 	if err != nil {
 		restore.Status.Phase = v1alpha1.RestoreFailed
@@ -189,8 +367,104 @@ func (c *Controller) pendingHandler(ctx context.Context, restore *v1alpha1.Resto
 	return c.Create(ctx, gritAgentJob)
 }
 
+func (c *Controller) submitToAgentDaemon(ctx context.Context, ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore) (bool, error) {
+	req, err := c.agentManager.GenerateRestoreRequest(ctx, ckpt, restore, restore.Status.NodeName)
+	if err != nil && ckpt.Spec.VolumeClaim != nil {
+		log.FromContext(ctx).Info("checkpointed data can't be pulled from the peer, fall back to grit agent job", "restore", restore.Name, "node", restore.Status.NodeName, "reason", err.Error())
+		return false, nil
+	} else if err != nil {
+		restore.Status.Phase = v1alpha1.RestoreFailed
+		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "GenerateGritAgentFailed", fmt.Sprintf("failed to generate grit agent request, %v", err))
+		return true, nil
+	}
+
+	if _, err := c.agentManager.SubmitRestore(ctx, restore.Status.NodeName, req); err != nil {
+		log.FromContext(ctx).Error(err, "grit agent daemon is unavailable, fall back to grit agent job", "restore", restore.Name, "node", restore.Status.NodeName)
+		return false, nil
+	}
+
+	message := fmt.Sprintf("restore operation is accepted by grit agent daemon on node(%s)", restore.Status.NodeName)
+	if restore.Spec.LazyPages && !req.LazyPages {
+		message += ", lazy restore requires peer transfer from another node, all checkpointed data is downloaded before restoring"
+	}
+	restore.Status.Phase = v1alpha1.Restoring
+	util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Restoring), util.GritAgentDaemonAcceptedReason, message)
+	return true, nil
+}
+
+// fallBackToGritAgentJob creates grit agent job which downloads checkpointed data from volume claim of the
+// checkpoint when restore operation in grit agent daemon failed to pull the data from the peer. false is
+// returned if there is no fallback for the checkpoint.
+func (c *Controller) fallBackToGritAgentJob(ctx context.Context, restore *v1alpha1.Restore, reason string) (bool, error) {
+	var ckpt v1alpha1.Checkpoint
+	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.CheckpointName}, &ckpt); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if ckpt.Spec.VolumeClaim == nil || agentmanager.IsLocalRestore(&ckpt, restore.Status.NodeName) {
+		return false, nil
+	}
+
+	gritAgentJob, err := c.agentManager.GenerateGritAgentJob(ctx, &ckpt, restore)
+	if err != nil {
+		return false, nil
+	}
+	if err := c.Create(ctx, gritAgentJob); client.IgnoreAlreadyExists(err) != nil {
+		return false, err
+	}
+
+	if err := c.agentManager.CleanupOperation(ctx, restore.Status.NodeName, agentmanager.OperationID(nil, restore)); err != nil {
+		log.FromContext(ctx).Info("failed to cleanup operation in grit agent daemon", "restore", restore.Name, "error", err)
+	}
+	log.FromContext(ctx).Info("failed to pull checkpointed data from the peer, fall back to grit agent job", "restore", restore.Name, "node", restore.Status.NodeName, "reason", reason)
+	util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Restoring), "GritAgentIsCreated", fmt.Sprintf("grit agent job(%s/%s) for restore is created, because pulling checkpointed data from the peer failed, %s", gritAgentJob.Namespace, gritAgentJob.Name, reason))
+	return true, nil
+}
+
 // restoringHandler is used for checking restoration pod is restored or not.
 func (c *Controller) restoringHandler(ctx context.Context, restore *v1alpha1.Restore) error {
+	var operationRunning bool
+	// restoration pod will not be started until checkpointed data is downloaded, so failure of restore
+	// operation in grit agent daemon should be reflected to restore directly, unless checkpointed data
+	// can be downloaded from volume claim of the checkpoint by grit agent job instead.
+	if util.IsAcceptedByAgentDaemon(restore.Status.Conditions, string(v1alpha1.Restoring)) {
+		opStatus, err := c.agentManager.GetOperationStatus(ctx, restore.Status.NodeName, agentmanager.OperationID(nil, restore))
+		if status.Code(err) == codes.NotFound {
+			if fellBack, err := c.fallBackToGritAgentJob(ctx, restore, "restore operation is lost"); err != nil || fellBack {
+				return err
+			}
+			restore.Status.Phase = v1alpha1.RestoreFailed
+			util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "GritAgentOperationLost", fmt.Sprintf("restore operation is not found in grit agent daemon on node(%s)", restore.Status.NodeName))
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if opStatus.Phase == api.OperationFailed {
+			if fellBack, err := c.fallBackToGritAgentJob(ctx, restore, opStatus.Message); err != nil || fellBack {
+				return err
+			}
+			restore.Status.Phase = v1alpha1.RestoreFailed
+			util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "GritAgentOperationFailed", fmt.Sprintf("restore operation in grit agent daemon on node(%s) failed, %s", restore.Status.NodeName, opStatus.Message))
+			return nil
+		}
+		operationRunning = opStatus.Phase == api.OperationRunning
+	} else {
+		var gritAgentJob batchv1.Job
+		if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: util.GritAgentJobName(nil, restore)}, &gritAgentJob); client.IgnoreNotFound(err) != nil {
+			return err
+		} else if err == nil {
+			if _, isFailed := util.JobCompletedOrFailed(&gritAgentJob); isFailed {
+				message := fmt.Sprintf("grit agent job(%s/%s) failed to download checkpointed data", gritAgentJob.Namespace, gritAgentJob.Name)
+				if terminationMessage := util.GritAgentTerminationMessage(ctx, c.Client, &gritAgentJob); len(terminationMessage) != 0 {
+					message = fmt.Sprintf("%s, %s", message, terminationMessage)
+				}
+				restore.Status.Phase = v1alpha1.RestoreFailed
+				util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "GritAgentJobFailed", message)
+				return nil
+			}
+		}
+	}
+
 	var restorationPod corev1.Pod
 	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Status.TargetPod}, &restorationPod); client.IgnoreNotFound(err) != nil {
 		return err
@@ -204,8 +478,12 @@ func (c *Controller) restoringHandler(ctx context.Context, restore *v1alpha1.Res
 		restore.Status.Phase = v1alpha1.RestoreFailed
 		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.RestoreFailed), "RestorationPodFailed", fmt.Sprintf("restoration pod(%s) for restore(%s) failed to start", restore.Status.TargetPod, restore.Name))
 	} else if restorationPod.Status.Phase == corev1.PodRunning {
+		restore.Status.PodStartedTime = lo.ToPtr(metav1.NewTime(c.clock.Now()))
 		restore.Status.Phase = v1alpha1.Restored
 		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Restored), "RestorationPodRunning", fmt.Sprintf("restoration pod(%s) for restore(%s) is running", restore.Status.TargetPod, restore.Name))
+	} else if operationRunning {
+		// there is no event for operation in grit agent daemon, so poll the status periodically.
+		return util.RequeueAfter(5*time.Second, "restore operation is running in grit agent daemon")
 	}
 
 	return nil
@@ -213,25 +491,71 @@ func (c *Controller) restoringHandler(ctx context.Context, restore *v1alpha1.Res
 
 // restoredHandler is used for garbage collecting grit agent pod which used for restoring pod.
 func (c *Controller) restoredHandler(ctx context.Context, restore *v1alpha1.Restore) error {
+	// memory pages are still downloaded by grit agent daemon after restoration pod started for lazy restore,
+	// so the restore operation is kept until all pages arrived.
+	if restore.Spec.LazyPages && restore.Status.PagesCompletedTime == nil &&
+		util.IsAcceptedByAgentDaemon(restore.Status.Conditions, string(v1alpha1.Restoring)) {
+		if completed, err := c.checkLazyPages(ctx, restore); err != nil || !completed {
+			return err
+		}
+	}
+
 	var gritAgentJob batchv1.Job
 	if err := c.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: util.GritAgentJobName(nil, restore)}, &gritAgentJob); err == nil {
 		if gritAgentJob.DeletionTimestamp.IsZero() {
-			deletePolicy := metav1.DeletePropagationForeground
-			return c.Delete(ctx, &gritAgentJob, &client.DeleteOptions{PropagationPolicy: &deletePolicy})
+			return util.DeleteGritAgentJob(ctx, c.Client, &gritAgentJob)
 		}
 	} else if client.IgnoreNotFound(err) != nil {
 		return err
 	}
 
 	// grit agent job has been removed.
+	if util.IsAcceptedByAgentDaemon(restore.Status.Conditions, string(v1alpha1.Restoring)) {
+		if err := c.agentManager.CleanupOperation(ctx, restore.Status.NodeName, agentmanager.OperationID(nil, restore)); err != nil {
+			log.FromContext(ctx).Info("failed to cleanup operation in grit agent daemon", "restore", restore.Name, "error", err)
+		}
+	}
 	return nil
 }
 
-// +kubebuilder:rbac:groups=kaito.sh,resources=restores,verbs=list;watch;get
+// checkLazyPages records the time when all memory pages arrived on the node for lazy restore, false is returned
+// if memory pages are still being downloaded.
+func (c *Controller) checkLazyPages(ctx context.Context, restore *v1alpha1.Restore) (bool, error) {
+	if cond := util.GetCondition(restore.Status.Conditions, string(v1alpha1.Restored)); cond != nil && cond.Reason == "LazyPagesFailed" {
+		return true, nil
+	}
+
+	opStatus, err := c.agentManager.GetOperationStatus(ctx, restore.Status.NodeName, agentmanager.OperationID(nil, restore))
+	if status.Code(err) == codes.NotFound {
+		// grit agent daemon maybe has been restarted, the time when memory pages arrived is unknown.
+		log.FromContext(ctx).Info("restore operation is not found in grit agent daemon, skip recording completion of memory pages", "restore", restore.Name, "node", restore.Status.NodeName)
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	switch opStatus.Phase {
+	case api.OperationRunning:
+		return false, util.RequeueAfter(5*time.Second, "memory pages are being downloaded by grit agent daemon")
+	case api.OperationFailed:
+		util.UpdateCondition(c.clock, &restore.Status.Conditions, metav1.ConditionTrue, string(v1alpha1.Restored), "LazyPagesFailed", fmt.Sprintf("restoration pod(%s) is running, but memory pages failed to be downloaded on node(%s), %s", restore.Status.TargetPod, restore.Status.NodeName, opStatus.Message))
+		return true, nil
+	}
+
+	completionTime := c.clock.Now()
+	if opStatus.CompletionTime != nil {
+		completionTime = *opStatus.CompletionTime
+	}
+	restore.Status.PagesCompletedTime = lo.ToPtr(metav1.NewTime(completionTime))
+	return true, nil
+}
+
+// +kubebuilder:rbac:groups=kaito.sh,resources=restores,verbs=list;watch;get;patch
 // +kubebuilder:rbac:groups=kaito.sh,resources=restores/status,verbs=update
 // +kubebuilder:rbac:groups=kaito.sh,resources=checkpoints,verbs=get
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=list;watch;get;create;delete
-// +kubebuilder:rbac:groups="",resources=pods,verbs=list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;create
 
 func (c *Controller) Register(_ context.Context, m manager.Manager) error {
 	return controllerruntime.NewControllerManagedBy(m).
@@ -13,10 +13,12 @@ import (
 	"golang.org/x/time/rate"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
 	"knative.dev/pkg/webhook/certificates/resources"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -35,6 +37,7 @@ import (
 const (
 	girtManagerValidatingWebhookConfig = "grit-manager-validating-webhook-configuration"
 	girtManagerMutatingWebhookConfig   = "grit-manager-mutating-webhook-configuration"
+	podMutatingWebhookName             = "mutating.pods.k8s.io"
 )
 
 var (
@@ -90,9 +93,36 @@ type Controller struct {
 	webhookServerSecretName string
 	webhookServiceName      string
 	expirationDuration      time.Duration
+	podNamespaceSelector    *metav1.LabelSelector
+	podObjectSelector       *metav1.LabelSelector
+	podFailurePolicy        admissionv1.FailurePolicyType
 }
 
-func NewController(clk clock.Clock, kubeClient client.Client, ns, secretName, serviceName string, expirationDuration time.Duration) *Controller {
+func NewController(clk clock.Clock, kubeClient client.Client, ns, secretName, serviceName string, expirationDuration time.Duration, podNamespaceSelector, podObjectSelector *metav1.LabelSelector) *Controller {
+	// pod webhook rejects pod creation when it's unavailable only if pods are opted in by selectors, otherwise
+	// every pod creation in the cluster would depend on grit-manager, and pods are created without restoring
+	// when grit-manager is unavailable.
+	podFailurePolicy := admissionv1.Ignore
+	if podNamespaceSelector != nil || podObjectSelector != nil {
+		podFailurePolicy = admissionv1.Fail
+	}
+
+	// empty selector matches everything, and it's the default value of webhook selectors.
+	if podNamespaceSelector == nil {
+		podNamespaceSelector = &metav1.LabelSelector{}
+	}
+	if podObjectSelector == nil {
+		podObjectSelector = &metav1.LabelSelector{}
+	}
+	// pods of grit-manager itself must not be intercepted, otherwise grit-manager can never be recreated when
+	// pod webhook fails pod creation. system pods in kube-system are never restoration pods.
+	podNamespaceSelector = podNamespaceSelector.DeepCopy()
+	podNamespaceSelector.MatchExpressions = append(podNamespaceSelector.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      corev1.LabelMetadataName,
+		Operator: metav1.LabelSelectorOpNotIn,
+		Values:   []string{ns, metav1.NamespaceSystem},
+	})
+
 	return &Controller{
 		clock:                   clk,
 		Client:                  kubeClient,
@@ -100,6 +130,9 @@ func NewController(clk clock.Clock, kubeClient client.Client, ns, secretName, se
 		webhookServerSecretName: secretName,
 		webhookServiceName:      serviceName,
 		expirationDuration:      expirationDuration,
+		podNamespaceSelector:    podNamespaceSelector,
+		podObjectSelector:       podObjectSelector,
+		podFailurePolicy:        podFailurePolicy,
 	}
 }
 
@@ -220,6 +253,17 @@ func (c *Controller) updateWebhookConfigurations(ctx context.Context, caCert []b
 			mutatingWebhook.Webhooks[i].ClientConfig.Service.Name = c.webhookServiceName
 			updated = true
 		}
+
+		// only opted-in pods are intercepted by pod webhook
+		if mutatingWebhook.Webhooks[i].Name == podMutatingWebhookName &&
+			(!equality.Semantic.DeepEqual(mutatingWebhook.Webhooks[i].NamespaceSelector, c.podNamespaceSelector) ||
+				!equality.Semantic.DeepEqual(mutatingWebhook.Webhooks[i].ObjectSelector, c.podObjectSelector) ||
+				!equality.Semantic.DeepEqual(mutatingWebhook.Webhooks[i].FailurePolicy, &c.podFailurePolicy)) {
+			mutatingWebhook.Webhooks[i].NamespaceSelector = c.podNamespaceSelector.DeepCopy()
+			mutatingWebhook.Webhooks[i].ObjectSelector = c.podObjectSelector.DeepCopy()
+			mutatingWebhook.Webhooks[i].FailurePolicy = ptr.To(c.podFailurePolicy)
+			updated = true
+		}
 	}
 
 	if updated {
@@ -0,0 +1,171 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+)
+
+// podSpecFields are pod spec fields of a container which matter for restoring.
+var podSpecFields = map[v1alpha1.PodSpecField]func(c *corev1.Container) interface{}{
+	v1alpha1.PodSpecFieldImage: func(c *corev1.Container) interface{} {
+		return c.Image
+	},
+	v1alpha1.PodSpecFieldCommand: func(c *corev1.Container) interface{} {
+		return struct {
+			Command    []string `json:"command,omitempty"`
+			Args       []string `json:"args,omitempty"`
+			WorkingDir string   `json:"workingDir,omitempty"`
+		}{c.Command, c.Args, c.WorkingDir}
+	},
+	v1alpha1.PodSpecFieldVolumeMounts: func(c *corev1.Container) interface{} {
+		// kube-api-access volume has a random name, and it's excluded.
+		mounts := make([]corev1.VolumeMount, 0, len(c.VolumeMounts))
+		for _, m := range c.VolumeMounts {
+			if !strings.HasPrefix(m.Name, KubeAPIAccessNamePrefix) {
+				mounts = append(mounts, m)
+			}
+		}
+		sort.Slice(mounts, func(i, j int) bool { return mounts[i].MountPath < mounts[j].MountPath })
+		return mounts
+	},
+	v1alpha1.PodSpecFieldDevices: func(c *corev1.Container) interface{} {
+		// devices are exposed by volume devices and extended resources, like nvidia.com/gpu.
+		resources := make(map[string]string)
+		for name, quantity := range c.Resources.Limits {
+			if strings.Contains(string(name), "/") {
+				resources[string(name)] = quantity.String()
+			}
+		}
+		devices := append([]corev1.VolumeDevice{}, c.VolumeDevices...)
+		sort.Slice(devices, func(i, j int) bool { return devices[i].DevicePath < devices[j].DevicePath })
+		return struct {
+			VolumeDevices []corev1.VolumeDevice `json:"volumeDevices,omitempty"`
+			Resources     map[string]string     `json:"resources,omitempty"`
+		}{devices, resources}
+	},
+}
+
+// PodSpecFieldDigests returns canonical digests of pod spec fields which matter for restoring, the key is
+// <container name>/<field>. fields which are not related to process state, like resource requests and
+// tolerations, are excluded, so they are allowed to differ between checkpointed pod and restoration pod.
+// sidecar containers are included because they are running and checkpointed with regular containers. the
+// spec should be unpinned by UnpinnedPodSpec for restoration pods, so images pinned by digest are not
+// regarded as changes.
+func PodSpecFieldDigests(spec *corev1.PodSpec) map[string]string {
+	digests := make(map[string]string)
+	for _, container := range CheckpointedContainers(spec) {
+		for field, fn := range podSpecFields {
+			data, _ := json.Marshal(fn(container))
+			sum := sha256.Sum256(data)
+			digests[fieldKey(container.Name, field)] = hex.EncodeToString(sum[:])
+		}
+	}
+	return digests
+}
+
+// CheckpointedContainers returns containers which are running while the pod is checkpointed, they are regular
+// containers and sidecar containers(init containers with Always restart policy). other init containers have
+// exited before the pod is checkpointed.
+func CheckpointedContainers(spec *corev1.PodSpec) []*corev1.Container {
+	containers := make([]*corev1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	for i := range spec.InitContainers {
+		if IsSidecarContainer(&spec.InitContainers[i]) {
+			containers = append(containers, &spec.InitContainers[i])
+		}
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+	return containers
+}
+
+func IsSidecarContainer(container *corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// UnpinnedPodSpec returns a copy of pod spec with images before they are pinned by digest for restoration pod.
+func UnpinnedPodSpec(pod *corev1.Pod) *corev1.PodSpec {
+	spec := pod.Spec.DeepCopy()
+	originals := OriginalImages(pod)
+	if len(originals) == 0 {
+		return spec
+	}
+	for _, container := range CheckpointedContainers(spec) {
+		if image, ok := originals[container.Name]; ok {
+			container.Image = image
+		}
+	}
+	return spec
+}
+
+// PodSpecDigest returns a canonical digest of all field digests.
+func PodSpecDigest(digests map[string]string) string {
+	keys := lo.Keys(digests)
+	sort.Strings(keys)
+
+	hasher := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(hasher, "%s=%s\n", k, digests[k])
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// CheckPodSpecCompatibility compares pod spec of restoration pod with field digests of checkpointed pod,
+// fields in allowed list are skipped. reasons of incompatibility are returned, and empty list means
+// the pod is compatible.
+func CheckPodSpecCompatibility(checkpointed map[string]string, spec *corev1.PodSpec, allowed []v1alpha1.PodSpecField) []string {
+	current := PodSpecFieldDigests(spec)
+	if PodSpecDigest(checkpointed) == PodSpecDigest(current) {
+		return nil
+	}
+
+	allowedFields := make(map[v1alpha1.PodSpecField]bool, len(allowed))
+	for _, field := range allowed {
+		allowedFields[field] = true
+	}
+
+	var reasons []string
+	keys := lo.Union(lo.Keys(checkpointed), lo.Keys(current))
+	sort.Strings(keys)
+	for _, key := range keys {
+		oldDigest, inCheckpoint := checkpointed[key]
+		newDigest, inPod := current[key]
+		containerName, field := splitFieldKey(key)
+		switch {
+		case !inPod:
+			reasons = append(reasons, fmt.Sprintf("container(%s) is missing in restoration pod", containerName))
+		case !inCheckpoint:
+			// additional containers like injected sidecars are started from scratch.
+			if !allowedFields[v1alpha1.PodSpecFieldAdditionalContainers] {
+				reasons = append(reasons, fmt.Sprintf("container(%s) doesn't exist in checkpointed pod", containerName))
+			}
+		case oldDigest != newDigest && !allowedFields[field]:
+			reasons = append(reasons, fmt.Sprintf("%s of container(%s) is different from checkpointed pod", field, containerName))
+		}
+	}
+	return lo.Uniq(reasons)
+}
+
+func fieldKey(containerName string, field v1alpha1.PodSpecField) string {
+	return containerName + "/" + string(field)
+}
+
+func splitFieldKey(key string) (string, v1alpha1.PodSpecField) {
+	idx := strings.LastIndex(key, "/")
+	if idx < 0 {
+		return key, ""
+	}
+	return key[:idx], v1alpha1.PodSpecField(key[idx+1:])
+}
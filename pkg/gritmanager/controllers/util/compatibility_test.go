@@ -0,0 +1,141 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+)
+
+func TestCheckPodSpecCompatibility(t *testing.T) {
+	newSpec := func() *corev1.PodSpec {
+		return &corev1.PodSpec{
+			NodeName: "node-1",
+			Containers: []corev1.Container{
+				{
+					Name:    "app",
+					Image:   "nginx:latest",
+					Command: []string{"nginx"},
+					VolumeMounts: []corev1.VolumeMount{
+						{Name: "data", MountPath: "/data"},
+						{Name: "kube-api-access-abcde", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"},
+					},
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("1"),
+							"nvidia.com/gpu":      resource.MustParse("1"),
+							corev1.ResourceMemory: resource.MustParse("1Gi"),
+						},
+					},
+				},
+			},
+		}
+	}
+	checkpointed := PodSpecFieldDigests(newSpec())
+
+	t.Run("fields which don't matter for restoring are changed", func(t *testing.T) {
+		spec := newSpec()
+		spec.NodeName = "node-2"
+		spec.Tolerations = []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+		spec.Containers[0].Resources.Limits[corev1.ResourceCPU] = resource.MustParse("2")
+		spec.Containers[0].VolumeMounts[1].Name = "kube-api-access-fghij"
+
+		if reasons := CheckPodSpecCompatibility(checkpointed, spec, nil); len(reasons) != 0 {
+			t.Fatalf("expected compatible pod spec, got %v", reasons)
+		}
+	})
+
+	t.Run("image and devices are changed", func(t *testing.T) {
+		spec := newSpec()
+		spec.Containers[0].Image = "nginx:1.27"
+		spec.Containers[0].Resources.Limits["nvidia.com/gpu"] = resource.MustParse("2")
+
+		expected := []string{
+			"Devices of container(app) is different from checkpointed pod",
+			"Image of container(app) is different from checkpointed pod",
+		}
+		if reasons := CheckPodSpecCompatibility(checkpointed, spec, nil); !reflect.DeepEqual(reasons, expected) {
+			t.Fatalf("expected %v, got %v", expected, reasons)
+		}
+	})
+
+	t.Run("image is allowed to differ", func(t *testing.T) {
+		spec := newSpec()
+		spec.Containers[0].Image = "nginx:1.27"
+
+		if reasons := CheckPodSpecCompatibility(checkpointed, spec, []v1alpha1.PodSpecField{v1alpha1.PodSpecFieldImage}); len(reasons) != 0 {
+			t.Fatalf("expected compatible pod spec, got %v", reasons)
+		}
+	})
+
+	t.Run("sidecar container is injected", func(t *testing.T) {
+		spec := newSpec()
+		spec.Containers = append(spec.Containers, corev1.Container{Name: "sidecar", Image: "envoy"})
+
+		expected := []string{"container(sidecar) doesn't exist in checkpointed pod"}
+		if reasons := CheckPodSpecCompatibility(checkpointed, spec, nil); !reflect.DeepEqual(reasons, expected) {
+			t.Fatalf("expected %v, got %v", expected, reasons)
+		}
+	})
+
+	t.Run("injected sidecar container is allowed", func(t *testing.T) {
+		spec := newSpec()
+		spec.Containers = append(spec.Containers, corev1.Container{Name: "sidecar", Image: "envoy"})
+
+		if reasons := CheckPodSpecCompatibility(checkpointed, spec, []v1alpha1.PodSpecField{v1alpha1.PodSpecFieldAdditionalContainers}); len(reasons) != 0 {
+			t.Fatalf("expected compatible pod spec, got %v", reasons)
+		}
+
+		// checkpointed containers are still required and compared.
+		spec.Containers[0].Image = "nginx:1.27"
+		expected := []string{"Image of container(app) is different from checkpointed pod"}
+		if reasons := CheckPodSpecCompatibility(checkpointed, spec, []v1alpha1.PodSpecField{v1alpha1.PodSpecFieldAdditionalContainers}); !reflect.DeepEqual(reasons, expected) {
+			t.Fatalf("expected %v, got %v", expected, reasons)
+		}
+	})
+
+	t.Run("image of sidecar container is changed", func(t *testing.T) {
+		withSidecar := func() *corev1.PodSpec {
+			spec := newSpec()
+			spec.InitContainers = []corev1.Container{
+				{Name: "init", Image: "busybox"},
+				{Name: "proxy", Image: "envoy:v1", RestartPolicy: ptr.To(corev1.ContainerRestartPolicyAlways)},
+			}
+			return spec
+		}
+		checkpointed := PodSpecFieldDigests(withSidecar())
+
+		spec := withSidecar()
+		spec.InitContainers[0].Image = "busybox:1.36"
+		if reasons := CheckPodSpecCompatibility(checkpointed, spec, nil); len(reasons) != 0 {
+			t.Fatalf("expected init container which has exited to be ignored, got %v", reasons)
+		}
+
+		spec.InitContainers[1].Image = "envoy:v2"
+		expected := []string{"Image of container(proxy) is different from checkpointed pod"}
+		if reasons := CheckPodSpecCompatibility(checkpointed, spec, nil); !reflect.DeepEqual(reasons, expected) {
+			t.Fatalf("expected %v, got %v", expected, reasons)
+		}
+	})
+
+	t.Run("restoration pod is checkpointed again", func(t *testing.T) {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha1.OriginalImagesAnnotation: `{"app":"nginx:latest"}`}},
+			Spec:       *newSpec(),
+		}
+		pod.Spec.Containers[0].Image = "docker.io/library/nginx@sha256:1234"
+
+		// the next restoration pod is created from the same template as the first one.
+		if reasons := CheckPodSpecCompatibility(PodSpecFieldDigests(UnpinnedPodSpec(pod)), newSpec(), nil); len(reasons) != 0 {
+			t.Fatalf("expected compatible pod spec, got %v", reasons)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package util
+
+import (
+	"sort"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// PrestageCandidateNodes returns at most maxNodes nodes which a new pod of the checkpointed pod is likely to be
+// scheduled to. candidate nodes are ready and schedulable nodes which match node selector, required node affinity
+// and tolerations of the pod, the node where the pod is located is excluded. nodes are sorted by preferred node
+// affinity of the pod, then by name.
+func PrestageCandidateNodes(pod *corev1.Pod, nodes []corev1.Node, maxNodes int) []string {
+	candidates := lo.Filter(nodes, func(node corev1.Node, _ int) bool {
+		return node.Name != pod.Spec.NodeName &&
+			!node.Spec.Unschedulable &&
+			node.DeletionTimestamp.IsZero() &&
+			isNodeReady(&node) &&
+			matchNodeSelector(pod, &node) &&
+			matchRequiredNodeAffinity(pod, &node) &&
+			toleratesNodeTaints(pod, &node)
+	})
+
+	scores := make(map[string]int32, len(candidates))
+	for i := range candidates {
+		scores[candidates[i].Name] = preferredNodeAffinityScore(pod, &candidates[i])
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if scores[candidates[i].Name] != scores[candidates[j].Name] {
+			return scores[candidates[i].Name] > scores[candidates[j].Name]
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	names := lo.Map(candidates, func(node corev1.Node, _ int) string { return node.Name })
+	if len(names) > maxNodes {
+		names = names[:maxNodes]
+	}
+	return names
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	return lo.ContainsBy(node.Status.Conditions, func(cond corev1.NodeCondition) bool {
+		return cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue
+	})
+}
+
+func matchNodeSelector(pod *corev1.Pod, node *corev1.Node) bool {
+	return labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels))
+}
+
+func matchRequiredNodeAffinity(pod *corev1.Pod, node *corev1.Node) bool {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+
+	// node selector terms are ORed.
+	return lo.ContainsBy(pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms, func(term corev1.NodeSelectorTerm) bool {
+		return matchNodeSelectorTerm(&term, node)
+	})
+}
+
+func preferredNodeAffinityScore(pod *corev1.Pod, node *corev1.Node) int32 {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return 0
+	}
+
+	var score int32
+	for _, term := range pod.Spec.Affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if matchNodeSelectorTerm(&term.Preference, node) {
+			score += term.Weight
+		}
+	}
+	return score
+}
+
+// matchNodeSelectorTerm checks node matches all requirements in the term, empty term matches no node.
+func matchNodeSelectorTerm(term *corev1.NodeSelectorTerm, node *corev1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	return matchNodeSelectorRequirements(term.MatchExpressions, labels.Set(node.Labels)) &&
+		matchNodeSelectorRequirements(term.MatchFields, labels.Set{metav1.ObjectNameField: node.Name})
+}
+
+func matchNodeSelectorRequirements(requirements []corev1.NodeSelectorRequirement, set labels.Set) bool {
+	operators := map[corev1.NodeSelectorOperator]selection.Operator{
+		corev1.NodeSelectorOpIn:           selection.In,
+		corev1.NodeSelectorOpNotIn:        selection.NotIn,
+		corev1.NodeSelectorOpExists:       selection.Exists,
+		corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+		corev1.NodeSelectorOpGt:           selection.GreaterThan,
+		corev1.NodeSelectorOpLt:           selection.LessThan,
+	}
+
+	for _, req := range requirements {
+		op, ok := operators[req.Operator]
+		if !ok {
+			return false
+		}
+		requirement, err := labels.NewRequirement(req.Key, op, req.Values)
+		if err != nil || !requirement.Matches(set) {
+			return false
+		}
+	}
+	return true
+}
+
+// toleratesNodeTaints checks the pod tolerates all taints of the node which prevent the pod from being scheduled.
+func toleratesNodeTaints(pod *corev1.Pod, node *corev1.Node) bool {
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		if !lo.ContainsBy(pod.Spec.Tolerations, func(toleration corev1.Toleration) bool { return toleration.ToleratesTaint(taint) }) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPrestageCandidateNodes(t *testing.T) {
+	newNode := func(name string, nodeLabels map[string]string, taints ...corev1.Taint) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+			Spec:       corev1.NodeSpec{Taints: taints},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+	gpuTaint := corev1.Taint{Key: "nvidia.com/gpu", Effect: corev1.TaintEffectNoSchedule}
+
+	notReady := newNode("node-not-ready", map[string]string{"pool": "gpu"}, gpuTaint)
+	notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+	cordoned := newNode("node-cordoned", map[string]string{"pool": "gpu"}, gpuTaint)
+	cordoned.Spec.Unschedulable = true
+	nodes := []corev1.Node{
+		newNode("node-source", map[string]string{"pool": "gpu"}, gpuTaint),
+		newNode("node-b", map[string]string{"pool": "gpu", "zone": "1"}, gpuTaint),
+		newNode("node-a", map[string]string{"pool": "gpu", "zone": "2"}, gpuTaint),
+		newNode("node-c", map[string]string{"pool": "gpu", "zone": "1"}),
+		newNode("node-cpu", map[string]string{"pool": "cpu"}),
+		newNode("node-untolerated", map[string]string{"pool": "gpu"}, corev1.Taint{Key: "dedicated", Effect: corev1.TaintEffectNoExecute}),
+		notReady,
+		cordoned,
+	}
+
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{
+			Spec: corev1.PodSpec{
+				NodeName:     "node-source",
+				NodeSelector: map[string]string{"pool": "gpu"},
+				Tolerations:  []corev1.Toleration{{Key: "nvidia.com/gpu", Operator: corev1.TolerationOpExists}},
+			},
+		}
+	}
+
+	t.Run("nodes match node selector and tolerations", func(t *testing.T) {
+		got := PrestageCandidateNodes(newPod(), nodes, 10)
+		expected := []string{"node-a", "node-b", "node-c"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected candidate nodes %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("nodes match node affinity", func(t *testing.T) {
+		pod := newPod()
+		pod.Spec.Affinity = &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"1"}}}},
+						{MatchFields: []corev1.NodeSelectorRequirement{{Key: metav1.ObjectNameField, Operator: corev1.NodeSelectorOpIn, Values: []string{"node-a"}}}},
+					},
+				},
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{
+					{Weight: 10, Preference: corev1.NodeSelectorTerm{MatchFields: []corev1.NodeSelectorRequirement{{Key: metav1.ObjectNameField, Operator: corev1.NodeSelectorOpIn, Values: []string{"node-c"}}}}},
+				},
+			},
+		}
+
+		got := PrestageCandidateNodes(pod, nodes, 2)
+		expected := []string{"node-c", "node-a"}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected candidate nodes %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("no node matches", func(t *testing.T) {
+		pod := newPod()
+		pod.Spec.NodeSelector = map[string]string{"pool": "tpu"}
+		if got := PrestageCandidateNodes(pod, nodes, 1); len(got) != 0 {
+			t.Fatalf("expected no candidate node, got %v", got)
+		}
+	})
+}
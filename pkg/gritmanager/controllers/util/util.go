@@ -5,9 +5,12 @@ package util
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"hash/fnv"
 	"strings"
+	"sync"
+	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -22,6 +25,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
+	agentmetrics "github.com/kaito-project/grit/pkg/gritagent/metrics"
+	"github.com/kaito-project/grit/pkg/metadata"
 )
 
 const (
@@ -30,8 +36,26 @@ const (
 	CACert                  = "ce-cert.pem"
 	GritAgentJobNamePrefix  = "grit-agent-"
 	KubeAPIAccessNamePrefix = "kube-api-access-"
+
+	// GritAgentDaemonAcceptedReason is the condition reason when operation is submitted to grit agent daemon.
+	GritAgentDaemonAcceptedReason = "GritAgentDaemonAccepted"
 )
 
+// RequeueError is returned by state handlers when the resource should be reconciled again after a period,
+// like waiting for an operation of grit agent daemon to complete. status of resource will still be updated.
+type RequeueError struct {
+	After  time.Duration
+	Reason string
+}
+
+func (e *RequeueError) Error() string {
+	return fmt.Sprintf("requeue after %v: %s", e.After, e.Reason)
+}
+
+func RequeueAfter(after time.Duration, reason string) error {
+	return &RequeueError{After: after, Reason: reason}
+}
+
 type controllerNameKeyType struct{}
 type webhookNameKeyType struct{}
 
@@ -82,8 +106,14 @@ var (
 
 			return IsRestorationPod(pod)
 		},
+		// restoration pod may be deleted before restoring starts, and the binding of restore should be released.
 		DeleteFunc: func(e event.DeleteEvent) bool {
-			return false
+			pod, ok := e.Object.(*corev1.Pod)
+			if !ok {
+				return false
+			}
+
+			return IsRestorationPod(pod)
 		},
 	}
 
@@ -113,8 +143,32 @@ func GritAgentJobName(ckpt *v1alpha1.Checkpoint, restore *v1alpha1.Restore) stri
 	return ""
 }
 
+// FanOutGritAgentJobName returns the name of grit agent job which downloads checkpointed data on the node
+// for restore in FanOut mode.
+func FanOutGritAgentJobName(restore *v1alpha1.Restore, nodeName string) string {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(nodeName))
+	return fmt.Sprintf("%s%s-%d", GritAgentJobNamePrefix, restore.Name, hasher.Sum32())
+}
+
+// PrestageGritAgentJobName returns the name of grit agent job which pre-stages checkpointed data to the node,
+// or removes pre-staged data from the node.
+func PrestageGritAgentJobName(ckpt *v1alpha1.Checkpoint, action, nodeName string) string {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(nodeName))
+	return fmt.Sprintf("%s%s-%s-%d", GritAgentJobNamePrefix, ckpt.Name, action, hasher.Sum32())
+}
+
 func GritAgentJobOwnerName(job *batchv1.Job) string {
 	if job != nil {
+		// grit agent jobs which pre-stage checkpointed data are labeled with checkpoint name.
+		if ckptName, ok := job.Labels[v1alpha1.CheckpointNameLabel]; ok {
+			return ckptName
+		}
+		// grit agent jobs of restore in FanOut mode are labeled with restore name.
+		if restoreName, ok := job.Labels[v1alpha1.RestoreNameLabel]; ok {
+			return restoreName
+		}
 		if strings.HasPrefix(job.Name, GritAgentJobNamePrefix) {
 			return strings.TrimPrefix(job.Name, GritAgentJobNamePrefix)
 		}
@@ -162,10 +216,217 @@ func ComputeHash(spec *corev1.PodSpec) string {
 	return fmt.Sprint(hasher.Sum32())
 }
 
+// ContainerImages returns images of containers in the pod, image digest is resolved from the image id
+// in container status which is reported by container runtime. images of restoration pod are pinned by
+// digest, the original images recorded in annotations are returned for them, so the pod can be restored
+// again by pods created from the same template.
+func ContainerImages(pod *corev1.Pod) []v1alpha1.ContainerImage {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	containers := CheckpointedContainers(UnpinnedPodSpec(pod))
+	images := make([]v1alpha1.ContainerImage, 0, len(containers))
+	for _, container := range containers {
+		image := v1alpha1.ContainerImage{
+			Name:  container.Name,
+			Image: container.Image,
+		}
+		for _, status := range statuses {
+			if status.Name == container.Name {
+				image.ImageDigest = metadata.DigestReference(status.ImageID)
+				break
+			}
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+// OriginalImages returns images of containers before they are pinned by digest, it's nil for pods which
+// are not restoration pods.
+func OriginalImages(pod *corev1.Pod) map[string]string {
+	value, ok := pod.Annotations[v1alpha1.OriginalImagesAnnotation]
+	if !ok {
+		return nil
+	}
+
+	var images map[string]string
+	if err := json.Unmarshal([]byte(value), &images); err != nil {
+		return nil
+	}
+	return images
+}
+
+// SameImage checks whether the image in pod spec is the checkpointed image, images pinned by digest are
+// compared by the digest, so a reference of the same image with another repository name is also matched.
+func SameImage(podImage string, image v1alpha1.ContainerImage) bool {
+	if podImage == image.Image || podImage == image.ImageDigest {
+		return true
+	}
+	digest := metadata.ImageDigest(podImage)
+	return len(digest) != 0 && digest == metadata.ImageDigest(image.ImageDigest)
+}
+
+// ResolvePriority returns the priority which queued checkpoint or restore is ordered by. priority in spec is used
+// if it's specified, otherwise priority of the pod which is resolved from its PriorityClass by kube-apiserver is used.
+func ResolvePriority(priority *int32, pod *corev1.Pod) int32 {
+	if priority != nil {
+		return *priority
+	}
+	if pod != nil && pod.Spec.Priority != nil {
+		return *pod.Spec.Priority
+	}
+	return 0
+}
+
 func IsGritAgentJob(job *batchv1.Job) bool {
 	return job.Labels[v1alpha1.GritAgentLabel] == v1alpha1.GritAgentName
 }
 
+func JobCompletedOrFailed(job *batchv1.Job) (bool, bool) {
+	if job == nil {
+		return false, false
+	}
+
+	if job.Status.Succeeded > 0 {
+		return true, false
+	}
+
+	if job.Status.Failed > 0 {
+		return false, true
+	}
+
+	for _, cond := range job.Status.Conditions {
+		if cond.Type == batchv1.JobComplete && cond.Status == "True" {
+			return true, false
+		}
+
+		if cond.Type == batchv1.JobFailed && cond.Status == "True" {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// GritAgentJobResults returns results of terminated grit agent pods of the job, grit agent writes the result of the
+// operation into termination message. termination message of previous grit agent is the failure reason in plain text.
+func GritAgentJobResults(ctx context.Context, c client.Reader, job *batchv1.Job) []*api.JobResult {
+	results, _ := listGritAgentJobResults(ctx, c, job)
+	return results
+}
+
+func listGritAgentJobResults(ctx context.Context, c client.Reader, job *batchv1.Job) ([]*api.JobResult, error) {
+	var podList corev1.PodList
+	if err := c.List(ctx, &podList, client.InNamespace(job.Namespace), client.MatchingLabels{batchv1.JobNameLabel: job.Name}); err != nil {
+		return nil, err
+	}
+
+	var results []*api.JobResult
+	for i := range podList.Items {
+		for _, status := range podList.Items[i].Status.ContainerStatuses {
+			if terminated := status.State.Terminated; terminated != nil {
+				if result := parseJobResult(terminated.Message, terminated.ExitCode); result != nil {
+					results = append(results, result)
+				}
+			}
+		}
+	}
+	return results, nil
+}
+
+func parseJobResult(message string, exitCode int32) *api.JobResult {
+	if len(message) == 0 {
+		return nil
+	}
+
+	var result api.JobResult
+	if err := json.Unmarshal([]byte(message), &result); err != nil {
+		if exitCode == 0 {
+			return nil
+		}
+		return &api.JobResult{Message: strings.TrimSpace(message)}
+	}
+	return &result
+}
+
+// GritAgentTerminationMessage returns the failure reason of failed grit agent pod, grit agent writes the failure
+// reason into termination message when checkpoint or restore failed.
+func GritAgentTerminationMessage(ctx context.Context, c client.Reader, job *batchv1.Job) string {
+	for _, result := range GritAgentJobResults(ctx, c, job) {
+		if len(result.Message) != 0 {
+			return result.Message
+		}
+	}
+	return ""
+}
+
+// GritAgentEstimatedSize returns the estimated size of checkpointed data which is reported by grit agent pod of
+// checkpoint, 0 is returned if it's not reported.
+func GritAgentEstimatedSize(ctx context.Context, c client.Reader, job *batchv1.Job) int64 {
+	var size int64
+	for _, result := range GritAgentJobResults(ctx, c, job) {
+		if result.EstimatedSize > 0 {
+			size = result.EstimatedSize
+		}
+	}
+	return size
+}
+
+// DeleteGritAgentJob deletes grit agent job after the operation is finished. metrics reported by grit agent pods
+// of the job are recorded before the job is deleted, because the pods are removed with the job. they're recorded
+// only once for each job, so deleting the job can be retried, and the job being deleted is still recorded if its
+// metrics haven't been recorded, like when the delete request failed after the job is marked as deleted.
+func DeleteGritAgentJob(ctx context.Context, c client.Client, job *batchv1.Job) error {
+	if err := jobMetrics.record(ctx, c, job, time.Now()); err != nil {
+		return err
+	}
+	if !job.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	deletePolicy := metav1.DeletePropagationForeground
+	return c.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &deletePolicy})
+}
+
+// recordedJobTTL is how long uid of grit agent job is remembered after its metrics are recorded, the job is
+// removed within it.
+const recordedJobTTL = time.Hour
+
+var jobMetrics = &jobMetricsRecorder{observe: agentmetrics.ObserveOperationMetrics, recorded: make(map[types.UID]time.Time)}
+
+// jobMetricsRecorder records metrics reported by grit agent jobs, uids of recorded jobs are remembered, so
+// metrics of a job are never recorded twice.
+type jobMetricsRecorder struct {
+	observe func(*api.OperationMetrics)
+
+	mu       sync.Mutex
+	recorded map[types.UID]time.Time
+}
+
+func (r *jobMetricsRecorder) record(ctx context.Context, c client.Reader, job *batchv1.Job, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for uid, recordedTime := range r.recorded {
+		if now.Sub(recordedTime) > recordedJobTTL {
+			delete(r.recorded, uid)
+		}
+	}
+	if _, ok := r.recorded[job.UID]; ok {
+		return nil
+	}
+
+	results, err := listGritAgentJobResults(ctx, c, job)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if result.Metrics != nil {
+			r.observe(result.Metrics)
+		}
+	}
+	r.recorded[job.UID] = now
+	return nil
+}
+
 func IsRestorationPod(pod *corev1.Pod) bool {
 	return len(pod.Annotations[v1alpha1.CheckpointDataPathLabel]) != 0
 }
@@ -203,6 +464,34 @@ func UpdateCondition(clk clock.Clock, conditions *[]metav1.Condition, status met
 	*conditions = append(*conditions, newCondition)
 }
 
+func GetCondition(conditions []metav1.Condition, conditionType string) *metav1.Condition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// IsAcceptedByAgentDaemon checks whether operation of the phase is handled by grit agent daemon instead of grit agent job.
+func IsAcceptedByAgentDaemon(conditions []metav1.Condition, phase string) bool {
+	cond := GetCondition(conditions, phase)
+	return cond != nil && cond.Reason == GritAgentDaemonAcceptedReason
+}
+
+// PhaseEvent resolves type and message of the event for the phase of checkpoint or restore from the condition of
+// the phase. the event is a warning if the phase is Failed.
+func PhaseEvent(conditions []metav1.Condition, phase, kind string) (string, string) {
+	eventType := corev1.EventTypeNormal
+	if phase == string(v1alpha1.CheckpointFailed) {
+		eventType = corev1.EventTypeWarning
+	}
+	if cond := GetCondition(conditions, phase); cond != nil && len(cond.Message) != 0 {
+		return eventType, cond.Message
+	}
+	return eventType, fmt.Sprintf("%s is %s", kind, phase)
+}
+
 func RemoveCondition(conditions *[]metav1.Condition, conditionType string) {
 	for i, cond := range *conditions {
 		if cond.Type == conditionType {
@@ -0,0 +1,130 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package util
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	"github.com/kaito-project/grit/pkg/gritagent/api"
+)
+
+func TestParseJobResult(t *testing.T) {
+	t.Run("result of grit agent job", func(t *testing.T) {
+		message := `{"message":"insufficient disk space","estimatedSize":1024,"metrics":{"podFreezeSeconds":1.5}}`
+		expected := &api.JobResult{Message: "insufficient disk space", EstimatedSize: 1024, Metrics: &api.OperationMetrics{PodFreezeSeconds: 1.5}}
+		if result := parseJobResult(message, 1); !reflect.DeepEqual(result, expected) {
+			t.Fatalf("expected %+v, but got %+v", expected, result)
+		}
+	})
+
+	t.Run("failure reason of previous grit agent", func(t *testing.T) {
+		result := parseJobResult("failed to checkpoint pod\n", 1)
+		if result == nil || result.Message != "failed to checkpoint pod" {
+			t.Fatalf("expected plain text is the failure reason, but got %+v", result)
+		}
+	})
+
+	t.Run("no result", func(t *testing.T) {
+		if result := parseJobResult("", 1); result != nil {
+			t.Fatalf("expected no result for empty termination message, but got %+v", result)
+		}
+		if result := parseJobResult("completed", 0); result != nil {
+			t.Fatalf("expected no result for unknown termination message of completed pod, but got %+v", result)
+		}
+	})
+}
+
+func TestContainerImages(t *testing.T) {
+	digest := "docker.io/library/nginx@sha256:1234"
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:latest"}}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "app", ImageID: "docker.io/library/nginx@sha256:1234"},
+		}},
+	}
+
+	t.Run("pod is not a restoration pod", func(t *testing.T) {
+		expected := []v1alpha1.ContainerImage{{Name: "app", Image: "nginx:latest", ImageDigest: digest}}
+		if images := ContainerImages(pod); !reflect.DeepEqual(images, expected) {
+			t.Fatalf("expected %+v, but got %+v", expected, images)
+		}
+	})
+
+	t.Run("image of restoration pod is pinned by digest", func(t *testing.T) {
+		restored := pod.DeepCopy()
+		restored.ObjectMeta = metav1.ObjectMeta{Annotations: map[string]string{v1alpha1.OriginalImagesAnnotation: `{"app":"nginx:latest"}`}}
+		restored.Spec.Containers[0].Image = digest
+		expected := []v1alpha1.ContainerImage{{Name: "app", Image: "nginx:latest", ImageDigest: digest}}
+		if images := ContainerImages(restored); !reflect.DeepEqual(images, expected) {
+			t.Fatalf("expected %+v, but got %+v", expected, images)
+		}
+	})
+}
+
+func TestSameImage(t *testing.T) {
+	image := v1alpha1.ContainerImage{Name: "app", Image: "nginx:latest", ImageDigest: "docker.io/library/nginx@sha256:1234"}
+	cases := map[string]bool{
+		"nginx:latest":                        true,
+		"docker.io/library/nginx@sha256:1234": true,
+		"nginx@sha256:1234":                   true,
+		"nginx:1.27":                          false,
+		"nginx@sha256:5678":                   false,
+	}
+	for podImage, expected := range cases {
+		t.Run(podImage, func(t *testing.T) {
+			if same := SameImage(podImage, image); same != expected {
+				t.Fatalf("expected %v, but got %v", expected, same)
+			}
+		})
+	}
+}
+
+func TestJobMetricsRecorder(t *testing.T) {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "grit-agent-ckpt", Namespace: "default", UID: types.UID("job-uid")}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "grit-agent-ckpt-abcde", Namespace: "default", Labels: map[string]string{batchv1.JobNameLabel: job.Name}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: `{"metrics":{"podFreezeSeconds":1.5}}`}},
+		}}},
+	}
+	c := fake.NewClientBuilder().WithObjects(job, pod).Build()
+
+	var observed int
+	r := &jobMetricsRecorder{observe: func(*api.OperationMetrics) { observed++ }, recorded: make(map[types.UID]time.Time)}
+	now := time.Now()
+
+	t.Run("metrics are recorded once for each job", func(t *testing.T) {
+		if err := r.record(context.Background(), c, job, now); err != nil {
+			t.Fatalf("failed to record metrics, %v", err)
+		}
+		// job is being deleted after the previous delete request failed.
+		deleting := job.DeepCopy()
+		deleting.DeletionTimestamp = &metav1.Time{Time: now}
+		if err := r.record(context.Background(), c, deleting, now.Add(time.Minute)); err != nil {
+			t.Fatalf("failed to record metrics, %v", err)
+		}
+		if observed != 1 {
+			t.Fatalf("expected metrics are recorded once, but recorded %d times", observed)
+		}
+	})
+
+	t.Run("recorded job is forgotten after ttl", func(t *testing.T) {
+		if err := r.record(context.Background(), c, &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", UID: types.UID("other-uid")}}, now.Add(2*recordedJobTTL)); err != nil {
+			t.Fatalf("failed to record metrics, %v", err)
+		}
+		if _, ok := r.recorded[job.UID]; ok {
+			t.Fatalf("expected job %s is forgotten after ttl", job.Name)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package metrics defines prometheus metrics of checkpoint and restore lifecycle in grit-manager, they are served
+// by the metrics endpoint of controller-runtime manager together with metrics reported by grit agent jobs.
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+	agentmetrics "github.com/kaito-project/grit/pkg/gritagent/metrics"
+)
+
+const (
+	KindCheckpoint = "checkpoint"
+	KindRestore    = "restore"
+)
+
+var (
+	PhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grit_manager_phase_duration_seconds",
+		Help:    "Duration of checkpoint and restore phases, it's recorded when checkpoint or restore leaves the phase.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"kind", "phase"})
+	Failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grit_manager_failures_total",
+		Help: "Number of failed checkpoints and restores by the reason of Failed condition.",
+	}, []string{"kind", "reason"})
+
+	inFlightDesc = prometheus.NewDesc(
+		"grit_manager_inflight_operations",
+		"Number of checkpoints and restores which are in progress by phase.",
+		[]string{"kind", "phase"}, nil,
+	)
+	inFlightCheckpointPhases = []v1alpha1.CheckpointPhase{v1alpha1.CheckpointCreated, v1alpha1.CheckpointPending, v1alpha1.CheckpointQueued, v1alpha1.Checkpointing, v1alpha1.AutoMigrationSubmitting}
+	inFlightRestorePhases    = []v1alpha1.RestorePhase{v1alpha1.RestoreCreated, v1alpha1.RestorePending, v1alpha1.RestoreQueued, v1alpha1.Restoring}
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(PhaseDuration, Failures)
+	// metrics of grit agent jobs are reported to grit-manager and recorded into grit agent metrics.
+	crmetrics.Registry.MustRegister(agentmetrics.Collectors()...)
+}
+
+// RegisterInFlightCollector registers the collector which counts checkpoints and restores in progress from
+// informer cache when metrics are scraped.
+func RegisterInFlightCollector(c client.Reader) error {
+	return crmetrics.Registry.Register(&inFlightCollector{client: c})
+}
+
+// ObservePhaseTransition records the duration of previous phase and the reason of failure after phase of checkpoint
+// or restore is changed. the duration is measured from the transition time of the condition of previous phase.
+// Queued is a sub-state of Pending, so the time in queue is counted into Pending phase.
+func ObservePhaseTransition(kind, oldPhase, newPhase string, oldConditions, newConditions []metav1.Condition, now time.Time) {
+	if oldPhase == newPhase || newPhase == string(v1alpha1.CheckpointQueued) {
+		return
+	}
+
+	if oldPhase == string(v1alpha1.CheckpointQueued) {
+		oldPhase = string(v1alpha1.CheckpointPending)
+	}
+	if len(oldPhase) != 0 {
+		if cond := meta.FindStatusCondition(oldConditions, oldPhase); cond != nil {
+			PhaseDuration.WithLabelValues(kind, strings.ToLower(oldPhase)).Observe(now.Sub(cond.LastTransitionTime.Time).Seconds())
+		}
+	}
+
+	if newPhase == string(v1alpha1.CheckpointFailed) {
+		reason := "Unknown"
+		if cond := meta.FindStatusCondition(newConditions, newPhase); cond != nil {
+			reason = cond.Reason
+		}
+		Failures.WithLabelValues(kind, reason).Inc()
+	}
+}
+
+// inFlightCollector counts checkpoints and restores in progress. restore in FanOut mode is not counted because
+// it stays in Restoring phase until it's deleted.
+type inFlightCollector struct {
+	client client.Reader
+}
+
+func (c *inFlightCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- inFlightDesc
+}
+
+func (c *inFlightCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var ckptList v1alpha1.CheckpointList
+	if err := c.client.List(ctx, &ckptList); err != nil {
+		ch <- prometheus.NewInvalidMetric(inFlightDesc, err)
+		return
+	}
+	checkpoints := make(map[v1alpha1.CheckpointPhase]int)
+	for i := range ckptList.Items {
+		checkpoints[ckptList.Items[i].Status.Phase]++
+	}
+	for _, phase := range inFlightCheckpointPhases {
+		ch <- prometheus.MustNewConstMetric(inFlightDesc, prometheus.GaugeValue, float64(checkpoints[phase]), KindCheckpoint, strings.ToLower(string(phase)))
+	}
+
+	var restoreList v1alpha1.RestoreList
+	if err := c.client.List(ctx, &restoreList); err != nil {
+		ch <- prometheus.NewInvalidMetric(inFlightDesc, err)
+		return
+	}
+	restores := make(map[v1alpha1.RestorePhase]int)
+	for i := range restoreList.Items {
+		if restoreList.Items[i].Spec.Mode != v1alpha1.RestoreModeFanOut {
+			restores[restoreList.Items[i].Status.Phase]++
+		}
+	}
+	for _, phase := range inFlightRestorePhases {
+		ch <- prometheus.MustNewConstMetric(inFlightDesc, prometheus.GaugeValue, float64(restores[phase]), KindRestore, strings.ToLower(string(phase)))
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
+)
+
+func TestObservePhaseTransition(t *testing.T) {
+	now := time.Now()
+	pending := []metav1.Condition{{Type: string(v1alpha1.CheckpointPending), LastTransitionTime: metav1.NewTime(now.Add(-time.Minute))}}
+
+	t.Run("queued is counted into pending", func(t *testing.T) {
+		PhaseDuration.Reset()
+		ObservePhaseTransition(KindCheckpoint, string(v1alpha1.CheckpointPending), string(v1alpha1.CheckpointQueued), pending, pending, now)
+		if count := testutil.CollectAndCount(PhaseDuration); count != 0 {
+			t.Fatalf("expected no duration is recorded when checkpoint is queued, but got %d", count)
+		}
+		ObservePhaseTransition(KindCheckpoint, string(v1alpha1.CheckpointQueued), string(v1alpha1.Checkpointing), pending, pending, now)
+		if count := testutil.CollectAndCount(PhaseDuration, "grit_manager_phase_duration_seconds"); count != 1 {
+			t.Fatalf("expected duration of pending phase is recorded, but got %d", count)
+		}
+	})
+
+	t.Run("failure reason", func(t *testing.T) {
+		Failures.Reset()
+		failed := append(pending, metav1.Condition{Type: string(v1alpha1.CheckpointFailed), Reason: "InsufficientDiskSpace"})
+		ObservePhaseTransition(KindCheckpoint, string(v1alpha1.CheckpointPending), string(v1alpha1.CheckpointFailed), pending, failed, now)
+		if value := testutil.ToFloat64(Failures.WithLabelValues(KindCheckpoint, "InsufficientDiskSpace")); value != 1 {
+			t.Fatalf("expected one failure is counted, but got %v", value)
+		}
+	})
+}
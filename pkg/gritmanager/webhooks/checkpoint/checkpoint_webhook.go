@@ -62,14 +62,34 @@ func (w *CheckpointWebhook) ValidateCreate(ctx context.Context, obj runtime.Obje
 		return admission.Warnings{}, fmt.Errorf("node(%s) referenced by pod(%s) and checkpoint(%s) is not ready", node.Name, pod.Name, ckpt.Name)
 	}
 
-	//validate pvc
-	var pvc corev1.PersistentVolumeClaim
-	if err := w.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: ckpt.Spec.VolumeClaim.ClaimName}, &pvc); err != nil {
-		return admission.Warnings{}, err
+	//validate pvc, checkpointed data is kept on the node if pvc is not specified.
+	if ckpt.Spec.VolumeClaim != nil {
+		var pvc corev1.PersistentVolumeClaim
+		if err := w.Get(ctx, client.ObjectKey{Namespace: ckpt.Namespace, Name: ckpt.Spec.VolumeClaim.ClaimName}, &pvc); err != nil {
+			return admission.Warnings{}, err
+		}
+
+		if pvc.Status.Phase != corev1.ClaimBound {
+			return admission.Warnings{}, fmt.Errorf("pvc(%s) is not bound", ckpt.Spec.VolumeClaim.ClaimName)
+		}
+	} else if ckpt.Spec.AutoMigration {
+		return admission.Warnings{}, fmt.Errorf("pvc should be specified for auto migration in checkpoint(%s), because pod is migrated to another node", ckpt.Name)
+	} else if ckpt.Spec.Prestage != nil {
+		return admission.Warnings{}, fmt.Errorf("pvc should be specified for pre-staging in checkpoint(%s), because restoration pod is scheduled to the checkpointed node", ckpt.Name)
+	} else if ckpt.Spec.Streaming {
+		return admission.Warnings{}, fmt.Errorf("pvc should be specified for streaming in checkpoint(%s), because criu images are streamed into it", ckpt.Name)
 	}
 
-	if pvc.Status.Phase != corev1.ClaimBound {
-		return admission.Warnings{}, fmt.Errorf("pvc(%s) is not bound", ckpt.Spec.VolumeClaim.ClaimName)
+	// pvcs for taking volume snapshots should be used by the pod
+	if ckpt.Spec.VolumeSnapshot != nil {
+		if len(ckpt.Spec.VolumeSnapshot.ClaimNames) == 0 {
+			return admission.Warnings{}, fmt.Errorf("no pvc is specified for volume snapshot in checkpoint(%s)", ckpt.Name)
+		}
+		for _, claimName := range ckpt.Spec.VolumeSnapshot.ClaimNames {
+			if !isClaimUsedByPod(&pod, claimName) {
+				return admission.Warnings{}, fmt.Errorf("pvc(%s) for volume snapshot is not used by pod(%s)", claimName, pod.Name)
+			}
+		}
 	}
 
 	return admission.Warnings{}, nil
@@ -93,6 +113,16 @@ func isNodeReady(node *corev1.Node) bool {
 	return false
 }
 
+func isClaimUsedByPod(pod *corev1.Pod, claimName string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+	}
+
+	return false
+}
+
 // +kubebuilder:webhook:path=/validate-kaito-sh-v1alpha1-checkpoint,mutating=false,failurePolicy=fail,sideEffects=None,admissionReviewVersions=v1,groups="kaito.sh",resources=checkpoints,verbs=create,versions=v1alpha1,name=validating.checkpoints.kaito.sh
 // +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
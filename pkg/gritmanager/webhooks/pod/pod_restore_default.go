@@ -5,30 +5,49 @@ package pod
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"sort"
 
 	"github.com/samber/lo"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/util/retry"
 	controllerruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/kaito-project/grit/pkg/apis/v1alpha1"
 	"github.com/kaito-project/grit/pkg/gritmanager/agentmanager"
 	"github.com/kaito-project/grit/pkg/gritmanager/controllers/util"
+	"github.com/kaito-project/grit/pkg/util/volumesnapshot"
+)
+
+const (
+	// restoreCandidateIndex is the field index of restores which are waiting for restoration pod.
+	restoreCandidateIndex = "restoreCandidate"
+	// anyPodSpecIndexKey is the index key of restores which can match pods with any pod spec.
+	anyPodSpecIndexKey = "podspec/*"
 )
 
 type PodRestoreWebhook struct {
 	client.Client
+	apiReader    client.Reader
 	agentManager *agentmanager.AgentManager
 }
 
-func NewWebook(client client.Client, agentManager *agentmanager.AgentManager) *PodRestoreWebhook {
+func NewWebook(client client.Client, apiReader client.Reader, agentManager *agentmanager.AgentManager) *PodRestoreWebhook {
 	return &PodRestoreWebhook{
 		Client:       client,
+		apiReader:    apiReader,
 		agentManager: agentManager,
 	}
 }
@@ -45,81 +64,366 @@ func (w *PodRestoreWebhook) Default(ctx context.Context, obj runtime.Object) err
 		return nil
 	}
 
-	var restoreList v1alpha1.RestoreList
-	if err := w.List(ctx, &restoreList, &client.ListOptions{Namespace: pod.Namespace}); err != nil {
-		log.FromContext(ctx).Error(err, "failed to list restore resources", "namespace", pod.Namespace, "podName", pod.Name)
-		return nil //nolint:nilerr
+	restores, err := w.listCandidateRestores(ctx, pod)
+	if err != nil {
+		// the error is returned instead of skipping the pod, so the pod creation is retried by its owner
+		// rather than starting from scratch silently.
+		log.FromContext(ctx).Error(err, "failed to list candidate restores", "namespace", pod.Namespace, "podName", pod.Name)
+		return err
 	}
 
-	restores := lo.Filter(restoreList.Items, func(restore v1alpha1.Restore, _ int) bool {
-		if restore.Status.Phase != "" && restore.Status.Phase != v1alpha1.RestoreCreated {
-			return false
-		}
-		if restore.Annotations[v1alpha1.RestorationPodSelectedLabel] == "true" {
-			return false
-		}
-
-		return true
-	})
-
 	if len(restores) == 0 {
 		return nil
 	}
 
-	// check there is any Restore can matchi the pod(PodSpecHash and Owner Reference)
+	// check there is any Restore can match the pod(owner reference or selector, and pod spec compatibility)
 	var selectedRestore *v1alpha1.Restore
-	podSpecHash := util.ComputeHash(&pod.Spec)
+	var ckpt *v1alpha1.Checkpoint
+	var images map[string]string
+	var bindingID string
 	for i := range restores {
-		ownerRefIsMatch := false
-		for _, ownerRef := range pod.OwnerReferences {
-			if ownerRef.UID == restores[i].Spec.OwnerRef.UID &&
-				ownerRef.Kind == restores[i].Spec.OwnerRef.Kind &&
-				ownerRef.APIVersion == restores[i].Spec.OwnerRef.APIVersion {
-				ownerRefIsMatch = true
-				break
-			}
+		if matched, reason := matchRestorationPod(pod, &restores[i]); !matched {
+			log.FromContext(ctx).V(4).Info("pod is not matched by restore", "name", pod.Name, "restore name", restores[i].Name, "reason", reason)
+			continue
 		}
-		if !ownerRefIsMatch {
+
+		var candidate v1alpha1.Checkpoint
+		if err := w.Get(ctx, client.ObjectKey{Namespace: restores[i].Namespace, Name: restores[i].Spec.CheckpointName}, &candidate); err != nil {
+			log.FromContext(ctx).Error(err, "failed to get checkpoint for restore", "restore", restores[i].Name, "checkpoint", restores[i].Spec.CheckpointName)
 			continue
 		}
 
-		log.FromContext(ctx).Info("select pod for restore(owner reference is equal)", "name", pod.Name, "spec", pod.Spec, "restore name", restores[i].Name, "old pod spec hash", restores[i].Annotations[v1alpha1.PodSpecHashLabel], "new pod spec hash", podSpecHash)
-		if restores[i].Annotations[v1alpha1.PodSpecHashLabel] == podSpecHash {
-			selectedRestore = &restores[i]
-			break
+		if reasons := incompatibleReasons(pod, &restores[i], &candidate); len(reasons) != 0 {
+			log.FromContext(ctx).Info("pod is rejected for restore", "name", pod.Name, "restore name", restores[i].Name, "reasons", reasons)
+			continue
+		}
+
+		// rootfs diff in checkpoint is based on the image of checkpointed container, so restoration pod should
+		// use the same image. the match is rejected if the image can't be pinned.
+		pinned, err := pinnedContainerImages(pod, &restores[i], candidate.Status.ContainerImages)
+		if err != nil {
+			log.FromContext(ctx).Info("pod is rejected for restore", "name", pod.Name, "restore name", restores[i].Name, "reasons", []string{err.Error()})
+			continue
 		}
+
+		// pod name and uid maybe are empty in the pod create webhook, so a binding id is generated and
+		// recorded in annotations of both restore and pod. restore.Status.Binding is configured in restore
+		// controller according to the pod which has the same binding id.
+		bindingID = string(uuid.NewUUID())
+		// restore in FanOut mode binds every matching pod, so it's not claimed by the pod. restore is not
+		// claimed for dry-run requests either, because the pod is never created.
+		if restores[i].Spec.Mode != v1alpha1.RestoreModeFanOut && !isDryRun(ctx) {
+			claimed, err := w.claimRestore(ctx, &restores[i], bindingID)
+			if err != nil {
+				log.FromContext(ctx).Error(err, "failed to patch target pod mark for restore", "restore", restores[i].Name, "pod", pod.Name)
+				return err
+			} else if !claimed {
+				log.FromContext(ctx).Info("restore has been claimed by another pod", "name", pod.Name, "restore name", restores[i].Name)
+				continue
+			}
+		}
+
+		log.FromContext(ctx).Info("select pod for restore", "name", pod.Name, "restore name", restores[i].Name)
+		selectedRestore = &restores[i]
+		ckpt = &candidate
+		images = pinned
+		break
 	}
 
 	if selectedRestore == nil {
 		return nil
 	}
 
-	// there is a hack here for storing restoration pod name in restore:
-	// pod name maybe is empty in the pod create webhook, so we only mark
-	// restore annotation which specify a pod has already been selected by the restore.
-	// and restore.Status.TargetPod is configured in restore controller according to this mark.
-	patch := client.MergeFrom(selectedRestore.DeepCopy())
-	selectedRestore.Annotations[v1alpha1.RestorationPodSelectedLabel] = "true"
-	if err := w.Patch(ctx, selectedRestore, patch); err != nil {
-		log.FromContext(ctx).Error(err, "failed to patch target pod mark for restore", "restore", selectedRestore.Name, "pod", pod.Name)
-		return err
-	}
-
 	// add annotation for pod
 	if pod.Annotations == nil {
 		pod.Annotations = make(map[string]string)
 	}
 	pod.Annotations[v1alpha1.CheckpointDataPathLabel] = filepath.Join(w.agentManager.GetHostPath(), selectedRestore.Namespace, selectedRestore.Spec.CheckpointName)
 	pod.Annotations[v1alpha1.RestoreNameLabel] = selectedRestore.Name
+	pod.Annotations[v1alpha1.RestoreBindingLabel] = bindingID
+	// pvcs which have volume snapshots in checkpoint are replaced by the ones provisioned from snapshots,
+	// these pvcs are created by restore controller.
+	rewriteVolumeClaims(pod, selectedRestore, ckpt.Status.VolumeSnapshots)
+	// checkpointed data is kept on the node if cloud storage is not specified in checkpoint, so restoration
+	// pod should be scheduled to the same node unless the data can be pulled from the node by peer transfer.
+	if ckpt.Spec.VolumeClaim == nil && len(ckpt.Status.NodeName) != 0 && !w.agentManager.PeerTransferEnabled() {
+		pinToNode(pod, ckpt.Status.NodeName)
+	}
+	if err := pinImages(pod, images); err != nil {
+		return err
+	}
 	log.FromContext(ctx).Info("selected pod for restore successfully", "namespace", pod.Namespace, "pod name", pod.Name, "restore name", selectedRestore.Name)
 
 	return nil
 }
 
-// +kubebuilder:webhook:path=/mutate-core-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=None,admissionReviewVersions=v1,groups="",resources=pods,verbs=create,versions=v1,name=mutating.pods.k8s.io
-// +kubebuilder:rbac:groups=kaito.sh,resources=restores,verbs=patch
+// isDryRun checks whether the admission request is a dry-run request, objects other than the pod must not be
+// modified for it.
+func isDryRun(ctx context.Context) bool {
+	req, err := admission.RequestFromContext(ctx)
+	return err == nil && req.DryRun != nil && *req.DryRun
+}
+
+// listCandidateRestores looks up restores which are waiting for restoration pod from restore candidate index,
+// instead of listing all restores in the namespace. restores are looked up by owner uid of the pod and by
+// pod spec digest(or hash) of the pod, and restores which allow pod spec differences are always candidates.
+func (w *PodRestoreWebhook) listCandidateRestores(ctx context.Context, pod *corev1.Pod) ([]v1alpha1.Restore, error) {
+	keys := []string{
+		anyPodSpecIndexKey,
+		podSpecIndexKey(util.PodSpecDigest(util.PodSpecFieldDigests(&pod.Spec))),
+		podSpecIndexKey(util.ComputeHash(&pod.Spec)),
+	}
+	for _, ownerRef := range pod.OwnerReferences {
+		keys = append(keys, ownerIndexKey(ownerRef.UID))
+	}
+
+	var restores []v1alpha1.Restore
+	for _, key := range keys {
+		var restoreList v1alpha1.RestoreList
+		if err := w.List(ctx, &restoreList, client.InNamespace(pod.Namespace), client.MatchingFields{restoreCandidateIndex: key}); err != nil {
+			return nil, err
+		}
+		restores = append(restores, restoreList.Items...)
+	}
+
+	// the oldest restore is preferred when more than one restore can match the pod.
+	restores = lo.UniqBy(restores, func(restore v1alpha1.Restore) types.UID { return restore.UID })
+	sort.SliceStable(restores, func(i, j int) bool {
+		if !restores[i].CreationTimestamp.Equal(&restores[j].CreationTimestamp) {
+			return restores[i].CreationTimestamp.Before(&restores[j].CreationTimestamp)
+		}
+		return restores[i].Name < restores[j].Name
+	})
+	return restores, nil
+}
+
+// claimRestore marks the restore as selected with optimistic lock, so two pods which are created concurrently
+// can't claim the same restore. false is returned if the restore has been claimed by another pod.
+func (w *PodRestoreWebhook) claimRestore(ctx context.Context, restore *v1alpha1.Restore, bindingID string) (bool, error) {
+	claimed := true
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		patch := client.MergeFromWithOptions(restore.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if restore.Annotations == nil {
+			restore.Annotations = make(map[string]string)
+		}
+		restore.Annotations[v1alpha1.RestorationPodSelectedLabel] = bindingID
+		err := w.Patch(ctx, restore, patch)
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+
+		// restore in cache is stale, it may be updated by restore controller or claimed by another pod,
+		// so get the latest restore from api server and try again if it's still waiting for restoration pod.
+		if getErr := w.apiReader.Get(ctx, client.ObjectKeyFromObject(restore), restore); getErr != nil {
+			return getErr
+		}
+		if !isPendingSelection(restore) {
+			claimed = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return claimed, nil
+}
+
+// isPendingSelection checks whether the restore is still waiting for restoration pod. restore in Pending phase
+// without selected mark is included, because the binding has been released by restore controller and phase of
+// restore is going back to Created.
+func isPendingSelection(restore *v1alpha1.Restore) bool {
+	// restore in FanOut mode binds every matching pod until it's deleted.
+	if restore.Spec.Mode == v1alpha1.RestoreModeFanOut {
+		return restore.DeletionTimestamp.IsZero() && restore.Status.Phase != v1alpha1.RestoreFailed
+	}
+	if restore.Status.Phase != "" && restore.Status.Phase != v1alpha1.RestoreCreated &&
+		restore.Status.Phase != v1alpha1.RestorePending && restore.Status.Phase != v1alpha1.RestoreQueued {
+		return false
+	}
+	return len(restore.Annotations[v1alpha1.RestorationPodSelectedLabel]) == 0
+}
+
+// restoreCandidateKeys returns index keys of restores which are waiting for restoration pod. restore with
+// owner reference is keyed by owner uid, and restore with only selector is keyed by pod spec digest of
+// checkpointed pod(pod spec hash for checkpoints which have no digest). restore which allows pod spec
+// differences can't be keyed by pod spec, so it's keyed by anyPodSpecIndexKey.
+func restoreCandidateKeys(obj client.Object) []string {
+	restore, ok := obj.(*v1alpha1.Restore)
+	if !ok || !isPendingSelection(restore) {
+		return nil
+	}
+
+	switch {
+	case len(restore.Spec.OwnerRef.UID) != 0:
+		return []string{ownerIndexKey(restore.Spec.OwnerRef.UID)}
+	case len(allowedDifferences(restore)) != 0:
+		return []string{anyPodSpecIndexKey}
+	case len(restore.Annotations[v1alpha1.PodSpecDigestLabel]) != 0:
+		return []string{podSpecIndexKey(restore.Annotations[v1alpha1.PodSpecDigestLabel])}
+	default:
+		return []string{podSpecIndexKey(restore.Annotations[v1alpha1.PodSpecHashLabel])}
+	}
+}
+
+func ownerIndexKey(uid types.UID) string {
+	return "owner/" + string(uid)
+}
+
+func podSpecIndexKey(digest string) string {
+	return "podspec/" + digest
+}
+
+// matchRestorationPod checks whether the pod is selected by OwnerRef and Selector of restore. when both of
+// them are specified, the pod should match both. a restore without OwnerRef and Selector matches nothing.
+func matchRestorationPod(pod *corev1.Pod, restore *v1alpha1.Restore) (bool, string) {
+	hasOwnerRef := len(restore.Spec.OwnerRef.UID) != 0
+	if !hasOwnerRef && restore.Spec.Selector == nil {
+		return false, "neither owner reference nor selector is specified"
+	}
+
+	if hasOwnerRef {
+		_, found := lo.Find(pod.OwnerReferences, func(ownerRef metav1.OwnerReference) bool {
+			return ownerRef.UID == restore.Spec.OwnerRef.UID &&
+				ownerRef.Kind == restore.Spec.OwnerRef.Kind &&
+				ownerRef.APIVersion == restore.Spec.OwnerRef.APIVersion
+		})
+		if !found {
+			return false, "owner reference is not matched"
+		}
+	}
+
+	if restore.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(restore.Spec.Selector)
+		if err != nil {
+			return false, fmt.Sprintf("invalid selector, %v", err)
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			return false, fmt.Sprintf("labels of pod are not matched by selector(%s)", selector.String())
+		}
+	}
+	return true, ""
+}
+
+// incompatibleReasons returns the reasons why the pod can't be restored from checkpoint. pod spec hash is
+// compared for checkpoints which are created before pod spec field digests are recorded.
+func incompatibleReasons(pod *corev1.Pod, restore *v1alpha1.Restore, ckpt *v1alpha1.Checkpoint) []string {
+	if len(ckpt.Status.PodSpecFieldDigests) == 0 {
+		podSpecHash := util.ComputeHash(&pod.Spec)
+		if restore.Annotations[v1alpha1.PodSpecHashLabel] != podSpecHash {
+			return []string{fmt.Sprintf("pod spec hash(%s) is different from checkpointed pod spec hash(%s)", podSpecHash, restore.Annotations[v1alpha1.PodSpecHashLabel])}
+		}
+		return nil
+	}
+
+	return util.CheckPodSpecCompatibility(ckpt.Status.PodSpecFieldDigests, &pod.Spec, allowedDifferences(restore))
+}
+
+func allowedDifferences(restore *v1alpha1.Restore) []v1alpha1.PodSpecField {
+	if restore.Spec.CompatibilityPolicy == nil {
+		return nil
+	}
+	return restore.Spec.CompatibilityPolicy.AllowedDifferences
+}
+
+// pinnedContainerImages returns images pinned by digest for containers of restoration pod. error is returned
+// when the image of container is different from checkpointed container or it has no digest. nothing is pinned
+// for checkpoints which are created before container images are recorded, and the image which is allowed to
+// differ by compatibility policy is kept.
+func pinnedContainerImages(pod *corev1.Pod, restore *v1alpha1.Restore, images []v1alpha1.ContainerImage) (map[string]string, error) {
+	imageAllowed := lo.Contains(allowedDifferences(restore), v1alpha1.PodSpecFieldImage)
+	pinned := make(map[string]string)
+	containers := util.CheckpointedContainers(&pod.Spec)
+	for _, image := range images {
+		container, found := lo.Find(containers, func(c *corev1.Container) bool {
+			return c.Name == image.Name
+		})
+		if !found {
+			return nil, fmt.Errorf("container(%s) is not found in restoration pod", image.Name)
+		}
+
+		if !util.SameImage(container.Image, image) {
+			if imageAllowed {
+				continue
+			}
+			return nil, fmt.Errorf("image(%s) of container(%s) is different from checkpointed image(%s)", container.Image, container.Name, image.Image)
+		} else if len(image.ImageDigest) == 0 {
+			return nil, fmt.Errorf("image(%s) of container(%s) can't be pinned by digest", image.Image, image.Name)
+		}
+		pinned[image.Name] = image.ImageDigest
+	}
+	return pinned, nil
+}
+
+// pinImages replaces images of containers with the pinned ones, and records the original images in annotations.
+func pinImages(pod *corev1.Pod, images map[string]string) error {
+	originals := make(map[string]string)
+	for _, container := range util.CheckpointedContainers(&pod.Spec) {
+		if image, ok := images[container.Name]; ok && image != container.Image {
+			originals[container.Name] = container.Image
+			container.Image = image
+		}
+	}
+	if len(originals) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(originals)
+	if err != nil {
+		return err
+	}
+	pod.Annotations[v1alpha1.OriginalImagesAnnotation] = string(data)
+	return nil
+}
+
+func rewriteVolumeClaims(pod *corev1.Pod, restore *v1alpha1.Restore, snapshots []v1alpha1.VolumeSnapshotReference) {
+	for _, ref := range snapshots {
+		for i := range pod.Spec.Volumes {
+			pvc := pod.Spec.Volumes[i].PersistentVolumeClaim
+			if pvc != nil && pvc.ClaimName == ref.ClaimName {
+				pvc.ClaimName = volumesnapshot.RestoredClaimName(restore.Name, ref.ClaimName)
+			}
+		}
+	}
+}
+
+// +kubebuilder:webhook:path=/mutate-core-v1-pod,mutating=true,failurePolicy=ignore,sideEffects=NoneOnDryRun,admissionReviewVersions=v1,groups="",resources=pods,verbs=create,versions=v1,name=mutating.pods.k8s.io
+// +kubebuilder:rbac:groups=kaito.sh,resources=restores,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=kaito.sh,resources=checkpoints,verbs=get
+
+// pinToNode adds a required node affinity term for the node into every node selector term of the pod.
+func pinToNode(pod *corev1.Pod, nodeName string) {
+	requirement := corev1.NodeSelectorRequirement{
+		Key:      metav1.ObjectNameField,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{nodeName},
+	}
+
+	if pod.Spec.Affinity == nil {
+		pod.Spec.Affinity = &corev1.Affinity{}
+	}
+	if pod.Spec.Affinity.NodeAffinity == nil {
+		pod.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	nodeAffinity := pod.Spec.Affinity.NodeAffinity
+	if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	// node selector terms are ORed, so the requirement is added into each term.
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchFields = append(required.NodeSelectorTerms[i].MatchFields, requirement)
+	}
+}
+
+func (w *PodRestoreWebhook) Register(ctx context.Context, mgr manager.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &v1alpha1.Restore{}, restoreCandidateIndex, restoreCandidateKeys); err != nil {
+		return err
+	}
 
-func (w *PodRestoreWebhook) Register(_ context.Context, mgr manager.Manager) error {
 	return controllerruntime.NewWebhookManagedBy(mgr).
 		For(&corev1.Pod{}).
 		WithDefaulter(w).
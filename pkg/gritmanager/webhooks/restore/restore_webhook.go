@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/utils/clock"
 	controllerruntime "sigs.k8s.io/controller-runtime"
@@ -47,6 +48,10 @@ func (w *RestoreWebhook) Default(ctx context.Context, obj runtime.Object) error
 	}
 
 	restore.Annotations[v1alpha1.PodSpecHashLabel] = ckpt.Status.PodSpecHash
+	// pod spec digest is used by pod webhook for looking up restores which are compatible with restoration pod.
+	if len(ckpt.Status.PodSpecDigest) != 0 {
+		restore.Annotations[v1alpha1.PodSpecDigestLabel] = ckpt.Status.PodSpecDigest
+	}
 	return nil
 }
 
@@ -61,6 +66,24 @@ func (w *RestoreWebhook) ValidateCreate(ctx context.Context, obj runtime.Object)
 		return admission.Warnings{}, fmt.Errorf("checkpoint is not specified in restore(%s)", restore.Name)
 	}
 
+	// restoration pod is selected by owner reference or selector
+	if len(restore.Spec.OwnerRef.UID) == 0 && restore.Spec.Selector == nil {
+		return admission.Warnings{}, fmt.Errorf("neither ownerRef nor selector is specified in restore(%s)", restore.Name)
+	}
+	if restore.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(restore.Spec.Selector)
+		if err != nil {
+			return admission.Warnings{}, fmt.Errorf("invalid selector in restore(%s), %w", restore.Name, err)
+		} else if selector.Empty() {
+			return admission.Warnings{}, fmt.Errorf("selector in restore(%s) should not be empty", restore.Name)
+		}
+	}
+
+	// memory pages are served by criu page server for one restoration pod.
+	if restore.Spec.LazyPages && restore.Spec.Mode == v1alpha1.RestoreModeFanOut {
+		return admission.Warnings{}, fmt.Errorf("lazy pages is not supported by restore(%s) in FanOut mode", restore.Name)
+	}
+
 	var ckpt v1alpha1.Checkpoint
 	if err := w.Get(ctx, client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.CheckpointName}, &ckpt); err != nil {
 		return admission.Warnings{}, err
@@ -73,6 +96,11 @@ func (w *RestoreWebhook) ValidateCreate(ctx context.Context, obj runtime.Object)
 		return admission.Warnings{}, fmt.Errorf("restore(%s) referenced checkpoint(%s) has not completed checkpoint process", restore.Name, ckpt.Name)
 	}
 
+	// pvcs provisioned from volume snapshots can't be shared by many restoration pods.
+	if restore.Spec.Mode == v1alpha1.RestoreModeFanOut && len(ckpt.Status.VolumeSnapshots) != 0 {
+		return admission.Warnings{}, fmt.Errorf("checkpoint(%s) with volume snapshots is not supported by restore(%s) in FanOut mode", ckpt.Name, restore.Name)
+	}
+
 	return admission.Warnings{}, nil
 }
 
@@ -17,7 +17,7 @@ import (
 func NewWebhooks(mgr manager.Manager, clk clock.Clock, agentManager *agentmanager.AgentManager) []controller.Controller {
 
 	return []controller.Controller{
-		pod.NewWebook(mgr.GetClient(), agentManager),
+		pod.NewWebook(mgr.GetClient(), mgr.GetAPIReader(), agentManager),
 		checkpoint.NewCheckpointWebhook(clk, mgr.GetClient()),
 		restore.NewRestoreWebhook(clk, mgr.GetClient()),
 	}
@@ -0,0 +1,59 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ContainerLogIndexFile records where each log file of the container is located in ContainerLogFile.
+const ContainerLogIndexFile = "container-log.json"
+
+// ContainerLogSegment is a log file of the container which is concatenated into ContainerLogFile.
+type ContainerLogSegment struct {
+	// Source is the name of log file in the kubelet log directory, like 0.log.20250101-000000.gz.
+	Source       string `json:"source"`
+	RestartCount int    `json:"restartCount"`
+	// Compressed is true if the source file is compressed by kubelet log rotation, the content
+	// in ContainerLogFile is always decompressed.
+	Compressed bool  `json:"compressed,omitempty"`
+	Offset     int64 `json:"offset"`
+	Size       int64 `json:"size"`
+}
+
+// ContainerLogIndex lists log segments in the order of restart count and rotation time.
+type ContainerLogIndex struct {
+	Segments []ContainerLogSegment `json:"segments"`
+}
+
+func WriteContainerLogIndex(dir string, index *ContainerLogIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ContainerLogIndexFile), data, 0644)
+}
+
+func ReadContainerLogIndex(dir string) (*ContainerLogIndex, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ContainerLogIndexFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var index ContainerLogIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse container log index: %w", err)
+	}
+	return &index, nil
+}
+
+// MigrationMarker returns a log line in CRI log format, it's appended after the restored log
+// history so users can find the migration point with kubectl logs.
+func MigrationMarker(now time.Time, checkpointPath string) string {
+	return fmt.Sprintf("%s stdout F [grit] container is restored from checkpoint %s\n", now.UTC().Format(time.RFC3339Nano), checkpointPath)
+}
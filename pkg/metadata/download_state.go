@@ -0,0 +1,117 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DownloadStateVersion is the version of download state protocol, it should be increased
+	// when incompatible changes are made to DownloadState.
+	DownloadStateVersion = "v1"
+)
+
+type DownloadPhase string
+
+const (
+	DownloadPhaseDownloading DownloadPhase = "Downloading"
+	DownloadPhaseVerifying   DownloadPhase = "Verifying"
+	DownloadPhaseReady       DownloadPhase = "Ready"
+	DownloadPhaseFailed      DownloadPhase = "Failed"
+	// DownloadPhaseMetadataReady means all checkpointed data except memory pages has been downloaded for lazy
+	// restore, restoration can be started and memory pages are served on demand by page servers in PageServers.
+	DownloadPhaseMetadataReady DownloadPhase = "MetadataReady"
+)
+
+// DownloadState is written into DownloadSentinelFile by grit-agent while checkpointed data is
+// downloaded to the node, the containerd interceptor and shim read it to decide whether the
+// restoration can be continued.
+type DownloadState struct {
+	Version string        `json:"version"`
+	Phase   DownloadPhase `json:"phase"`
+	// Reason is a brief CamelCase string which describes why download is failed.
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	// PageServers maps criu image directory(relative to the download dir) to the unix socket which is tunneled
+	// to criu page server serving memory pages of it, it's only set for lazy restore.
+	PageServers map[string]string `json:"pageServers,omitempty"`
+	// TotalBytes is zero when the size of checkpointed data is unknown.
+	TotalBytes       int64     `json:"totalBytes,omitempty"`
+	TransferredBytes int64     `json:"transferredBytes,omitempty"`
+	UpdateTime       time.Time `json:"updateTime"`
+}
+
+func (s *DownloadState) IsCompleted() bool {
+	return s.Phase == DownloadPhaseReady || s.Phase == DownloadPhaseFailed
+}
+
+// IsRestorable checks whether restoration can be started with the downloaded data, restoration is started
+// before memory pages are downloaded for lazy restore.
+func (s *DownloadState) IsRestorable() bool {
+	return s.Phase == DownloadPhaseReady || s.Phase == DownloadPhaseMetadataReady
+}
+
+// Error returns a readable error for failed download state.
+func (s *DownloadState) Error() error {
+	if s.Phase != DownloadPhaseFailed {
+		return nil
+	}
+	return fmt.Errorf("download checkpointed data failed, reason: %s, message: %s", s.Reason, s.Message)
+}
+
+// WriteDownloadState writes download state into dir atomically, so readers never see a partial file.
+func WriteDownloadState(dir string, state *DownloadState) error {
+	state.Version = DownloadStateVersion
+	state.UpdateTime = time.Now().UTC()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(dir, "."+DownloadSentinelFile+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filepath.Join(dir, DownloadSentinelFile))
+}
+
+// ReadDownloadState reads download state from dir. the error satisfies os.IsNotExist if download
+// state has not been written. sentinel file written by previous grit-agent is regarded as ready.
+func ReadDownloadState(dir string) (*DownloadState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, DownloadSentinelFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var state DownloadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		if strings.TrimSpace(string(data)) == legacySentinelContent {
+			return &DownloadState{Phase: DownloadPhaseReady}, nil
+		}
+		return nil, fmt.Errorf("failed to parse download state: %w", err)
+	}
+
+	if state.Version != DownloadStateVersion {
+		return nil, fmt.Errorf("unsupported download state version %q", state.Version)
+	}
+	return &state, nil
+}
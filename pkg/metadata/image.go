@@ -0,0 +1,76 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ImageFile is written into container checkpoint directory, it records the image which the
+	// rootfs diff of the container is based on.
+	ImageFile = "image.json"
+
+	// dockerPullablePrefix is added to image id of container status by dockershim and cri-dockerd.
+	dockerPullablePrefix = "docker-pullable://"
+)
+
+type ContainerImage struct {
+	// Image is the image specified by the user, like nginx:latest.
+	Image string `json:"image"`
+	// ImageRef is the image reference which is resolved by container runtime, it's a reference
+	// pinned by digest(like docker.io/library/nginx@sha256:xxx) if the image is pulled from registry.
+	ImageRef string `json:"imageRef"`
+}
+
+// Digest returns the digest which the image is pinned to, empty string is returned if the image
+// can't be pulled by digest, like images which are imported into container runtime directly.
+func (i *ContainerImage) Digest() string {
+	return ImageDigest(i.ImageRef)
+}
+
+// ImageDigest returns the digest part of image reference, like sha256:xxx for nginx@sha256:xxx.
+func ImageDigest(ref string) string {
+	if idx := strings.LastIndex(ref, "@"); idx >= 0 {
+		return ref[idx+1:]
+	}
+	return ""
+}
+
+// DigestReference returns the image reference pinned by digest from image id in container status,
+// empty string is returned if image id doesn't contain a repository digest, like sha256:xxx.
+func DigestReference(imageID string) string {
+	ref := strings.TrimPrefix(imageID, dockerPullablePrefix)
+	if idx := strings.LastIndex(ref, "@"); idx <= 0 || len(ref[idx+1:]) == 0 {
+		return ""
+	}
+	return ref
+}
+
+func WriteContainerImage(dir string, image *ContainerImage) error {
+	data, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ImageFile), data, 0644)
+}
+
+// ReadContainerImage reads image metadata from container checkpoint directory, the error satisfies
+// os.IsNotExist for checkpoints which are created before image metadata is introduced.
+func ReadContainerImage(dir string) (*ContainerImage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ImageFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var image ContainerImage
+	if err := json.Unmarshal(data, &image); err != nil {
+		return nil, fmt.Errorf("failed to parse container image: %w", err)
+	}
+	return &image, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// ImageStreamerBinary is used for dumping criu images into storage directly and restoring from it,
+	// criu connects to the unix sockets created by it when criu runs in stream mode.
+	ImageStreamerBinary = "criu-image-streamer"
+	// ImagesStreamFile is the stream of criu images captured by criu-image-streamer, it's placed in the
+	// checkpoint directory of the container next to the criu image directory.
+	ImagesStreamFile = "images.stream"
+	// StreamerCaptureSocket and StreamerServeSocket are created by criu-image-streamer in criu image directory.
+	StreamerCaptureSocket = "streamer-capture.sock"
+	StreamerServeSocket   = "streamer-serve.sock"
+)
+
+// ImagesStreamPath returns the path of images stream for the container checkpoint directory.
+func ImagesStreamPath(containerCheckpointDir string) string {
+	return filepath.Join(containerCheckpointDir, ImagesStreamFile)
+}
+
+// WaitForStreamerSocket waits for the unix socket of criu-image-streamer to be created, criu fails
+// immediately if it can't connect to the socket.
+func WaitForStreamerSocket(ctx context.Context, socketPath string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(socketPath); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("criu-image-streamer socket %s is not created: %w", socketPath, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestFile records all files of checkpointed data, it's used for verifying downloaded data.
+const ManifestFile = "manifest.json"
+
+type ManifestEntry struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex encoded sha256 digest of the file, it's empty in manifests of earlier versions.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+func (m *Manifest) TotalBytes() int64 {
+	var total int64
+	for _, f := range m.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// PageImages splits files in manifest into memory page images(pages-*.img) of criu and the others, memory
+// pages can be served on demand by criu page server for lazy restore, and the others are required before restoring.
+func (m *Manifest) PageImages() (pages, others []ManifestEntry) {
+	for _, f := range m.Files {
+		if IsPageImage(f.Path) {
+			pages = append(pages, f)
+		} else {
+			others = append(others, f)
+		}
+	}
+	return pages, others
+}
+
+// IsPageImage checks whether the file is a memory page image of criu.
+func IsPageImage(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, "pages-") && strings.HasSuffix(name, ".img")
+}
+
+// GenerateManifest walks through dir and records size and digest of regular files except files of download protocol.
+func GenerateManifest(dir string) (*Manifest, error) {
+	manifest := &Manifest{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if relPath == ManifestFile || relPath == DownloadSentinelFile {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		digest, err := fileDigest(path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestEntry{Path: relPath, Size: info.Size(), SHA256: digest})
+		return nil
+	})
+	return manifest, err
+}
+
+func WriteManifest(dir string, manifest *Manifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), data, 0644)
+}
+
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// VerifyManifest checks all files in manifest exist in dir with the recorded size and digest.
+func VerifyManifest(dir string, manifest *Manifest) error {
+	return VerifyFiles(dir, manifest.Files)
+}
+
+// VerifyFiles checks files exist in dir with the recorded size and digest, the size is checked first
+// so truncated files are rejected without reading them.
+func VerifyFiles(dir string, files []ManifestEntry) error {
+	for _, f := range files {
+		path := filepath.Join(dir, f.Path)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.Size() != f.Size {
+			return fmt.Errorf("size of file %s is %d, expected %d", f.Path, info.Size(), f.Size)
+		}
+		if len(f.SHA256) == 0 {
+			continue
+		}
+		digest, err := fileDigest(path)
+		if err != nil {
+			return err
+		}
+		if digest != f.SHA256 {
+			return fmt.Errorf("sha256 of file %s is %s, expected %s", f.Path, digest, f.SHA256)
+		}
+	}
+	return nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
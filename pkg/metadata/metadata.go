@@ -7,4 +7,7 @@ package metadata
 const (
 	ContainerLogFile     = "container.log"
 	DownloadSentinelFile = "download-state"
+
+	// legacySentinelContent is written into DownloadSentinelFile by grit-agent before download state is introduced.
+	legacySentinelContent = "Transfer Completed"
 )
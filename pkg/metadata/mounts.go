@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MountsFile is written into criu image directory, it records the external mounts of the container.
+	MountsFile = "mounts.json"
+
+	kubeletPodsPathSegment = "/pods/"
+)
+
+// MountEntry is a pod volume mount which is registered as criu external mount.
+type MountEntry struct {
+	// Key is the key of external mount in criu images.
+	Key string `json:"key"`
+	// VolumeKey identifies the pod volume, like kubernetes.io~configmap/config.
+	VolumeKey   string `json:"volumeKey"`
+	Destination string `json:"destination"`
+	// Source is the mount source on the checkpointed node, it's only used for troubleshooting.
+	Source string `json:"source"`
+}
+
+type MountsManifest struct {
+	Mounts []MountEntry `json:"mounts"`
+}
+
+// VolumeKey returns a key which identifies the pod volume of the mount source regardless of pod uid,
+// empty string is returned if source is not a volume managed by kubelet. sources are like:
+//   - <kubelet root>/pods/<uid>/volumes/<plugin>/<volume name>
+//   - <kubelet root>/pods/<uid>/volume-subpaths/<volume name>/<container name>/<index>
+//   - <kubelet root>/pods/<uid>/etc-hosts
+//   - <kubelet root>/pods/<uid>/containers/<container name>/<id>
+func VolumeKey(source string) string {
+	idx := strings.LastIndex(source, kubeletPodsPathSegment)
+	if idx < 0 {
+		return ""
+	}
+
+	// strip pod uid
+	parts := strings.SplitN(source[idx+len(kubeletPodsPathSegment):], "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return ""
+	}
+
+	rest := strings.Split(parts[1], "/")
+	switch {
+	case rest[0] == "volumes" && len(rest) == 3:
+		return rest[1] + "/" + rest[2]
+	case rest[0] == "volume-subpaths" && len(rest) == 4:
+		return "subpath/" + rest[1] + "/" + rest[3]
+	case rest[0] == "etc-hosts" && len(rest) == 1:
+		return rest[0]
+	case rest[0] == "containers" && len(rest) == 3:
+		// termination message path, id changes across restarts.
+		return "termination-log/" + rest[1]
+	}
+	return ""
+}
+
+// IsPersistentVolumeSource checks whether the mount source is the directory of the persistent volume, sources are
+// like <kubelet root>/pods/<uid>/volumes/kubernetes.io~csi/<pv name>/mount.
+func IsPersistentVolumeSource(source, volumeName string) bool {
+	idx := strings.LastIndex(source, kubeletPodsPathSegment)
+	if idx < 0 || len(volumeName) == 0 {
+		return false
+	}
+
+	rest := strings.Split(source[idx+len(kubeletPodsPathSegment):], "/")
+	return len(rest) >= 4 && rest[1] == "volumes" && rest[3] == volumeName
+}
+
+func WriteMountsManifest(dir string, manifest *MountsManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, MountsFile), data, 0644)
+}
+
+// ReadMountsManifest reads mounts manifest from criu image directory, the error satisfies
+// os.IsNotExist for checkpoints which are created before mounts manifest is introduced.
+func ReadMountsManifest(dir string) (*MountsManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MountsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest MountsManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse mounts manifest: %w", err)
+	}
+	return &manifest, nil
+}
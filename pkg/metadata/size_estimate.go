@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SizeEstimateFile is written into pod checkpoint directory before the pod is paused, it records the estimated
+// size of checkpointed data.
+const SizeEstimateFile = "size-estimate.json"
+
+// ErrInsufficientDiskSpace is returned when there is not enough free space for checkpointed data, checkpoint is
+// refused before the pod is paused. error messages of checkpoint start with it.
+var ErrInsufficientDiskSpace = errors.New("insufficient disk space")
+
+// SizeEstimate is the estimated size of checkpointed data of the pod.
+type SizeEstimate struct {
+	// MemoryBytes is the size of memory which is dumped into criu images, it's estimated by anonymous and
+	// shared memory in cgroup memory stats of containers.
+	MemoryBytes int64 `json:"memoryBytes"`
+	// RootfsDiffBytes is the size of writable layers of containers.
+	RootfsDiffBytes int64 `json:"rootfsDiffBytes"`
+	// VolumeBytes is the size of emptyDir volumes and /dev/shm of the pod which are archived.
+	VolumeBytes int64 `json:"volumeBytes,omitempty"`
+}
+
+// Total returns the estimated size of all checkpointed data.
+func (e *SizeEstimate) Total() int64 {
+	return e.MemoryBytes + e.RootfsDiffBytes + e.VolumeBytes
+}
+
+func WriteSizeEstimate(dir string, estimate *SizeEstimate) error {
+	data, err := json.Marshal(estimate)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, SizeEstimateFile), data, 0644)
+}
+
+// ReadSizeEstimate reads estimated size from pod checkpoint directory, the error satisfies os.IsNotExist if
+// the size is not estimated.
+func ReadSizeEstimate(dir string) (*SizeEstimate, error) {
+	data, err := os.ReadFile(filepath.Join(dir, SizeEstimateFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var estimate SizeEstimate
+	if err := json.Unmarshal(data, &estimate); err != nil {
+		return nil, fmt.Errorf("failed to parse size estimate: %w", err)
+	}
+	return &estimate, nil
+}
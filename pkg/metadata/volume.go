@@ -0,0 +1,52 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// VolumesDirectory is the directory in pod checkpoint which stores archived volumes.
+	VolumesDirectory = "volumes"
+	// ShmVolumeName is used for the /dev/shm of the pod sandbox which is not an emptyDir volume.
+	ShmVolumeName = "dev-shm"
+
+	ShmMountDestination = "/dev/shm"
+	emptyDirPathSegment = "/volumes/kubernetes.io~empty-dir/"
+)
+
+// ArchivedVolumeName returns the name of pod volume which should be archived into checkpoint
+// for the container mount. emptyDir volumes are located by kubelet path, and /dev/shm which
+// is provided by the pod sandbox is archived as ShmVolumeName. empty string is returned for
+// other mounts.
+func ArchivedVolumeName(destination, source string) string {
+	if idx := strings.Index(source, emptyDirPathSegment); idx >= 0 {
+		name := source[idx+len(emptyDirPathSegment):]
+		if len(name) != 0 && !strings.Contains(name, "/") {
+			return name
+		}
+		return ""
+	}
+
+	if filepath.Clean(destination) == ShmMountDestination && len(source) != 0 && filepath.IsAbs(source) {
+		return ShmVolumeName
+	}
+	return ""
+}
+
+// VolumeArchivePath returns the path of archived volume in pod checkpoint.
+func VolumeArchivePath(checkpointDir, name string) string {
+	return filepath.Join(checkpointDir, VolumesDirectory, name+".tar")
+}
+
+// VolumeRestoredMarkerPath returns the marker which records the archived volume has been restored into the mount
+// source. it's keyed by the mount source, so pods restored from the same checkpoint on the node don't share it.
+func VolumeRestoredMarkerPath(checkpointDir, source string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(source)))
+	return filepath.Join(checkpointDir, VolumesDirectory, ".restored", hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package archive
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func writeArchive(t *testing.T, entries []entry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "volume.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create archive, %v", err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write header, %v", err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("failed to write content, %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close archive, %v", err)
+	}
+	return path
+}
+
+func TestUntarFile(t *testing.T) {
+	t.Run("regular files and symlinks in volume", func(t *testing.T) {
+		dstDir := t.TempDir()
+		path := writeArchive(t, []entry{
+			{name: "data", typeflag: tar.TypeDir},
+			{name: "data/file", typeflag: tar.TypeReg, content: "hello"},
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "data/file"},
+		})
+		if err := UntarFile(path, dstDir); err != nil {
+			t.Fatalf("expected archive is extracted, but got %v", err)
+		}
+		if content, err := os.ReadFile(filepath.Join(dstDir, "link")); err != nil || string(content) != "hello" {
+			t.Fatalf("expected symlink points to extracted file, but got %q, %v", content, err)
+		}
+	})
+
+	t.Run("symlinks outside of volume are rejected", func(t *testing.T) {
+		for _, linkname := range []string{"/etc", "../outside", "data/../../outside"} {
+			path := writeArchive(t, []entry{{name: "link", typeflag: tar.TypeSymlink, linkname: linkname}})
+			if err := UntarFile(path, t.TempDir()); err == nil {
+				t.Fatalf("expected symlink to %s is rejected", linkname)
+			}
+		}
+	})
+
+	t.Run("entries are not written through symlinks", func(t *testing.T) {
+		dstDir := t.TempDir()
+		path := writeArchive(t, []entry{
+			{name: "data", typeflag: tar.TypeDir},
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "data"},
+			{name: "link/file", typeflag: tar.TypeReg, content: "hello"},
+		})
+		if err := UntarFile(path, dstDir); err == nil {
+			t.Fatalf("expected entry under symlink is rejected")
+		}
+		if _, err := os.Stat(filepath.Join(dstDir, "data", "file")); !os.IsNotExist(err) {
+			t.Fatalf("expected file is not written through symlink, but got %v", err)
+		}
+	})
+}
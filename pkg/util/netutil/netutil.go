@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package netutil provides utilities for handing connections of criu page server over between processes.
+package netutil
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// connPairTimeout is the max duration for establishing a loopback connection pair.
+const connPairTimeout = 10 * time.Second
+
+// LoopbackConnPair returns a pair of connected tcp connections on the loopback address. criu only accepts a tcp
+// socket for page server, so one end is passed to criu as an inherited fd instead of letting criu listen on a
+// loopback port, which can be connected by any process in host network. the listener is only used for
+// establishing the pair, and connections from other processes are rejected by checking the address of the peer.
+func LoopbackConnPair() (*net.TCPConn, *net.TCPConn, error) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer l.Close()
+	if err := l.SetDeadline(time.Now().Add(connPairTimeout)); err != nil {
+		return nil, nil, err
+	}
+
+	dialed, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		accepted, err := l.AcceptTCP()
+		if err != nil {
+			dialed.Close()
+			return nil, nil, err
+		}
+		if accepted.RemoteAddr().String() == dialed.LocalAddr().String() {
+			return dialed, accepted, nil
+		}
+		accepted.Close()
+	}
+}
+
+// ListenUnix listens on the unix socket at path which can only be connected by the owner, the directory of the
+// socket is created with mode 0700, so the socket is never accessible by others even before its mode is changed.
+func ListenUnix(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Tunnel copies data between two connections in both directions, and both connections are closed when
+// either side is closed.
+func Tunnel(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	copyData := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyData(a, b)
+	go copyData(b, a)
+
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+package netutil
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoopbackConnPair(t *testing.T) {
+	a, b, err := LoopbackConnPair()
+	if err != nil {
+		t.Fatalf("failed to create loopback connection pair, %v", err)
+	}
+	defer a.Close()
+	defer b.Close()
+
+	if a.LocalAddr().String() != b.RemoteAddr().String() || a.RemoteAddr().String() != b.LocalAddr().String() {
+		t.Fatalf("expected connections are connected to each other, got %s->%s and %s->%s", a.LocalAddr(), a.RemoteAddr(), b.LocalAddr(), b.RemoteAddr())
+	}
+	go a.Write([]byte("pages"))
+	buf := make([]byte, len("pages"))
+	if _, err := io.ReadFull(b, buf); err != nil || string(buf) != "pages" {
+		t.Fatalf("expected data is transferred through the pair, but got %q, %v", buf, err)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "page-servers", "0.sock")
+	// stale socket of previous restore is replaced.
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, nil, 0644)
+
+	l, err := ListenUnix(path)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket, %v", err)
+	}
+	defer l.Close()
+
+	for file, mode := range map[string]os.FileMode{filepath.Dir(path): 0700, path: 0600} {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("failed to stat %s, %v", file, err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Fatalf("expected mode of %s is %o, got %o", file, mode, info.Mode().Perm())
+		}
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect unix socket, %v", err)
+	}
+	conn.Close()
+}
+
+func TestTunnel(t *testing.T) {
+	peerConn, peerEnd := net.Pipe()
+	pageServerConn, pageServerEnd := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		Tunnel(peerConn, pageServerConn)
+		close(done)
+	}()
+
+	go peerEnd.Write([]byte("request"))
+	buf := make([]byte, len("request"))
+	if _, err := io.ReadFull(pageServerEnd, buf); err != nil || string(buf) != "request" {
+		t.Fatalf("expected request is forwarded to page server, but got %q, %v", buf, err)
+	}
+
+	go pageServerEnd.Write([]byte("pages"))
+	buf = make([]byte, len("pages"))
+	if _, err := io.ReadFull(peerEnd, buf); err != nil || string(buf) != "pages" {
+		t.Fatalf("expected pages are forwarded to peer, but got %q, %v", buf, err)
+	}
+
+	// tunnel is closed when page server exits.
+	pageServerEnd.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected tunnel is closed after page server exits")
+	}
+	if _, err := peerEnd.Read(buf); err == nil {
+		t.Fatalf("expected peer connection is closed")
+	}
+}
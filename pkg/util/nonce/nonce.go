@@ -0,0 +1,50 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package nonce provides utilities for rejecting replayed requests which are signed with a shared secret.
+package nonce
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// New generates a random nonce for a signed request.
+func New() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Cache remembers the nonces of accepted requests until their signatures are expired, so a request can't be
+// replayed within the lifetime of its signature.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string]time.Time)}
+}
+
+// Add records the nonce which is valid until expires, it returns false if the nonce has been used already.
+func (c *Cache) Add(nonce string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for n, e := range c.entries {
+		if e.Before(now) {
+			delete(c.entries, n)
+		}
+	}
+
+	if _, ok := c.entries[nonce]; ok {
+		return false
+	}
+	c.entries[nonce] = expires
+	return true
+}
@@ -0,0 +1,89 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT license.
+
+// Package volumesnapshot provides helpers for CSI VolumeSnapshot resources. VolumeSnapshot
+// is handled as unstructured object, so grit doesn't depend on the external-snapshotter client.
+package volumesnapshot
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	GroupName = "snapshot.storage.k8s.io"
+	Kind      = "VolumeSnapshot"
+
+	// CheckpointLabel is used for recording the Checkpoint which VolumeSnapshot is taken for.
+	CheckpointLabel = "grit.dev/checkpoint-name"
+)
+
+var GroupVersionKind = schema.GroupVersionKind{Group: GroupName, Version: "v1", Kind: Kind}
+
+// Name returns the name of VolumeSnapshot which is taken for the pvc of checkpointed pod.
+func Name(checkpointName, claimName string) string {
+	return fmt.Sprintf("%s-%s", checkpointName, claimName)
+}
+
+// RestoredClaimName returns the name of pvc which is provisioned from VolumeSnapshot for restoration pod.
+func RestoredClaimName(restoreName, claimName string) string {
+	return fmt.Sprintf("%s-%s", restoreName, claimName)
+}
+
+// New returns an empty VolumeSnapshot object, it can be used for getting VolumeSnapshot from kube-apiserver.
+func New() *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(GroupVersionKind)
+	return obj
+}
+
+// NewForClaim returns a VolumeSnapshot object which takes a snapshot of the specified pvc.
+func NewForClaim(namespace, checkpointName, claimName, className string) *unstructured.Unstructured {
+	obj := New()
+	obj.SetNamespace(namespace)
+	obj.SetName(Name(checkpointName, claimName))
+	obj.SetLabels(map[string]string{CheckpointLabel: checkpointName})
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": claimName,
+		},
+	}
+	if len(className) != 0 {
+		spec["volumeSnapshotClassName"] = className
+	}
+	obj.Object["spec"] = spec
+	return obj
+}
+
+// IsTaken returns true when the snapshot has been cut by the csi driver. creationTime is set
+// as soon as the snapshot is taken, data of snapshot may still be uploading in the background.
+func IsTaken(obj *unstructured.Unstructured) bool {
+	creationTime, _, _ := unstructured.NestedString(obj.Object, "status", "creationTime")
+	return len(creationTime) != 0
+}
+
+// IsReady returns true when the snapshot can be used for provisioning a new pvc.
+func IsReady(obj *unstructured.Unstructured) bool {
+	ready, _, _ := unstructured.NestedBool(obj.Object, "status", "readyToUse")
+	return ready
+}
+
+// Error returns the error message of VolumeSnapshot if taking snapshot failed.
+func Error(obj *unstructured.Unstructured) string {
+	message, _, _ := unstructured.NestedString(obj.Object, "status", "error", "message")
+	return message
+}
+
+// DataSource returns the data source of pvc which is provisioned from the VolumeSnapshot.
+func DataSource(snapshotName string) *corev1.TypedLocalObjectReference {
+	apiGroup := GroupName
+	return &corev1.TypedLocalObjectReference{
+		APIGroup: &apiGroup,
+		Kind:     Kind,
+		Name:     snapshotName,
+	}
+}